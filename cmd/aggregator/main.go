@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/aggregator"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/httpserver"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+)
+
+var (
+	listen       = flag.String("listen", ":9401", "Address serving /capacity and /metrics, or a unix socket path prefixed by \"unix:\"")
+	tlsCertFile  = flag.String("tls-cert-file", "", "TLS certificate serving /capacity and /metrics, reloaded when it changes")
+	tlsKeyFile   = flag.String("tls-key-file", "", "TLS private key serving /capacity and /metrics")
+	kubeconfig   = flag.String("kubeconfig", "", "Path to a kubeconfig, only required when running out of cluster")
+	nodeSelector = flag.String("node-selector", "", "Label selector of the GPU nodes")
+	interval     = flag.Duration("interval", 30*time.Second, "Interval between two capacity refreshes")
+)
+
+func main() {
+	flag.Parse()
+	log.Println("Start virtual GPU capacity aggregator")
+
+	client, err := kube.NewClient(*kubeconfig)
+	if err != nil {
+		log.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	a := aggregator.New(client, *nodeSelector)
+	go a.Run(*interval, make(chan struct{}))
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(httpserver.Serve(*listen, *tlsCertFile, *tlsKeyFile, a.Handler()))
+}
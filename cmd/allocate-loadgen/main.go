@@ -0,0 +1,164 @@
+// Command allocate-loadgen issues Allocate calls to a running device plugin
+// the way kubelet does and reports their latency. It exits with an error when
+// the latency exceeds the given thresholds, so that CI can catch regressions
+// of the allocation path.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var (
+	socket      = flag.String("socket", pluginapi.DevicePluginPath+"hkube-vgpu.sock", "Device plugin socket")
+	requests    = flag.Int("requests", 500, "Number of Allocate calls")
+	concurrency = flag.Int("concurrency", 8, "Number of concurrent Allocate calls")
+	containers  = flag.Int("containers", 1, "Number of containers in every Allocate call")
+	devices     = flag.Int("devices", 10, "Number of virtual GPUs requested by every container")
+	timeout     = flag.Duration("timeout", 10*time.Second, "Timeout of every Allocate call, as set by kubelet")
+	maxP50      = flag.Duration("max-p50", 0, "Fail when the median latency exceeds this duration, 0 disables the check")
+	maxP99      = flag.Duration("max-p99", 0, "Fail when the 99th percentile latency exceeds this duration, 0 disables the check")
+)
+
+func dial(ctx context.Context, path string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, path, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+}
+
+// listDevices returns the IDs of the healthy devices advertised by the plugin.
+func listDevices(client pluginapi.DevicePluginClient) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, d := range resp.Devices {
+		if d.Health == pluginapi.Healthy {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids, nil
+}
+
+// newRequest picks random devices for every container.
+func newRequest(ids []string) *pluginapi.AllocateRequest {
+	req := &pluginapi.AllocateRequest{}
+	for c := 0; c < *containers; c++ {
+		perm := rand.Perm(len(ids))[:*devices]
+		creq := &pluginapi.ContainerAllocateRequest{}
+		for _, i := range perm {
+			creq.DevicesIDs = append(creq.DevicesIDs, ids[i])
+		}
+		req.ContainerRequests = append(req.ContainerRequests, creq)
+	}
+	return req
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	conn, err := dial(ctx, *socket)
+	cancel()
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *socket, err)
+	}
+	defer conn.Close()
+
+	client := pluginapi.NewDevicePluginClient(conn)
+	ids, err := listDevices(client)
+	if err != nil {
+		log.Fatalf("Failed to list devices: %v", err)
+	}
+	if len(ids) < *devices {
+		log.Fatalf("%d healthy devices advertised, %d requested per container", len(ids), *devices)
+	}
+	log.Printf("Issuing %d Allocate calls of %d containers x %d devices, %d at a time", *requests, *containers, *devices, *concurrency)
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+		wg        sync.WaitGroup
+	)
+	work := make(chan *pluginapi.AllocateRequest)
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range work {
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				start := time.Now()
+				_, err := client.Allocate(ctx, req)
+				elapsed := time.Since(start)
+				cancel()
+
+				mu.Lock()
+				if err != nil {
+					failures++
+					log.Printf("Allocate failed: %v", err)
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; i < *requests; i++ {
+		work <- newRequest(ids)
+	}
+	close(work)
+	wg.Wait()
+	total := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p50, p99 := percentile(latencies, 0.5), percentile(latencies, 0.99)
+	fmt.Printf("calls=%d failures=%d duration=%s rate=%.1f/s p50=%s p90=%s p99=%s max=%s\n",
+		len(latencies)+failures, failures, total, float64(*requests)/total.Seconds(),
+		p50, percentile(latencies, 0.9), p99, percentile(latencies, 1))
+
+	failed := failures > 0
+	if *maxP50 > 0 && p50 > *maxP50 {
+		log.Printf("Median latency %s exceeds %s", p50, *maxP50)
+		failed = true
+	}
+	if *maxP99 > 0 && p99 > *maxP99 {
+		log.Printf("99th percentile latency %s exceeds %s", p99, *maxP99)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/audit"
+)
+
+var (
+	auditLog = flag.String("audit-log", "", "Path of the allocation audit log written by the device plugin")
+	keyFile  = flag.String("signing-key", "", "Path of the node key the audit log was signed with")
+)
+
+func main() {
+	flag.Parse()
+	if *auditLog == "" || *keyFile == "" {
+		log.Fatal("Both --audit-log and --signing-key are required")
+	}
+
+	key, err := audit.LoadKey(*keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+
+	f, err := os.Open(*auditLog)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	count, err := audit.Verify(f, key)
+	if err != nil {
+		log.Fatalf("Audit log %s does not verify after %d records: %v", *auditLog, count, err)
+	}
+	log.Printf("Audit log %s verified, %d records", *auditLog, count)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/scheduler/extender"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	listen       = flag.String("listen", ":39999", "Address the extender listens on")
+	resourceName = flag.String("resource-name", "nvidia.com/gpu", "Resource advertised by the virtual GPU device plugin")
+	singleGPU    = flag.Bool("single-gpu", true, "Require every container to fit on a single physical GPU")
+)
+
+func main() {
+	flag.Parse()
+	log.Println("Start virtual GPU scheduler extender")
+
+	e := &extender.Extender{
+		ResourceName: v1.ResourceName(*resourceName),
+		SingleGPU:    *singleGPU,
+	}
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, e.Handler()))
+}
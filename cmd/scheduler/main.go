@@ -0,0 +1,23 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/scheduler/gpumemory"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+)
+
+// main runs kube-scheduler with the GPUMemory plugin registered. Enable it
+// through the plugins section of the scheduler configuration.
+func main() {
+	rand.Seed(time.Now().UnixNano())
+
+	command := app.NewSchedulerCommand(
+		app.WithPlugin(gpumemory.Name, gpumemory.New),
+	)
+	if err := command.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
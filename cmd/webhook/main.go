@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/httpserver"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/webhook"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	listen        = flag.String("listen", ":8443", "Address the webhook listens on")
+	tlsCertFile   = flag.String("tls-cert-file", "/etc/webhook/certs/tls.crt", "TLS certificate served by the webhook")
+	tlsKeyFile    = flag.String("tls-key-file", "/etc/webhook/certs/tls.key", "TLS private key of the webhook")
+	resourceName  = flag.String("resource-name", "nvidia.com/gpu", "Resource advertised by the virtual GPU device plugin")
+	memoryPerVGPU = flag.String("memory-per-vgpu", "1Gi", "GPU memory backing one virtual GPU")
+	vGPUsPerGPU   = flag.Int64("vgpus-per-gpu", 10, "Number of virtual GPUs exposed for every physical GPU, 0 disables validation")
+	singleGPU     = flag.Bool("single-gpu", false, "Require the virtual GPUs of every container to come from a single physical GPU")
+	classPolicy   = flag.String("class-policy", "", "JSON file mapping namespaces or pod labels to the virtual GPU resource classes their pods may request")
+	deviceClasses = flag.Bool("device-classes", false, "Move the virtual GPUs requested by pods of the Guaranteed QoS class, or of --guaranteed-priority-classes, to hkube.io/vgpu-guaranteed and those of the other pods to hkube.io/vgpu-best-effort")
+	guaranteedPCs = flag.String("guaranteed-priority-classes", "", "Comma separated priority classes whose pods get guaranteed virtual GPUs whatever their QoS class")
+	highPriority  = flag.String("mps-high-priority-classes", "", "Comma separated priority classes whose GPU containers get a normal MPS client priority, the others get a below normal one, MPS priorities are not set when empty")
+)
+
+func main() {
+	flag.Parse()
+	log.Println("Start virtual GPU admission webhook")
+
+	memory, err := resource.ParseQuantity(*memoryPerVGPU)
+	if err != nil || memory.Value() <= 0 {
+		log.Fatalf("Invalid memory per virtual GPU %q", *memoryPerVGPU)
+	}
+
+	wh := &webhook.Webhook{
+		ResourceName:  v1.ResourceName(*resourceName),
+		MemoryPerVGPU: memory.Value(),
+		VGPUsPerGPU:   *vGPUsPerGPU,
+		SingleGPU:     *singleGPU,
+	}
+	if *classPolicy != "" {
+		if wh.Classes, err = webhook.LoadClassPolicy(*classPolicy); err != nil {
+			log.Fatalf("Failed to load class policy: %v", err)
+		}
+	}
+	wh.HighPriorityClasses = splitList(*highPriority)
+	if *deviceClasses {
+		wh.DeviceClasses = &webhook.DeviceClasses{
+			Guaranteed:      "hkube.io/vgpu-guaranteed",
+			BestEffort:      "hkube.io/vgpu-best-effort",
+			PriorityClasses: splitList(*guaranteedPCs),
+		}
+	}
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(httpserver.Serve(*listen, *tlsCertFile, *tlsKeyFile, wh.Handler()))
+}
+
+// splitList returns the non-empty elements of the comma separated list.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -0,0 +1,70 @@
+#!/usr/bin/env bash
+# End-to-end test of the device plugin in simulation mode on a kind cluster.
+# It deploys manifests/device-plugin-fake.yml, schedules a pod requesting
+# virtual GPUs, and checks the environment injected into the container and
+# the vGPU capacity accounting of the node.
+#
+# Requires docker, kind and kubectl. Set KEEP_CLUSTER=1 to keep the cluster.
+set -euo pipefail
+
+CLUSTER=${CLUSTER:-vgpu-e2e}
+IMAGE=amazon/aws-virtual-gpu-device-plugin:v0.1.1
+FAKE_GPUS=2
+VGPUS=10
+REQUESTED=3
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+
+fail() {
+  echo "FAIL: $*" >&2
+  kubectl -n kube-system logs -l name=aws-virtual-gpu-device-plugin-fake --tail=50 >&2 || true
+  exit 1
+}
+
+cleanup() {
+  if [ -z "${KEEP_CLUSTER:-}" ]; then
+    kind delete cluster --name "$CLUSTER"
+  fi
+}
+
+kind create cluster --name "$CLUSTER"
+trap cleanup EXIT
+
+docker build -t "$IMAGE" "$ROOT"
+kind load docker-image "$IMAGE" --name "$CLUSTER"
+
+kubectl apply -f "$ROOT/manifests/device-plugin-fake.yml"
+kubectl -n kube-system rollout status daemonset/aws-virtual-gpu-device-plugin-fake --timeout=120s
+
+node=$(kubectl get nodes -o jsonpath='{.items[0].metadata.name}')
+expected=$((FAKE_GPUS * VGPUS))
+for i in $(seq 60); do
+  capacity=$(kubectl get node "$node" -o jsonpath='{.status.capacity.nvidia\.com/gpu}')
+  [ "$capacity" = "$expected" ] && break
+  sleep 2
+done
+[ "$capacity" = "$expected" ] || fail "node capacity is '$capacity' virtual GPUs, expected $expected"
+echo "PASS: node advertises $capacity virtual GPUs"
+
+kubectl apply -f "$ROOT/e2e/vgpu-pod.yml"
+for i in $(seq 60); do
+  phase=$(kubectl get pod vgpu-e2e -o jsonpath='{.status.phase}')
+  [ "$phase" = "Succeeded" ] || [ "$phase" = "Failed" ] && break
+  sleep 2
+done
+[ "$phase" = "Succeeded" ] || fail "pod phase is '$phase'"
+
+visible=$(kubectl logs vgpu-e2e | sed -n 's/^NVIDIA_VISIBLE_DEVICES=//p')
+[ -n "$visible" ] || fail "NVIDIA_VISIBLE_DEVICES is not set"
+for gpu in ${visible//,/ }; do
+  [[ "$gpu" == GPU-fa4e0000-* ]] || fail "unexpected visible device '$gpu'"
+done
+echo "PASS: container sees GPUs $visible"
+
+allocated=$(kubectl get pods --all-namespaces --field-selector spec.nodeName="$node" \
+  -o jsonpath='{range .items[*]}{range .spec.containers[*]}{.resources.limits.nvidia\.com/gpu}{"\n"}{end}{end}' |
+  awk '{s += $1} END {print s + 0}')
+[ "$allocated" = "$REQUESTED" ] || fail "$allocated virtual GPUs allocated on the node, expected $REQUESTED"
+echo "PASS: $allocated of $expected virtual GPUs allocated"
+
+kubectl delete -f "$ROOT/e2e/vgpu-pod.yml"
+echo "PASS: end-to-end test"
@@ -1,30 +1,285 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/budget"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
 	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvidia"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/logging"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/vgpu"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 var (
-	vGPU = flag.Int("vgpu", 10, "Number of virtual GPUs")
+	pluginPath   = flag.String("device-plugin-path", pluginapi.DevicePluginPath, "Directory holding the kubelet and device plugin sockets")
+	vGPU         = flag.Int("vgpu", 10, "Number of virtual GPUs")
+	totalVGPUs   = flag.Int("total-vgpus", 0, "Number of virtual GPUs exposed by the node, distributed across its shared GPUs in proportion to their memory, instead of --vgpu for every GPU, 0 disables it")
+	fakeGPUs     = flag.Uint("fake-gpus", 0, "Emulate this number of physical GPUs, without NVIDIA hardware or driver, for development clusters")
+	sequentialID = flag.Bool("sequential-device-ids", false, "Derive the virtual GPU IDs from the GPU indexes instead of their UUIDs, e.g. 0-0, 0-1, for stable IDs in tests and debugging")
+	perGPU       = flag.Bool("per-gpu-resources", false, "Also advertise the virtual GPUs of every physical GPU as hkube.io/gpu-<index>-vgpu")
+	exclusive    = flag.String("exclusive-gpus", "", "Comma separated indexes of the GPUs handed out whole as --exclusive-resource-name instead of being shared")
+	exclusiveRes = flag.String("exclusive-resource-name", nvidia.DefaultExclusiveResourceName, "Resource of the GPUs handed out whole")
+	memoryChunk  = flag.Uint64("gpu-memory-chunk", 0, "Also advertise the memory of every shared GPU as --gpu-memory-resource-name devices of this size in MiB, 0 disables the resource")
+	memoryRes    = flag.String("gpu-memory-resource-name", nvidia.DefaultMemoryResourceName, "Resource of the GPU memory chunks")
+	modelRes     = flag.Bool("model-resources", false, "Also advertise the virtual GPUs of every GPU model as hkube.io/<model>-vgpu, e.g. hkube.io/t4-vgpu")
+	tiers        = flag.String("vgpu-tiers", "", "Comma separated sizes of virtual GPUs advertised as their own resource, as <resource>=<virtual GPUs>, e.g. \"hkube.io/vgpu-small=1,hkube.io/vgpu-large=4\"")
+	guaranteed   = flag.Uint("guaranteed-percent", 0, "Also advertise this percentage of the virtual GPUs of every shared GPU as "+nvidia.GuaranteedResourceName+" and the others as "+nvidia.BestEffortResourceName+", 0 disables the device classes")
+	gridVGPUs    = flag.Bool("grid-partitioning", false, "Advertise every NVIDIA vGPU (GRID) handed to the node by the hypervisor as a single virtual GPU, sharing only the physical GPUs in software")
+	migDevices   = flag.Bool("mig-devices", false, "Share the MIG devices of the GPUs with MIG enabled, each like a GPU, instead of the GPUs")
+	tegra        = flag.Bool("tegra", nvidia.IsTegra(), "Share the integrated GPU of Jetson (Tegra) devices, which NVML does not support, defaults to true on Jetson devices")
+	wsl          = flag.Bool("wsl", nvidia.IsWSL(), "Share the GPUs of a WSL2 distribution through the /dev/dxg device of the Windows host, defaults to true in WSL2 with GPU support")
+	graphics     = flag.Bool("graphics", false, "Enable graphics support by mounting the Vulkan ICD directory into containers")
+	vulkanICDDir = flag.String("vulkan-icd-dir", nvidia.DefaultVulkanICDDir, "Host directory holding the Vulkan ICD files")
+	driverRoot   = flag.String("driver-root", nvidia.DefaultDriverRoot, "Host directory of the driver libraries mounted into containers at /usr/local/nvidia, or root directory of the driver install, e.g. \"/run/nvidia/driver\", nothing is mounted when empty")
+	driverRoots  = flag.String("driver-roots", "", "Comma separated host directories of the driver libraries of GPUs driven by another driver install than the host's, as <GPU index>=<directory>, e.g. \"2=/run/nvidia/driver/usr/lib64\"")
+	cudaLimiter  = flag.String("cuda-limiter-dir", "", "Host directory holding the CUDA interception library libvgpu.so enforcing the GPU memory share of every container")
+	computeEnf   = flag.String("compute-enforcement", nvidia.ComputeEnforcementNone, "Enforcement of the compute share of containers, \""+nvidia.ComputeEnforcementThrottle+"\" throttles their SM usage with the CUDA limiter")
+	readOnly     = flag.Bool("read-only-mounts", false, "Mark every mount injected into containers as read-only")
+	devicePerms  = flag.String("device-permissions", nvidia.DefaultDevicePermissions, "Cgroup permissions granted on injected device nodes, e.g. \"rw\" to deny mknod")
+	templates    = flag.String("response-templates", strings.Join(nvidia.DefaultResponseTemplates, ","), "Comma separated templates composing the responses to container requests, in order, e.g. \"toolkit\" to leave the driver and device nodes to the NVIDIA container runtime")
+	mpsPipeDir   = flag.String("mps-pipe-dir", "", "Host directory of the pipes of the MPS control daemon mounted into containers, which then use MPS with an active thread percentage of their compute share")
+	deviceProf   = flag.String("device-profile", nvidia.DeviceProfileDefault, "Devices injected into containers, \""+nvidia.DeviceProfileMinimal+"\" only injects what compute needs, without mknod and with read-only mounts")
+	resourceConf = flag.String("resource-config-file", "", "JSON file overriding the allocation settings of single resources, watched for changes which restart the device plugins of the changed resources")
+	selinuxLabel = flag.String("selinux-label", "", "SELinux label applied to injected devices and mounts, e.g. \""+nvidia.DefaultSELinuxLabel+"\"")
+	allocAnnots  = flag.Bool("allocation-annotations", false, "Describe the physical GPUs, virtual GPUs and GPU share of every container in the annotations of its allocation, passed to the hooks of the container runtime")
+	cdiAnnots    = flag.Bool("cdi-annotations", false, "Request the GPUs of every container from the container runtime through CDI annotations, which requires the CDI specification of the NVIDIA container toolkit on the node")
+	verifyPeer   = flag.Bool("verify-socket-peer", false, "Reject connections to the plugin socket from users other than --allowed-peer-uids")
+	allowedUIDs  = flag.String("allowed-peer-uids", "0", "Comma separated users allowed to connect to the plugin socket")
+	preStart     = flag.Bool("prestart-check", false, "Check that the GPUs of a container are healthy and answer the driver before kubelet starts it, failing the container with a clear error otherwise")
+	allocTimeout = flag.Duration("allocate-timeout", 10*time.Second, "Maximum processing time of an allocation request, 0 only honors kubelet's deadline")
+	drainTimeout = flag.Duration("drain-timeout", 5*time.Second, "Maximum time in-flight calls from kubelet may take to complete on shutdown or re-registration")
+	kubeconfig   = flag.String("kubeconfig", "", "Path to a kubeconfig, only required when running out of cluster")
+	nodeName     = flag.String("node-name", os.Getenv("NODE_NAME"), "Name of the node the plugin runs on")
+	nodeLabels   = flag.Bool("node-labels", false, "Label the node with the GPU count, product, memory, driver and CUDA versions and virtual GPU capacity, and keep the labels up to date")
+	noGPUTaint   = flag.String("no-gpu-taint", "", "Taint in the key[=value]:effect format, e.g. \"hkube.io/no-gpu=true:NoSchedule\", applied to the node while the plugin finds no usable GPU and removed once it starts with GPUs, the node is not tainted when empty")
+	nfdFeatures  = flag.String("nfd-features-dir", "", "features.d directory of node feature discovery, e.g. \"/etc/kubernetes/node-feature-discovery/features.d\", to describe the GPUs and virtual GPU configuration in, none is written when empty")
+	publishInv   = flag.Bool("publish-inventory", false, "Publish the per-GPU occupancy in the "+inventory.Annotation+" node annotation")
+	publishNode  = flag.Bool("publish-gpunode", false, "Publish the GPUs, virtual GPUs, health and allocations of the node in its "+nvidia.GPUNodeKind+" custom resource, installed by manifests/gpunode-crd.yml")
+	detectDrift  = flag.Bool("detect-capacity-drift", false, "Compare the capacity of the resources in the node status with the advertised devices every minute, and register again with kubelet when they drift apart")
+	healthCond   = flag.Bool("health-condition", false, "Report whether the device plugins are registered with kubelet and the GPUs healthy in the VGPUPluginHealthy node condition")
+	publishHlth  = flag.Bool("publish-health", false, "Publish the health of every GPU and its last Xid or ECC error in the "+nvidia.HealthAnnotation+" node annotation")
+	drainAnnot   = flag.Bool("drain-annotation", false, "Drain the GPUs listed by index or UUID in the "+nvidia.DrainAnnotation+" node annotation, marking their virtual GPUs unhealthy until they are removed from it")
+	publishTopo  = flag.Bool("publish-topology", false, "Include the virtual GPUs sharing every physical GPU in the published inventory")
+	stateNS      = flag.String("state-namespace", "", "Namespace of the hkube-vgpu-state-<node> ConfigMap the devices, health and allocations of the plugin are published to for debugging, nothing is published when empty")
+	annotatePods = flag.Bool("annotate-pods", false, "Record the physical GPUs received by every container in the hkube.io/gpu-assignment pod annotation")
+	auditLog     = flag.String("audit-log", "", "File recording every allocation with its pod and physical GPUs")
+	auditKey     = flag.String("audit-signing-key", "", "File holding the node key signing the audit log records")
+	webhookURL   = flag.String("allocation-webhook", "", "http or https URL every allocation and release of virtual GPUs is posted to, with its pod, GPUs and shares")
+	webhookKey   = flag.String("allocation-webhook-signing-key", "", "File holding the key signing the allocation webhook events with HMAC-SHA256")
+	metricsAddr  = flag.String("metrics-address", "", "Address serving Prometheus metrics on /metrics, e.g. \"localhost:9400\" or \"unix:/run/vgpu/metrics.sock\"")
+	metricsCert  = flag.String("metrics-tls-cert-file", "", "TLS certificate serving the metrics, reloaded when it changes")
+	metricsKey   = flag.String("metrics-tls-key-file", "", "TLS private key serving the metrics")
+	budgetDir    = flag.String("budget-dir", "", "Host directory holding the GPU budget files mounted into containers at "+budget.ContainerPath+", none are mounted when empty")
+	computeModes = flag.Bool("manage-compute-mode", false, "Set the compute mode of every GPU depending on whether it is shared or handed out whole to a container")
+	sharedMode   = flag.String("shared-compute-mode", nvidia.ComputeModeDefault, "Compute mode of the GPUs shared by several containers")
+	wholeMode    = flag.String("whole-compute-mode", nvidia.ComputeModeExclusiveProcess, "Compute mode of the GPUs handed out whole to a container")
+	persistence  = flag.String("persistence", nvidia.PersistenceNone, "Keep the GPUs initialized without users, sparing the first container of every GPU seconds of latency: \""+nvidia.PersistenceMode+"\" enables their persistence mode, \""+nvidia.PersistenceDaemon+"\" starts and supervises nvidia-persistenced")
+	memoryQuota  = flag.String("memory-quota-enforcement", nvidia.MemoryQuotaNone, "Handling of pods using more GPU memory than their virtual GPUs share: \"metric\", \"event\", \"kill\" or \"evict\", each including the previous ones")
+	verifyPolicy = flag.Bool("verify-device-policy", false, "On cgroup v2 nodes, report the containers using the GPUs without a device controller program")
+	maxPods      = flag.Uint("max-pods-per-gpu", 0, "Stop advertising the free virtual GPUs of a physical GPU shared by this number of pods, 0 disables the limit")
+	maxBudget    = flag.Uint("max-memory-budget-percent", 0, "Stop advertising the free virtual GPUs of a physical GPU once its allocated ones hold this percentage of its memory, 0 disables the limit")
+	overload     = flag.Uint("overload-threshold", 0, "GPU utilization percentage above which a GPU is busy, 0 disables overload detection")
+	idle         = flag.Uint("idle-threshold", 10, "GPU utilization percentage below which a GPU is idle")
+	overloadFor  = flag.Duration("overload-period", 10*time.Minute, "How long a GPU must stay busy while another one is idle to be reported as overloaded")
+	reconcile    = flag.Bool("reconcile-allocations", false, "Keep the allocations recorded by the plugin in sync with the devices kubelet assigned to the running containers, releasing those of deleted pods")
+	checkpoint   = flag.String("checkpoint-file", "", "File keeping the IDs of the physical GPUs and the allocations of their virtual GPUs across restarts and upgrades, e.g. \"/var/lib/hkube-vgpu/checkpoint.json\", nothing is kept when empty")
+	handover     = flag.Bool("handover", false, "Let a new instance of the plugin take over the node from the running one during an upgrade without a gap in the device plugin registrations, requires --checkpoint-file")
+	logFormat    = flag.String("log-format", logging.FormatText, "Format of the log, \""+logging.FormatJSON+"\" writes every entry as a JSON line for node log collectors")
+	logConsole   = flag.String("log-console", logging.ConsoleStderr, "Console output of the log, \""+logging.ConsoleStdout+"\", or \""+logging.ConsoleNone+"\" to only write it to --log-file")
+	logFile      = flag.String("log-file", "", "File the log is also written to, rotated by size, e.g. \"/var/log/hkube-vgpu/plugin.log\", none is written when empty")
+	logMaxSize   = flag.Int64("log-max-size", 100, "Size in MiB above which the log file is rotated, 0 disables the rotation")
+	logMaxAge    = flag.Duration("log-max-age", 7*24*time.Hour, "Age above which rotated log files are removed, 0 keeps them")
+	logBackups   = flag.Int("log-max-backups", 5, "Number of rotated log files kept, 0 keeps them all")
+	faultsAddr   = flag.String("fault-injection-address", os.Getenv("VGPU_FAULT_INJECTION_ADDRESS"), "Debug only: address serving the fault injection endpoints, e.g. \"unix:/run/vgpu/faults.sock\"")
 )
 
-const VOLTA_MAXIMUM_MPS_CLIENT = 48
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == soakCommand {
+		if err := soak(os.Args[2:]); err != nil {
+			log.Fatalf("Soak test failed: %v", err)
+		}
+		return
+	}
+
 	flag.Parse()
+	logCloser, err := logging.Setup(logging.Options{
+		Format:     *logFormat,
+		Console:    *logConsole,
+		File:       *logFile,
+		MaxSize:    *logMaxSize << 20,
+		MaxAge:     *logMaxAge,
+		MaxBackups: *logBackups,
+	})
+	if err != nil {
+		log.Fatalf("Invalid log output: %v", err)
+	}
+	defer logCloser.Close()
 	log.Println("Start virtual GPU device plugin")
 
-	if *vGPU > VOLTA_MAXIMUM_MPS_CLIENT {
-		log.Fatal("Number of virtual GPUs can not exceed maximum number of MPS clients")
+	uids, err := parseUIDs(*allowedUIDs)
+	if err != nil {
+		log.Fatalf("Invalid allowed peer uids: %v", err)
+	}
+
+	exclusiveGPUs, err := parseIndexes(*exclusive)
+	if err != nil {
+		log.Fatalf("Invalid exclusive GPUs: %v", err)
+	}
+
+	vGPUTiers, err := nvidia.ParseTiers(*tiers)
+	if err != nil {
+		log.Fatalf("Invalid virtual GPU tiers: %v", err)
+	}
+
+	gpuDrivers, err := nvidia.ParseDriverRoots(*driverRoots)
+	if err != nil {
+		log.Fatalf("Invalid driver roots: %v", err)
+	}
+
+	config := nvidia.Config{
+		DevicePluginPath:   *pluginPath,
+		VGPUCount:          *vGPU,
+		TotalVGPUs:         *totalVGPUs,
+		ModelResources:     *modelRes,
+		Tiers:              vGPUTiers,
+		GRIDPartitioning:   *gridVGPUs,
+		MIGDevices:         *migDevices,
+		FakeGPUs:           *fakeGPUs,
+		Tegra:              *tegra,
+		WSL:                *wsl,
+		PerGPUResources:    *perGPU,
+		Graphics:           *graphics,
+		VulkanICDDir:       *vulkanICDDir,
+		DriverRoot:         *driverRoot,
+		DriverRoots:        gpuDrivers,
+		CUDALimiterDir:     *cudaLimiter,
+		ComputeEnforcement: *computeEnf,
+		ReadOnlyMounts:     *readOnly,
+		DevicePermissions:  *devicePerms,
+		DeviceProfile:      *deviceProf,
+		ResourceConfigFile: *resourceConf,
+		ResponseTemplates:  nvidia.ParseResponseTemplates(*templates),
+		MPSPipeDir:         *mpsPipeDir,
+		SELinuxLabel:       *selinuxLabel,
+		VerifySocketPeer:   *verifyPeer,
+		AllowedPeerUIDs:    uids,
+		AllocateTimeout:    *allocTimeout,
+		PreStartCheck:      *preStart,
+		DrainTimeout:       *drainTimeout,
+		Kubeconfig:         *kubeconfig,
+		NodeName:           *nodeName,
+		NodeLabels:         *nodeLabels,
+		NoGPUTaint:         *noGPUTaint,
+		NFDFeaturesDir:     *nfdFeatures,
+		PublishInventory:   *publishInv,
+		PublishTopology:    *publishTopo,
+		PublishHealth:      *publishHlth,
+		WatchDrain:         *drainAnnot,
+		HealthCondition:    *healthCond,
+		PublishGPUNode:     *publishNode,
+		StateNamespace:     *stateNS,
+		AnnotatePods:       *annotatePods,
+		AuditLog:           *auditLog,
+		AuditSigningKey:    *auditKey,
+		MetricsAddress:     *metricsAddr,
+		MetricsTLSCertFile: *metricsCert,
+		MetricsTLSKeyFile:  *metricsKey,
+		OverloadThreshold:  *overload,
+		IdleThreshold:      *idle,
+		OverloadPeriod:     *overloadFor,
+
+		MemoryChunk:            *memoryChunk,
+		MemoryResourceName:     *memoryRes,
+		ExclusiveGPUs:          exclusiveGPUs,
+		ExclusiveResourceName:  *exclusiveRes,
+		BudgetDir:              *budgetDir,
+		ManageComputeMode:      *computeModes,
+		SharedComputeMode:      *sharedMode,
+		WholeComputeMode:       *wholeMode,
+		Persistence:            *persistence,
+		AllocationAnnotations:  *allocAnnots,
+		CDIAnnotations:         *cdiAnnots,
+		AllocationWebhook:      *webhookURL,
+		AllocationWebhookKey:   *webhookKey,
+		MemoryQuotaEnforcement: *memoryQuota,
+		VerifyDevicePolicy:     *verifyPolicy,
+		MaxPodsPerGPU:          *maxPods,
+		MaxMemoryBudgetPercent: *maxBudget,
+		GuaranteedPercent:      *guaranteed,
+		SequentialDeviceIDs:    *sequentialID,
+		ReconcileAllocations:   *reconcile,
+		DetectCapacityDrift:    *detectDrift,
+		CheckpointFile:         *checkpoint,
+		Handover:               *handover,
+		FaultInjectionAddress:  *faultsAddr,
+	}
+	// The self test reports an invalid configuration along with the other
+	// checks.
+	if flag.Arg(0) == "selftest" {
+		report := nvidia.SelfTest(config, nvidia.NewDeviceBackend(config))
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	vgm := nvidia.NewVirtualGPUManager(*vGPU)
+	switch flag.Arg(0) {
+	case "":
+	case "describe":
+		if err := nvidia.Describe(config, nvidia.NewDeviceBackend(config), os.Stdout); err != nil {
+			log.Fatalf("Failed to describe the node: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("Unknown command %q", flag.Arg(0))
+	}
 
-	err := vgm.Run()
+	err = vgpu.Run(vgpu.Options{Config: config})
 	if err != nil {
 		log.Fatalf("Failed due to %v", err)
 	}
 }
+
+func parseUIDs(s string) ([]uint32, error) {
+	var uids []uint32
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids, nil
+}
+
+func parseIndexes(s string) ([]int, error) {
+	var indexes []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		i, err := strconv.ParseUint(f, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, int(i))
+	}
+	return indexes, nil
+}
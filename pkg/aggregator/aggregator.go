@@ -0,0 +1,189 @@
+// Package aggregator sums up the GPU inventories published by the device
+// plugins on every node into cluster wide virtual GPU capacity, exposed as
+// metrics and as a JSON REST endpoint.
+package aggregator
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Zone labels, the beta one is used by clusters older than 1.17.
+const (
+	zoneLabel     = "topology.kubernetes.io/zone"
+	zoneLabelBeta = "failure-domain.beta.kubernetes.io/zone"
+
+	unknown = "unknown"
+)
+
+// Capacity counts physical and virtual GPUs.
+type Capacity struct {
+	GPUs       int `json:"gpus"`
+	TotalVGPUs int `json:"totalVGPUs"`
+	FreeVGPUs  int `json:"freeVGPUs"`
+}
+
+func (c *Capacity) add(g inventory.GPU) {
+	c.GPUs++
+	c.TotalVGPUs += g.TotalVGPUs
+	c.FreeVGPUs += g.FreeVGPUs()
+}
+
+// Summary is the cluster wide virtual GPU capacity.
+type Summary struct {
+	Nodes      int                  `json:"nodes"`
+	Total      Capacity             `json:"total"`
+	Models     map[string]*Capacity `json:"models"`
+	Zones      map[string]*Capacity `json:"zones"`
+	UpdateTime time.Time            `json:"updateTime"`
+}
+
+// Aggregator periodically reads the GPU inventory of the nodes.
+type Aggregator struct {
+	client   kubernetes.Interface
+	selector string
+
+	registry *metrics.Registry
+	total    *metrics.Vec
+	free     *metrics.Vec
+
+	mu      sync.RWMutex
+	summary *Summary
+}
+
+// New returns an aggregator reading the nodes matching the label selector.
+func New(client kubernetes.Interface, selector string) *Aggregator {
+	registry := metrics.NewRegistry()
+	return &Aggregator{
+		client:   client,
+		selector: selector,
+		registry: registry,
+		total: registry.NewGaugeVec("vgpu_cluster_vgpus_total",
+			"Virtual GPUs advertised in the cluster.", "model", "zone"),
+		free: registry.NewGaugeVec("vgpu_cluster_vgpus_free",
+			"Virtual GPUs not allocated in the cluster.", "model", "zone"),
+		summary: &Summary{},
+	}
+}
+
+func nodeZone(node *v1.Node) string {
+	if z, ok := node.Labels[zoneLabel]; ok {
+		return z
+	}
+	if z, ok := node.Labels[zoneLabelBeta]; ok {
+		return z
+	}
+	return unknown
+}
+
+// refresh recomputes the summary and the metrics from the nodes.
+func (a *Aggregator) refresh() error {
+	nodes, err := a.client.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: a.selector})
+	if err != nil {
+		return err
+	}
+
+	summary := &Summary{
+		Models:     make(map[string]*Capacity),
+		Zones:      make(map[string]*Capacity),
+		UpdateTime: time.Now(),
+	}
+	type key struct{ model, zone string }
+	byKey := make(map[key]*Capacity)
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		inv, err := inventory.Parse(node.Annotations)
+		if err != nil {
+			log.Printf("Skipping node %s: %v", node.Name, err)
+			continue
+		}
+		if inv == nil {
+			continue
+		}
+
+		summary.Nodes++
+		zone := nodeZone(node)
+		for _, g := range inv.GPUs {
+			model := g.Model
+			if model == "" {
+				model = unknown
+			}
+			if summary.Models[model] == nil {
+				summary.Models[model] = &Capacity{}
+			}
+			if summary.Zones[zone] == nil {
+				summary.Zones[zone] = &Capacity{}
+			}
+			k := key{model, zone}
+			if byKey[k] == nil {
+				byKey[k] = &Capacity{}
+			}
+
+			summary.Total.add(g)
+			summary.Models[model].add(g)
+			summary.Zones[zone].add(g)
+			byKey[k].add(g)
+		}
+	}
+
+	a.total.Reset()
+	a.free.Reset()
+	for k, c := range byKey {
+		a.total.Set(float64(c.TotalVGPUs), k.model, k.zone)
+		a.free.Set(float64(c.FreeVGPUs), k.model, k.zone)
+	}
+
+	a.mu.Lock()
+	a.summary = summary
+	a.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the capacity every interval until stop is closed.
+func (a *Aggregator) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.refresh(); err != nil {
+			log.Printf("Failed to refresh cluster capacity: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Summary returns the last computed cluster capacity.
+func (a *Aggregator) Summary() *Summary {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.summary
+}
+
+// Handler returns the HTTP handler serving the capacity on /capacity and the
+// metrics on /metrics.
+func (a *Aggregator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.registry)
+	mux.HandleFunc("/capacity", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.Summary()); err != nil {
+			log.Printf("Failed to encode capacity: %v", err)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,186 @@
+// Package audit writes and verifies the log of the physical GPUs allocated to
+// every container. Records can be signed with a node key so that the log can
+// be proven not to have been altered: every signature also covers the
+// signature of the previous record, so removing or reordering records breaks
+// the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is one allocation of virtual GPUs to a container.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Node      string    `json:"node"`
+	Namespace string    `json:"namespace,omitempty"`
+	Pod       string    `json:"pod,omitempty"`
+	Container string    `json:"container,omitempty"`
+	DeviceIDs []string  `json:"deviceIDs"`
+	GPUs      []string  `json:"gpus"`
+
+	// Previous is the signature of the previous record of the log.
+	Previous string `json:"previous,omitempty"`
+	// Signature is the hex encoded HMAC-SHA256 of the record without its
+	// signature, using the node key.
+	Signature string `json:"signature,omitempty"`
+}
+
+// sign returns the signature of the record with key.
+func (r Record) sign(key []byte) (string, error) {
+	r.Signature = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// LoadKey reads a signing key, e.g. mounted from a Secret. Surrounding
+// whitespace is ignored.
+func LoadKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty signing key %s", path)
+	}
+	return key, nil
+}
+
+// Log appends records as JSON lines to a file.
+type Log struct {
+	sync.Mutex
+	file *os.File
+	key  []byte
+	last string
+}
+
+// Open opens the log at path for appending, signing the records with key
+// unless it is empty. The signature chain continues from the last record
+// already in the file.
+func Open(path string, key []byte) (*Log, error) {
+	last, err := lastSignature(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: f, key: key, last: last}, nil
+}
+
+// lastSignature returns the signature of the last record of the log at path.
+func lastSignature(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var last Record
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			last = Record{}
+			if err := json.Unmarshal([]byte(line), &last); err != nil {
+				return "", fmt.Errorf("invalid audit record in %s: %v", path, err)
+			}
+		}
+	}
+	return last.Signature, s.Err()
+}
+
+// Write signs and appends the record to the log.
+func (l *Log) Write(r Record) error {
+	l.Lock()
+	defer l.Unlock()
+
+	r.Previous = ""
+	r.Signature = ""
+	if len(l.key) != 0 {
+		r.Previous = l.last
+		sig, err := r.sign(l.key)
+		if err != nil {
+			return err
+		}
+		r.Signature = sig
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.last = r.Signature
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Verify checks the signatures and the chain of every record read from r and
+// returns the number of valid records, or an error naming the first line
+// that does not verify.
+func Verify(r io.Reader, key []byte) (int, error) {
+	count := 0
+	previous := ""
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return count, fmt.Errorf("line %d: invalid record: %v", line, err)
+		}
+		if rec.Previous != previous {
+			return count, fmt.Errorf("line %d: broken chain, a record was removed or reordered", line)
+		}
+
+		sig, err := rec.sign(key)
+		if err != nil {
+			return count, fmt.Errorf("line %d: %v", line, err)
+		}
+		if !hmac.Equal([]byte(sig), []byte(rec.Signature)) {
+			return count, fmt.Errorf("line %d: invalid signature", line)
+		}
+
+		previous = rec.Signature
+		count++
+	}
+	return count, s.Err()
+}
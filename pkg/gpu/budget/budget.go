@@ -0,0 +1,46 @@
+// Package budget defines the GPU budget file that the virtual GPU device
+// plugin mounts into every GPU container, so that sidecars and frameworks can
+// read the limits of the container without parsing environment variables.
+package budget
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// ContainerPath is where the budget file is mounted in containers.
+const ContainerPath = "/etc/vgpu/budget.json"
+
+// GPU is the share of a physical GPU received by the container.
+type GPU struct {
+	// Index is the NVML index of the GPU on the node.
+	Index int    `json:"index"`
+	UUID  string `json:"uuid"`
+	// VGPUs are the IDs of the virtual GPUs of the container on the GPU.
+	VGPUs []string `json:"vgpus"`
+	// MemoryMiB is the memory share of the container, zero when the memory
+	// of the GPU is unknown.
+	MemoryMiB uint64 `json:"memoryMiB,omitempty"`
+	// ComputePercent is the share of the SMs of the container.
+	ComputePercent int `json:"computePercent"`
+}
+
+// Container is the GPU budget of a container.
+type Container struct {
+	// VGPUs are the IDs of all the virtual GPUs of the container.
+	VGPUs []string `json:"vgpus"`
+	GPUs  []GPU    `json:"gpus"`
+}
+
+// Read reads the budget file at path, usually ContainerPath.
+func Read(path string) (*Container, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Container
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,100 @@
+// Package inventory defines the per-GPU occupancy that the virtual GPU device
+// plugin publishes on its node, so that schedulers and other tooling can see
+// how virtual GPUs are packed onto physical GPUs.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Annotation is the node annotation holding the JSON encoded Node inventory.
+const Annotation = "hkube.io/gpu-inventory"
+
+// GPU describes the occupancy of a single physical GPU.
+type GPU struct {
+	// Index is the NVML index of the GPU, as used by gang schedulers such as
+	// Volcano's device share plugin to identify cards.
+	Index          int    `json:"index"`
+	UUID           string `json:"uuid"`
+	Model          string `json:"model,omitempty"`
+	TotalVGPUs     int    `json:"totalVGPUs"`
+	AllocatedVGPUs int    `json:"allocatedVGPUs"`
+	// MemoryTotal and MemoryFree are expressed in MiB.
+	MemoryTotal uint64 `json:"memoryTotal,omitempty"`
+	MemoryFree  uint64 `json:"memoryFree,omitempty"`
+	// VGPUs lists the IDs of the virtual GPUs sharing the GPU. It is only
+	// published when topology publishing is enabled.
+	VGPUs []string `json:"vgpus,omitempty"`
+}
+
+// FreeVGPUs returns the number of virtual GPUs of the GPU that are not allocated.
+func (g GPU) FreeVGPUs() int {
+	if g.AllocatedVGPUs >= g.TotalVGPUs {
+		return 0
+	}
+	return g.TotalVGPUs - g.AllocatedVGPUs
+}
+
+// Node is the inventory of all physical GPUs of a node.
+type Node struct {
+	GPUs []GPU `json:"gpus"`
+}
+
+// GPUOf returns the physical GPU backing the virtual GPU, or nil when the
+// topology is not published or the virtual GPU is unknown.
+func (n *Node) GPUOf(vGPUID string) *GPU {
+	for i := range n.GPUs {
+		for _, id := range n.GPUs[i].VGPUs {
+			if id == vGPUID {
+				return &n.GPUs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// FreeVGPUs returns the number of unallocated virtual GPUs on the node.
+func (n *Node) FreeVGPUs() int {
+	free := 0
+	for _, g := range n.GPUs {
+		free += g.FreeVGPUs()
+	}
+	return free
+}
+
+// MaxFreeVGPUs returns the largest number of unallocated virtual GPUs found
+// on a single physical GPU of the node.
+func (n *Node) MaxFreeVGPUs() int {
+	max := 0
+	for _, g := range n.GPUs {
+		if g.FreeVGPUs() > max {
+			max = g.FreeVGPUs()
+		}
+	}
+	return max
+}
+
+// Encode returns the annotation value for the inventory.
+func (n *Node) Encode() (string, error) {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Parse reads the inventory from the annotations of a node. It returns nil
+// when the node has no inventory published.
+func Parse(annotations map[string]string) (*Node, error) {
+	value, ok := annotations[Annotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var n Node
+	if err := json.Unmarshal([]byte(value), &n); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", Annotation, err)
+	}
+	return &n, nil
+}
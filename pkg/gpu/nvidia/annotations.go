@@ -0,0 +1,88 @@
+package nvidia
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Annotations of the allocation of a container, passed by kubelet to the
+	// container runtime for its hooks and to monitoring agents.
+
+	// AllocationGPUsAnnotation lists the UUIDs of the physical GPUs of the
+	// container, in the order of NVIDIA_VISIBLE_DEVICES.
+	AllocationGPUsAnnotation = "hkube.io/vgpu.gpus"
+	// AllocationDevicesAnnotation lists the virtual GPUs of the container,
+	// sorted.
+	AllocationDevicesAnnotation = "hkube.io/vgpu.devices"
+	// AllocationShareAnnotation lists the share of every physical GPU the
+	// container received, as <UUID>=<virtual GPUs>/<virtual GPUs of the GPU>.
+	AllocationShareAnnotation = "hkube.io/vgpu.share"
+	// AllocationMemoryAnnotation lists the memory, in MiB, the container
+	// received on every physical GPU, as <UUID>=<MiB>.
+	AllocationMemoryAnnotation = "hkube.io/vgpu.memory-mib"
+
+	// cdiAnnotationPrefix prefixes the annotations requesting Container
+	// Device Interface devices from the container runtime.
+	cdiAnnotationPrefix = "cdi.k8s.io/"
+	// cdiDeviceKind is the kind of the GPUs in the CDI specification
+	// generated by the NVIDIA container toolkit.
+	cdiDeviceKind = "nvidia.com/gpu"
+)
+
+// cdiAnnotationKey returns the CDI annotation of the devices of the
+// resource, e.g. cdi.k8s.io/hkube-vgpu_nvidia.com-gpu.
+func cdiAnnotationKey(resourceName string) string {
+	return cdiAnnotationPrefix + "hkube-vgpu_" + strings.Replace(resourceName, "/", "-", -1)
+}
+
+// annotate sets an annotation of the response of the allocation.
+func (a *containerAllocation) annotate(key, value string) {
+	if a.response.Annotations == nil {
+		a.response.Annotations = make(map[string]string)
+	}
+	a.response.Annotations[key] = value
+}
+
+// gpuUUIDs returns the UUIDs of the physical GPUs of the allocation.
+func (m *NvidiaDevicePlugin) gpuUUIDs(a *containerAllocation) []string {
+	uuids := make([]string, 0, len(a.gpus))
+	for _, id := range a.gpus {
+		uuids = append(uuids, m.gpus[id].UUID)
+	}
+	return uuids
+}
+
+// composeCDI requests the GPUs of the allocation as CDI devices.
+func composeCDI(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	var devices []string
+	for _, uuid := range m.gpuUUIDs(a) {
+		devices = append(devices, cdiDeviceKind+"="+uuid)
+	}
+	a.annotate(cdiAnnotationKey(m.resourceName), strings.Join(devices, ","))
+	return nil
+}
+
+// composeAnnotations describes the physical GPUs, virtual GPUs and share of
+// every GPU of the allocation.
+func composeAnnotations(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	devices := append([]string(nil), a.ids...)
+	sort.Strings(devices)
+
+	var shares, memories []string
+	for _, share := range m.gpuShares(a.gpus, a.ids) {
+		shares = append(shares, fmt.Sprintf("%s=%d/%d", share.UUID, share.VGPUs, share.TotalVGPUs))
+		if share.MemoryMiB > 0 {
+			memories = append(memories, fmt.Sprintf("%s=%d", share.UUID, share.MemoryMiB))
+		}
+	}
+
+	a.annotate(AllocationGPUsAnnotation, strings.Join(m.gpuUUIDs(a), ","))
+	a.annotate(AllocationDevicesAnnotation, strings.Join(devices, ","))
+	a.annotate(AllocationShareAnnotation, strings.Join(shares, ","))
+	if len(memories) > 0 {
+		a.annotate(AllocationMemoryAnnotation, strings.Join(memories, ","))
+	}
+	return nil
+}
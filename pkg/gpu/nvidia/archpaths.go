@@ -0,0 +1,56 @@
+package nvidia
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// nvmlLibrary is the driver library every driver install ships, whose
+// directory holds the driver libraries.
+const nvmlLibrary = "libnvidia-ml.so.1"
+
+// multiarchTriplets are the Debian multiarch library directories of the
+// architectures with NVIDIA drivers, by GOARCH.
+var multiarchTriplets = map[string]string{
+	"amd64":   "x86_64-linux-gnu",
+	"arm64":   "aarch64-linux-gnu",
+	"ppc64le": "powerpc64le-linux-gnu",
+}
+
+// libDir returns the multiarch library directory of the architecture of the
+// plugin, e.g. /usr/lib/aarch64-linux-gnu on ARM nodes such as GH200.
+func libDir() string {
+	if triplet, ok := multiarchTriplets[runtime.GOARCH]; ok {
+		return filepath.Join("/usr/lib", triplet)
+	}
+	return "/usr/lib"
+}
+
+// driverLibDirs returns the directories where a driver installed under root
+// keeps its libraries, for the architecture of the plugin: root itself, the
+// multiarch directory of Debian based installs, then /usr/lib64 of RPM based
+// ones.
+func driverLibDirs(root string) []string {
+	return []string{
+		root,
+		filepath.Join(root, libDir()),
+		filepath.Join(root, "/usr/lib64"),
+		filepath.Join(root, "/usr/lib"),
+	}
+}
+
+// resolveDriverRoot returns the directory of the driver libraries installed
+// under root, e.g. /run/nvidia/driver/usr/lib/aarch64-linux-gnu for the root
+// /run/nvidia/driver of a driver container on an ARM node, so that the same
+// configuration applies to every architecture. root is returned as is when
+// no directory holds the libraries, e.g. when the driver is not installed
+// yet.
+func resolveDriverRoot(root string) string {
+	for _, dir := range driverLibDirs(root) {
+		if _, err := os.Stat(filepath.Join(dir, nvmlLibrary)); err == nil {
+			return dir
+		}
+	}
+	return root
+}
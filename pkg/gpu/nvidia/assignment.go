@@ -0,0 +1,228 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/audit"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const (
+	// assignmentAnnotation records, for every container of a pod, the UUIDs,
+	// or indexes with sequential device IDs, of the physical GPUs backing its
+	// virtual GPUs.
+	assignmentAnnotation = "hkube.io/gpu-assignment"
+
+	// Kubelet only reports the devices of a container once Allocate returned,
+	// so the owner of an allocation is looked up for a while.
+	assignmentRetryInterval = time.Second
+	assignmentTimeout       = time.Minute
+)
+
+// assignmentRecorder resolves the pods owning allocated virtual GPUs and
+// records the physical GPUs they received as a pod annotation, when client is
+// set, in the audit log, when auditLog is set, and sends them to the webhook,
+// when webhook is set.
+type assignmentRecorder struct {
+	client   kubernetes.Interface
+	auditLog *audit.Log
+	webhook  *allocationWebhook
+	node     string
+	pending  chan pendingAssignment
+}
+
+// pendingAssignment is the allocation of the devices ids of resource to a
+// container, holding the shares gpus of the physical GPUs.
+type pendingAssignment struct {
+	resource string
+	ids      []string
+	gpus     []gpuShare
+}
+
+func newAssignmentRecorder(client kubernetes.Interface, auditLog *audit.Log, webhook *allocationWebhook, node string) *assignmentRecorder {
+	return &assignmentRecorder{
+		client:   client,
+		auditLog: auditLog,
+		webhook:  webhook,
+		node:     node,
+		pending:  make(chan pendingAssignment, 100),
+	}
+}
+
+// record queues the virtual GPUs allocated to a container. It never blocks
+// Allocate, allocations are dropped when the queue is full.
+func (r *assignmentRecorder) record(resource string, ids []string, gpus []gpuShare) {
+	select {
+	case r.pending <- pendingAssignment{resource: resource, ids: ids, gpus: gpus}:
+	default:
+		log.Printf("Assignment queue full, not recording the pod of %v", ids)
+	}
+}
+
+// run records the queued allocations until stop is closed.
+func (r *assignmentRecorder) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case a := <-r.pending:
+			go r.resolve(a, stop)
+		}
+	}
+}
+
+// findContainer returns the pod and container holding all the devices ids of
+// resource. The same device IDs are advertised by several resources, e.g.
+// with --per-gpu-resources, so the devices of other resources are ignored.
+func findContainer(pods []*podresourcesapi.PodResources, resource string, ids []string) (*podresourcesapi.PodResources, *podresourcesapi.ContainerResources) {
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			found := make(map[string]bool)
+			for _, d := range c.Devices {
+				if d.ResourceName != resource {
+					continue
+				}
+				for _, id := range d.DeviceIds {
+					found[id] = true
+				}
+			}
+
+			all := true
+			for _, id := range ids {
+				all = all && found[id]
+			}
+			if all {
+				return pod, c
+			}
+		}
+	}
+	return nil, nil
+}
+
+// resolve waits for kubelet to report the container owning the virtual GPUs
+// and records its assignment.
+func (r *assignmentRecorder) resolve(a pendingAssignment, stop <-chan struct{}) {
+	ids := a.ids
+	allocated := time.Now()
+	deadline := allocated.Add(assignmentTimeout)
+	for time.Now().Before(deadline) {
+		pods, err := listPodResources()
+		if err != nil {
+			log.Printf("Failed to list pod resources: %v", err)
+		} else if pod, container := findContainer(pods, a.resource, ids); pod != nil {
+			if r.client != nil {
+				if err := r.annotate(pod.Namespace, pod.Name, container.Name, ids); err != nil {
+					log.Printf("Failed to record GPU assignment of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				}
+			}
+			r.audit(allocated, pod.Namespace, pod.Name, container.Name, ids)
+			r.notify(allocated, pod.Namespace, pod.Name, container.Name, a)
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(assignmentRetryInterval):
+		}
+	}
+	log.Printf("No pod found owning virtual GPUs %v", ids)
+	r.audit(allocated, "", "", "", ids)
+	r.notify(allocated, "", "", "", a)
+}
+
+// physicalGPUs returns the sorted physical GPUs backing the virtual GPUs.
+func physicalGPUs(ids []string) []string {
+	var gpus []string
+	for _, id := range ids {
+		if gpu := getPhysicalDeviceID(id); !physicialDeviceExists(gpus, gpu) {
+			gpus = append(gpus, gpu)
+		}
+	}
+	sort.Strings(gpus)
+	return gpus
+}
+
+// audit appends the allocation to the audit log. Allocations whose pod could
+// not be found are recorded without a pod.
+func (r *assignmentRecorder) audit(allocated time.Time, namespace, name, container string, ids []string) {
+	if r.auditLog == nil {
+		return
+	}
+
+	err := r.auditLog.Write(audit.Record{
+		Time:      allocated.UTC(),
+		Node:      r.node,
+		Namespace: namespace,
+		Pod:       name,
+		Container: container,
+		DeviceIDs: ids,
+		GPUs:      physicalGPUs(ids),
+	})
+	if err != nil {
+		log.Printf("Failed to write audit record of virtual GPUs %v: %v", ids, err)
+	}
+}
+
+// notify sends the allocation to the webhook. Allocations whose pod could not
+// be found are sent without a pod.
+func (r *assignmentRecorder) notify(allocated time.Time, namespace, name, container string, a pendingAssignment) {
+	if r.webhook == nil {
+		return
+	}
+	r.webhook.allocated(allocationEvent{
+		Time:      allocated.UTC(),
+		Node:      r.node,
+		Namespace: namespace,
+		Pod:       name,
+		Container: container,
+		Resource:  a.resource,
+		DeviceIDs: a.ids,
+		GPUs:      a.gpus,
+	})
+}
+
+// annotate merges the physical GPUs of the container into the assignment
+// annotation of the pod.
+func (r *assignmentRecorder) annotate(namespace, name, container string, ids []string) error {
+	pod, err := r.client.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	assignment := make(map[string][]string)
+	if value, ok := pod.Annotations[assignmentAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &assignment); err != nil {
+			log.Printf("Overwriting invalid %s annotation of pod %s/%s", assignmentAnnotation, namespace, name)
+			assignment = make(map[string][]string)
+		}
+	}
+
+	gpus := physicalGPUs(ids)
+	assignment[container] = gpus
+
+	value, err := json.Marshal(assignment)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{assignmentAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.CoreV1().Pods(namespace).Patch(name, types.MergePatchType, patch)
+	if err == nil {
+		log.Printf("Pod %s/%s container %s runs on GPUs %v", namespace, name, container, gpus)
+	}
+	return err
+}
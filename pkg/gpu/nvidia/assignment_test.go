@@ -0,0 +1,35 @@
+package nvidia
+
+import (
+	"testing"
+
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+// podHolding returns the pod name with a container c holding the devices of
+// every resource.
+func podHolding(name string, devices map[string][]string) *podresourcesapi.PodResources {
+	c := &podresourcesapi.ContainerResources{Name: "c"}
+	for resource, ids := range devices {
+		c.Devices = append(c.Devices, &podresourcesapi.ContainerDevices{ResourceName: resource, DeviceIds: ids})
+	}
+	return &podresourcesapi.PodResources{Name: name, Namespace: "default", Containers: []*podresourcesapi.ContainerResources{c}}
+}
+
+func TestFindContainerMatchesTheResource(t *testing.T) {
+	pods := []*podresourcesapi.PodResources{
+		podHolding("per-gpu", map[string][]string{"hkube.io/gpu-0-vgpu": {"0-0", "0-1"}}),
+		podHolding("split", map[string][]string{"hkube.io/gpu-0-vgpu": {"0-2"}, resourceName: {"0-3"}}),
+		podHolding("shared", map[string][]string{resourceName: {"0-0", "0-1"}}),
+	}
+
+	if pod, _ := findContainer(pods, resourceName, []string{"0-0", "0-1"}); pod == nil || pod.Name != "shared" {
+		t.Errorf("found %v, want the pod holding the devices through %s", pod, resourceName)
+	}
+	if pod, _ := findContainer(pods, "hkube.io/gpu-0-vgpu", []string{"0-1"}); pod == nil || pod.Name != "per-gpu" {
+		t.Errorf("found %v, want the pod holding the devices through hkube.io/gpu-0-vgpu", pod)
+	}
+	if pod, _ := findContainer(pods, resourceName, []string{"0-2", "0-3"}); pod != nil {
+		t.Errorf("found %s, whose devices are held through two resources", pod.Name)
+	}
+}
@@ -0,0 +1,116 @@
+package nvidia
+
+import (
+	"golang.org/x/net/context"
+)
+
+// GPU is a physical GPU of the node.
+type GPU struct {
+	Index int
+	UUID  string
+	Path  string
+	// Model is empty and Memory, in MiB, zero when the driver does not
+	// report them.
+	Model  string
+	Memory uint64
+	// ComputeCapability is the CUDA compute capability of the GPU as an SM
+	// version, e.g. 80 for sm_80, zero when unknown.
+	ComputeCapability int
+	// VGPUProfile is the NVIDIA vGPU (GRID) profile of the GPU, e.g. T4-4Q,
+	// when the hypervisor hands the node a vGPU instead of a physical GPU.
+	// It is only set by the GRID backend.
+	VGPUProfile string
+	// MIG is set when the GPU is a MIG device of a physical GPU, shared
+	// instead of the physical GPU. Its Index and Path are those of the
+	// physical GPU. It is only set by the MIG backend.
+	MIG *MIGDevice
+}
+
+// GPUUsage is the current usage of a physical GPU.
+type GPUUsage struct {
+	// Utilization is a percentage.
+	Utilization uint
+	// MemoryFree is in MiB.
+	MemoryFree uint64
+}
+
+// GPUProcess is a process using a physical GPU.
+type GPUProcess struct {
+	PID int
+	// MemoryUsed is in MiB.
+	MemoryUsed uint64
+}
+
+// HealthEvent reports a physical GPU which is no longer usable.
+type HealthEvent struct {
+	// UUID is empty when the event concerns every GPU.
+	UUID string
+	// Xid is the critical Xid error raised by the GPU, zero when the GPU can
+	// not be health checked at all.
+	Xid uint64
+	// ECC reports a double bit ECC error instead of an Xid error.
+	ECC bool
+}
+
+// DriverInfo describes the installed driver.
+type DriverInfo struct {
+	Version string
+	// CUDAMajor and CUDAMinor are the CUDA version supported by the driver,
+	// nil when unknown.
+	CUDAMajor *uint
+	CUDAMinor *uint
+	// OpenKernelModules reports whether the open kernel modules of NVIDIA
+	// drive the GPUs.
+	OpenKernelModules bool
+	// ConfidentialComputing reports whether the GPUs run in confidential
+	// computing (CC) mode, where the driver restricts their telemetry.
+	ConfidentialComputing bool
+}
+
+// ContainerEdits are the vendor specific parts of the allocation of physical
+// GPUs to a container.
+type ContainerEdits struct {
+	// Envs select the allocated GPUs for the container runtime or the
+	// driver.
+	Envs map[string]string
+	// DeviceNodes are the host device nodes the container needs.
+	DeviceNodes []string
+	// DriverDir is the host directory of the driver libraries, mounted at
+	// DriverContainerDir, the driver root of the plugin when empty. Both are
+	// empty when the container runtime injects the driver.
+	DriverDir          string
+	DriverContainerDir string
+	// HostDirs are other host directories the driver needs, mounted
+	// read-only at the same path.
+	HostDirs []string
+}
+
+// DeviceBackend is the access of the device plugin to the GPUs and their
+// driver. The slicing of the GPUs into virtual GPUs, the device plugin servers
+// and the sharing policies are built on top of it, independently of the GPU
+// vendor.
+type DeviceBackend interface {
+	// Init prepares the backend, it is called before any other method.
+	Init() error
+	// Shutdown releases the backend.
+	Shutdown() error
+	// Discover returns the physical GPUs of the node.
+	Discover() ([]GPU, error)
+	// GetHealthEvents sends the health events of the GPUs with the given
+	// UUIDs on events until ctx is done.
+	GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error
+	// GetUtilization returns the usage of every physical GPU by UUID.
+	GetUtilization() (map[string]GPUUsage, error)
+	// GetProcesses returns the processes using every physical GPU by UUID.
+	GetProcesses() (map[string][]GPUProcess, error)
+	// GetDriverInfo returns the versions of the driver.
+	GetDriverInfo() (DriverInfo, error)
+	// SetComputeMode sets the compute mode, e.g. ComputeModeDefault, of the
+	// physical GPU with the given UUID.
+	SetComputeMode(uuid, mode string) error
+	// ContainerEdits returns what a container needs to use the physical GPUs
+	// gpus, as discovered, whose IDs are ids, their UUID or index as in the
+	// virtual GPU IDs. It is called on every allocation and must not query
+	// the GPUs.
+	ContainerEdits(ids []string, gpus []GPU) ContainerEdits
+}
@@ -0,0 +1,5 @@
+package nvidia
+
+// sysBPF is the number of the bpf system call, missing from package syscall
+// on amd64.
+const sysBPF = 321
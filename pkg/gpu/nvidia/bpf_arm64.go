@@ -0,0 +1,4 @@
+package nvidia
+
+// sysBPF is the number of the bpf system call.
+const sysBPF = 280
@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package nvidia
+
+// sysBPF is unknown on the other architectures, the device policy can not be
+// verified there.
+const sysBPF = 0
@@ -0,0 +1,162 @@
+package nvidia
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/budget"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// budgetWriter writes the budget files of the containers in the budget
+// directory, removing the files of the previous containers of the virtual
+// GPUs.
+type budgetWriter struct {
+	dir string
+
+	sync.Mutex
+	// files are the budget files by virtual GPU.
+	files map[string]string
+	// written records when every budget file was last written.
+	written map[string]time.Time
+}
+
+func newBudgetWriter(dir string) *budgetWriter {
+	return &budgetWriter{dir: dir, files: make(map[string]string), written: make(map[string]time.Time)}
+}
+
+// budgetFileName returns the name of the budget file of the container with
+// the given virtual GPUs, which can not be allocated to another container
+// at the same time.
+func budgetFileName(ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// write writes the budget file of the container with the given virtual GPUs
+// and returns its path.
+func (w *budgetWriter) write(ids []string, b *budget.Container) (string, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(w.dir, budgetFileName(ids))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for _, id := range ids {
+		if old, ok := w.files[id]; ok && old != path {
+			os.Remove(old)
+			delete(w.written, old)
+		}
+		w.files[id] = path
+	}
+	w.written[path] = time.Now()
+	return path, nil
+}
+
+// prune removes the budget files written before t which are used by none of
+// the live keys, including the files left over by a previous run, and
+// returns the number of files removed.
+func (w *budgetWriter) prune(live map[string]bool, t time.Time) int {
+	w.Lock()
+	defer w.Unlock()
+
+	used := make(map[string]bool)
+	for id, path := range w.files {
+		if live[id] || w.written[path].After(t) {
+			used[path] = true
+		}
+	}
+	for id, path := range w.files {
+		if !used[path] {
+			delete(w.files, id)
+		}
+	}
+
+	removed := 0
+	names, err := filepath.Glob(filepath.Join(w.dir, "*.json"))
+	if err != nil {
+		return 0
+	}
+	for _, path := range names {
+		if used[path] {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.ModTime().After(t) {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			delete(w.written, path)
+			removed++
+		}
+	}
+	return removed
+}
+
+// containerBudget returns the budget of the container with the given devices.
+func (m *NvidiaDevicePlugin) containerBudget(ids []string) *budget.Container {
+	vGPUs := m.vGPUs(ids)
+	memory := m.memoryBudgets(ids)
+	b := &budget.Container{VGPUs: vGPUs}
+	perGPU := make(map[string][]string)
+	var order []string
+	for _, id := range vGPUs {
+		gpu := getPhysicalDeviceID(id)
+		if _, ok := perGPU[gpu]; !ok {
+			order = append(order, gpu)
+		}
+		perGPU[gpu] = append(perGPU[gpu], id)
+	}
+
+	for _, id := range order {
+		gpu := m.gpus[id]
+		b.GPUs = append(b.GPUs, budget.GPU{
+			Index:          gpu.Index,
+			UUID:           gpu.UUID,
+			VGPUs:          perGPU[id],
+			MemoryMiB:      memory[id],
+			ComputePercent: m.computeShare(gpu, len(perGPU[id])),
+		})
+	}
+	return b
+}
+
+// budgetMount writes the budget file of the container with the given devices
+// and returns its mount.
+func (m *NvidiaDevicePlugin) budgetMount(ids []string) (*pluginapi.Mount, error) {
+	// The devices of the resources sharing the virtual GPUs may have the
+	// same IDs.
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, m.resourceName+"/"+id)
+	}
+	path, err := m.budgets.write(keys, m.containerBudget(ids))
+	if err != nil {
+		return nil, err
+	}
+	return &pluginapi.Mount{
+		ContainerPath: budget.ContainerPath,
+		HostPath:      path,
+		ReadOnly:      true,
+	}, nil
+}
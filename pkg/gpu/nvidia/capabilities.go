@@ -0,0 +1,121 @@
+package nvidia
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const procSelfStatus = "/proc/self/status"
+
+// capabilityNames are the Linux capabilities indexed by their bit number.
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// seccompModes are the values of the Seccomp field of /proc/self/status.
+var seccompModes = map[string]string{"0": "disabled", "1": "strict", "2": "filter"}
+
+// neededCapabilities returns the capabilities the plugin may use with the
+// given configuration, along with the reason. NVML, the device plugin
+// sockets and the Kubernetes API only need file permissions, see
+// requiredPermissions.
+func neededCapabilities(config Config) map[string]string {
+	needed := make(map[string]string)
+	if config.SELinuxLabel != "" {
+		needed["CAP_FOWNER"] = "relabel the injected files the plugin user does not own"
+	}
+	if config.VerifyDevicePolicy {
+		needed["CAP_NET_ADMIN"] = "query the device controller programs of the container cgroups"
+	}
+	return needed
+}
+
+// readProcStatus returns the fields of /proc/self/status.
+func readProcStatus() (map[string]string, error) {
+	f, err := os.Open(procSelfStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return fields, s.Err()
+}
+
+// capabilityList returns the names of the capabilities set in the hexadecimal
+// capability mask.
+func capabilityList(mask string) ([]string, error) {
+	bits, err := strconv.ParseUint(mask, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capability mask %q: %v", mask, err)
+	}
+
+	var caps []string
+	for i := uint(0); i < 64; i++ {
+		if bits&(1<<i) == 0 {
+			continue
+		}
+		if int(i) < len(capabilityNames) {
+			caps = append(caps, capabilityNames[i])
+		} else {
+			caps = append(caps, fmt.Sprintf("CAP_%d", i))
+		}
+	}
+	return caps, nil
+}
+
+// reportCapabilities logs the effective capabilities and the seccomp mode of
+// the plugin, warning about the capabilities it does not need so that they
+// can be dropped from the DaemonSet.
+func reportCapabilities(config Config) {
+	status, err := readProcStatus()
+	if err != nil {
+		log.Printf("Failed to read %s, skipping capability check: %v", procSelfStatus, err)
+		return
+	}
+
+	caps, err := capabilityList(status["CapEff"])
+	if err != nil {
+		log.Printf("Failed to check capabilities: %v", err)
+		return
+	}
+
+	mode, ok := seccompModes[status["Seccomp"]]
+	if !ok {
+		mode = "unknown"
+	}
+	log.Printf("Running with seccomp %s and %d effective capabilities", mode, len(caps))
+	if mode == "disabled" {
+		log.Println("Warning: seccomp is disabled, the plugin supports the runtime/default profile")
+	}
+
+	needed := neededCapabilities(config)
+	for _, c := range caps {
+		if reason, ok := needed[c]; ok {
+			log.Printf("Capability %s is used to %s", c, reason)
+			continue
+		}
+		log.Printf("Warning: capability %s is not needed and can be dropped", c)
+	}
+}
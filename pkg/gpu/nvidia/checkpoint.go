@@ -0,0 +1,269 @@
+package nvidia
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpointVersion is the version of the checkpoint format. Checkpoints of
+// other versions are ignored.
+const checkpointVersion = 1
+
+// checkpoint is the state of the plugin kept across restarts and upgrades,
+// so that running pods keep the virtual GPU IDs they were allocated.
+type checkpoint struct {
+	Version int `json:"version"`
+	// Checksum is the SHA-256 of the checkpoint without checksum, telling a
+	// corrupt checkpoint apart.
+	Checksum string `json:"checksum,omitempty"`
+	// SequentialDeviceIDs records the ID scheme of GPUs, which are not
+	// restored when the scheme changed.
+	SequentialDeviceIDs bool `json:"sequentialDeviceIDs"`
+	// BootID identifies the boot of the node the allocations were made
+	// during, they are not restored after a reboot.
+	BootID string `json:"bootID,omitempty"`
+	// GPUs maps the UUIDs of the physical GPUs to their ID in the virtual GPU
+	// IDs.
+	GPUs map[string]string `json:"gpus"`
+	// Allocations are the allocated virtual GPUs by ID.
+	Allocations map[string]checkpointAllocation `json:"allocations"`
+}
+
+// checkpointAllocation is the allocation of a virtual GPU.
+type checkpointAllocation struct {
+	Resource string    `json:"resource"`
+	Time     time.Time `json:"time"`
+}
+
+// bootIDFile identifies the current boot of the node.
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// bootID returns the ID of the current boot of the node, empty when unknown.
+func bootID() string {
+	b, err := ioutil.ReadFile(bootIDFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// corruptCheckpointError is returned by readCheckpoint when the checkpoint
+// can not be trusted.
+type corruptCheckpointError struct {
+	path string
+	err  error
+}
+
+func (e *corruptCheckpointError) Error() string {
+	return fmt.Sprintf("corrupt checkpoint %s: %v", e.path, e.err)
+}
+
+// checksum returns the checksum of c, ignoring its own.
+func (c checkpoint) checksum() (string, error) {
+	c.Checksum = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readCheckpoint reads the checkpoint at path, nil when there is none.
+// Checkpoints written before checksums were recorded are not verified.
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, &corruptCheckpointError{path, err}
+	}
+	if c.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d in %s, expected %d", c.Version, path, checkpointVersion)
+	}
+	if c.Checksum != "" {
+		sum, err := c.checksum()
+		if err != nil {
+			return nil, &corruptCheckpointError{path, err}
+		}
+		if sum != c.Checksum {
+			return nil, &corruptCheckpointError{path, fmt.Errorf("checksum %s, expected %s", sum, c.Checksum)}
+		}
+	}
+	return &c, nil
+}
+
+// writeCheckpoint replaces the checkpoint at path, atomically so that a crash
+// never leaves a partial checkpoint behind.
+func writeCheckpoint(path string, c *checkpoint) error {
+	c.Version = checkpointVersion
+	sum, err := c.checksum()
+	if err != nil {
+		return err
+	}
+	c.Checksum = sum
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data, 0600)
+}
+
+// writeFileSync replaces the file at path with data through a temporary file,
+// flushing both the file and its directory to disk so that the new content
+// survives a crash of the node.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// restoreGPUIDs returns the IDs of the physical GPUs by UUID: the GPUs of the
+// checkpoint keep their ID, the other ones get their own unless it is taken,
+// in which case they get the lowest free index.
+func restoreGPUIDs(config Config, gpus []GPU, c *checkpoint) map[string]string {
+	ids := make(map[string]string, len(gpus))
+	taken := make(map[string]bool, len(gpus))
+	if c != nil && c.SequentialDeviceIDs == config.SequentialDeviceIDs {
+		for _, gpu := range gpus {
+			if id, ok := c.GPUs[gpu.UUID]; ok {
+				ids[gpu.UUID] = id
+				taken[id] = true
+			}
+		}
+	}
+
+	for _, gpu := range gpus {
+		if _, ok := ids[gpu.UUID]; ok {
+			continue
+		}
+		id := config.gpuID(gpu)
+		for i := 0; taken[id]; i++ {
+			id = strconv.Itoa(i)
+		}
+		if id != config.gpuID(gpu) {
+			log.Printf("GPU %d keeps ID %s, its own ID %s is restored for another GPU.", gpu.Index, id, config.gpuID(gpu))
+		}
+		ids[gpu.UUID] = id
+		taken[id] = true
+	}
+	return ids
+}
+
+// loadCheckpoint restores the IDs of the physical GPUs and the allocations of
+// their virtual GPUs from the checkpoint file. It is called by discover once
+// the GPUs are known.
+func (vgm *vGPUManager) loadCheckpoint(gpus []GPU) {
+	c, err := readCheckpoint(vgm.config.CheckpointFile)
+	if _, ok := err.(*corruptCheckpointError); ok {
+		// Kept for inspection, the next checkpoint replaces the file.
+		aside := vgm.config.CheckpointFile + ".corrupt"
+		os.Rename(vgm.config.CheckpointFile, aside)
+		log.Printf("Warning: %v, rediscovering the GPUs and the allocations from kubelet, moved it to %s", err, aside)
+		c = nil
+	} else if err != nil {
+		log.Printf("Warning: ignoring checkpoint: %v", err)
+		c = nil
+	}
+
+	vgm.config.gpuIDs = restoreGPUIDs(vgm.config, gpus, c)
+	if c == nil {
+		return
+	}
+	// The containers were restarted by the reboot, the allocations are
+	// rebuilt from the state of kubelet instead.
+	if boot := bootID(); c.BootID != "" && boot != "" && c.BootID != boot {
+		log.Printf("The node rebooted since checkpoint %s, restored the GPU IDs only.", vgm.config.CheckpointFile)
+		return
+	}
+
+	known := make(map[string]bool, len(gpus))
+	for _, id := range vgm.config.gpuIDs {
+		known[id] = true
+	}
+	restored := 0
+	for id, a := range c.Allocations {
+		if known[getPhysicalDeviceID(id)] {
+			vgm.ledger.restore(id, a.Resource, a.Time)
+			restored++
+		}
+	}
+	log.Printf("Restored the GPU IDs and %d allocations from checkpoint %s.", restored, vgm.config.CheckpointFile)
+}
+
+// saveCheckpoints writes the checkpoint file whenever the allocations change,
+// until stop is closed or the node is handed over.
+func (vgm *vGPUManager) saveCheckpoints(stop <-chan struct{}) {
+	changes := vgm.ledger.subscribe()
+	boot := bootID()
+	if vgm.config.Handover {
+		// The running instance keeps the checkpoint until the node is
+		// handed over.
+		select {
+		case <-stop:
+			return
+		case <-vgm.claimed:
+		}
+	}
+	for {
+		select {
+		case <-vgm.handedOver:
+			return
+		default:
+		}
+
+		c := &checkpoint{
+			SequentialDeviceIDs: vgm.config.SequentialDeviceIDs,
+			BootID:              boot,
+			GPUs:                vgm.config.gpuIDs,
+			Allocations:         vgm.ledger.allocations(),
+		}
+		if err := writeCheckpoint(vgm.config.CheckpointFile, c); err != nil {
+			log.Printf("Failed to write checkpoint %s: %v", vgm.config.CheckpointFile, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-changes:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compatVersions are the versions of k8s.io/kubelet whose device plugin API
+// the plugin is checked against, overridden by PLUGINAPI_COMPAT_VERSIONS.
+// Kubernetes 1.16, the version the plugin is built against, is exercised
+// through the fake kubelet of pkg/kubelettest.
+var compatVersions = []string{"v0.17.17", "v0.20.15", "v0.23.17", "v0.26.15", "v0.28.15"}
+
+// goCommand runs the go command in dir.
+func goCommand(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+// TestPluginAPICompatibility checks the plugin against the kubelet side of
+// the v1beta1 device plugin API generated by several Kubernetes versions, so
+// that upgrading the pluginapi dependency can not silently break older
+// kubelets. For every version it builds testdata/compat-kubelet against
+// k8s.io/kubelet at that version, which registers the plugin and calls
+// ListAndWatch and Allocate. Versions whose modules can not be fetched, e.g.
+// offline, are skipped.
+func TestPluginAPICompatibility(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the compat kubelet of every version")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("the go command is not available")
+	}
+	source, err := filepath.Abs(filepath.Join("testdata", "compat-kubelet", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := compatVersions
+	if v := os.Getenv("PLUGINAPI_COMPAT_VERSIONS"); v != "" {
+		versions = strings.Fields(v)
+	}
+
+	work, err := ioutil.TempDir("", "vgpu-compat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	plugins := filepath.Join(work, "plugins")
+	if err := os.Mkdir(plugins, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.DevicePluginPath = plugins + "/"
+	backend := NewMockBackend(2)
+	defer backend.Shutdown()
+	served := servePlugins(t, newTestManager(t, config, backend))
+	defer served.Stop()
+
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			client := filepath.Join(work, "client-"+version)
+			if err := os.Mkdir(client, 0755); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(client, "main.go"), b, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if out, err := goCommand(client, "mod", "init", "compat-kubelet"); err != nil {
+				t.Fatalf("go mod init failed: %v\n%s", err, out)
+			}
+			if out, err := goCommand(client, "get", "k8s.io/kubelet@"+version); err != nil {
+				t.Skipf("k8s.io/kubelet %s is not available: %v\n%s", version, err, out)
+			}
+			for _, args := range [][]string{{"mod", "tidy"}, {"build", "-o", "compat-kubelet", "."}} {
+				if out, err := goCommand(client, args...); err != nil {
+					t.Fatalf("go %s failed: %v\n%s", strings.Join(args, " "), err, out)
+				}
+			}
+
+			// Creating the kubelet socket makes the plugin register again.
+			cmd := exec.Command(filepath.Join(client, "compat-kubelet"), "--dir", plugins, "--resource", resourceName)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("the kubelet of k8s.io/kubelet %s failed: %v\n%s", version, err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package nvidia
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// mpsPipeContainerDir is where containers find the pipes of the MPS
+	// control daemon, the default of the CUDA runtime.
+	mpsPipeContainerDir = "/tmp/nvidia-mps"
+	// envMPSPipeDirectory tells MPS clients where the pipes are.
+	envMPSPipeDirectory = "CUDA_MPS_PIPE_DIRECTORY"
+	// envMPSThreadPercentage caps the SMs the MPS client may use.
+	envMPSThreadPercentage = "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE"
+)
+
+// containerAllocation is the allocation of devices to a container, composed
+// into the response to its request.
+type containerAllocation struct {
+	// ids are the devices of the container.
+	ids []string
+	// gpus are the physical GPUs backing them, sorted by index.
+	gpus []string
+	// edits are what the backend needs the container to receive.
+	edits    ContainerEdits
+	response *pluginapi.ContainerAllocateResponse
+}
+
+// responseTemplate composes a part of the responses of the plugins whose
+// configuration it applies to. prepare adds the part which only depends on
+// the configuration once per plugin, compose the part depending on the
+// allocation to every response; either may be nil.
+type responseTemplate struct {
+	name    string
+	applies func(m *NvidiaDevicePlugin) bool
+	prepare func(m *NvidiaDevicePlugin, t *preparedTemplate)
+	compose func(m *NvidiaDevicePlugin, a *containerAllocation) error
+	// writesHost is set for the templates writing files on the host, which
+	// are skipped when the response is only inspected.
+	writesHost bool
+}
+
+// responseTemplates are the templates Config.ResponseTemplates selects, in
+// the order they compose the response to a container request by default. A
+// runtime variant is supported by adding its template.
+var responseTemplates = []responseTemplate{
+	{name: "toolkit", applies: always, compose: composeToolkit},
+	{name: "driver", applies: realGPUs, compose: composeDriver},
+	{name: "devices", applies: realGPUs, prepare: prepareDevices, compose: composeDevices},
+	{name: "graphics", applies: graphicsTemplate, prepare: prepareGraphics},
+	{name: "cuda-limiter", applies: limiterTemplate, prepare: prepareLimiter, compose: composeLimiter},
+	{name: "budget", applies: budgetTemplate, compose: composeBudget, writesHost: true},
+	{name: "mps", applies: mpsTemplate, prepare: prepareMPS, compose: composeMPS},
+	{name: "cdi", applies: cdiTemplate, compose: composeCDI},
+	{name: "annotations", applies: annotationsTemplate, compose: composeAnnotations},
+}
+
+// preparedTemplate is a template applying to a plugin, with the mounts and
+// environment it adds to every response of the plugin.
+type preparedTemplate struct {
+	responseTemplate
+	mounts []*pluginapi.Mount
+	envs   map[string]string
+}
+
+// DefaultResponseTemplates selects every template, in their default order.
+var DefaultResponseTemplates = responseTemplateNames()
+
+// responseTemplateNames returns the names of the templates.
+func responseTemplateNames() []string {
+	names := make([]string, 0, len(responseTemplates))
+	for _, t := range responseTemplates {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// findResponseTemplate returns the template with the given name.
+func findResponseTemplate(name string) (responseTemplate, bool) {
+	for _, t := range responseTemplates {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return responseTemplate{}, false
+}
+
+// ParseResponseTemplates parses the comma separated names of the response
+// templates, e.g. "toolkit,cdi".
+func ParseResponseTemplates(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func always(m *NvidiaDevicePlugin) bool { return true }
+
+// realGPUs holds for the GPUs which are not emulated, those only get the
+// environment.
+func realGPUs(m *NvidiaDevicePlugin) bool { return m.config.FakeGPUs == 0 }
+
+func graphicsTemplate(m *NvidiaDevicePlugin) bool {
+	return m.config.Graphics && m.config.FakeGPUs == 0
+}
+
+func limiterTemplate(m *NvidiaDevicePlugin) bool { return m.config.CUDALimiterDir != "" }
+
+func budgetTemplate(m *NvidiaDevicePlugin) bool { return m.budgets != nil }
+
+func mpsTemplate(m *NvidiaDevicePlugin) bool { return m.config.MPSPipeDir != "" }
+
+func cdiTemplate(m *NvidiaDevicePlugin) bool { return m.config.CDIAnnotations }
+
+func annotationsTemplate(m *NvidiaDevicePlugin) bool { return m.config.AllocationAnnotations }
+
+// composeContainer returns the response to the request of a container for
+// the devices ids.
+func (m *NvidiaDevicePlugin) composeContainer(ids []string) (*pluginapi.ContainerAllocateResponse, error) {
+	gpus := m.physicalGPUs(ids)
+	edits := m.containerEdits(gpus)
+	dir, err := m.driverDir(edits, gpus)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "invalid allocation request: %v", err)
+	}
+	edits.DriverDir = dir
+
+	a := &containerAllocation{ids: ids, gpus: gpus, edits: edits}
+	if err := m.compose(a, false); err != nil {
+		return nil, err
+	}
+	return a.response, nil
+}
+
+// containerEdits returns what the backend needs a container using the
+// physical GPUs with the given IDs to receive, from the GPUs discovered at
+// startup.
+func (m *NvidiaDevicePlugin) containerEdits(ids []string) ContainerEdits {
+	gpus := make([]GPU, len(ids))
+	for i, id := range ids {
+		gpus[i] = m.gpus[id]
+	}
+	return m.backend.ContainerEdits(ids, gpus)
+}
+
+// prepareTemplates selects the templates of the configuration applying to
+// the plugin and prepares their output, once the plugin is attached to its
+// GPUs.
+func (m *NvidiaDevicePlugin) prepareTemplates() {
+	m.templates = nil
+	for _, name := range m.config.ResponseTemplates {
+		t, ok := findResponseTemplate(name)
+		if !ok || !t.applies(m) {
+			continue
+		}
+		p := preparedTemplate{responseTemplate: t, envs: make(map[string]string)}
+		if t.prepare != nil {
+			t.prepare(m, &p)
+		}
+		m.templates = append(m.templates, p)
+	}
+}
+
+// compose composes the response of the allocation with the prepared
+// templates, skipping those writing on the host when the response is only
+// inspected. The prepared mounts are shared by the responses and must not be
+// modified.
+func (m *NvidiaDevicePlugin) compose(a *containerAllocation, inspect bool) error {
+	a.response = &pluginapi.ContainerAllocateResponse{Envs: make(map[string]string)}
+	for _, t := range m.templates {
+		if inspect && t.writesHost {
+			continue
+		}
+		a.response.Mounts = append(a.response.Mounts, t.mounts...)
+		for k, v := range t.envs {
+			a.response.Envs[k] = v
+		}
+		if t.compose == nil {
+			continue
+		}
+		if err := t.compose(m, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// composeToolkit selects the GPUs through the NVIDIA container runtime, which
+// injects the driver and the device nodes when the driver and devices
+// templates are left out.
+func composeToolkit(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	for k, v := range a.edits.Envs {
+		a.response.Envs[k] = v
+	}
+	return nil
+}
+
+// composeDriver mounts the driver and the host directories of the edits.
+func composeDriver(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	if a.edits.DriverDir != "" {
+		a.response.Mounts = append(a.response.Mounts, &pluginapi.Mount{
+			HostPath:      a.edits.DriverDir,
+			ContainerPath: a.edits.DriverContainerDir,
+			ReadOnly:      m.readOnlyMounts(),
+		})
+	}
+	for _, dir := range a.edits.HostDirs {
+		a.response.Mounts = append(a.response.Mounts, &pluginapi.Mount{
+			HostPath:      dir,
+			ContainerPath: dir,
+			ReadOnly:      true,
+		})
+	}
+	return nil
+}
+
+// prepareDevices computes the permissions granted on the device nodes.
+func prepareDevices(m *NvidiaDevicePlugin, t *preparedTemplate) {
+	permissions := parseDevicePermissions(m.config.DevicePermissions)
+	if m.minimalProfile() {
+		// CUDA issues ioctls on the control device, which must stay writable.
+		delete(permissions, 'm')
+	}
+	m.devicePermissions = permissions.String()
+}
+
+// composeDevices injects the device nodes of the edits, or those of the
+// minimal profile.
+func composeDevices(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	nodes := a.edits.DeviceNodes
+	if m.minimalProfile() {
+		nodes = m.minimalDeviceNodes(a)
+	}
+	for _, path := range nodes {
+		a.response.Devices = append(a.response.Devices, &pluginapi.DeviceSpec{
+			HostPath:      path,
+			ContainerPath: path,
+			Permissions:   m.devicePermissions,
+		})
+	}
+	return nil
+}
+
+// prepareGraphics mounts the Vulkan ICD directory.
+func prepareGraphics(m *NvidiaDevicePlugin, t *preparedTemplate) {
+	t.mounts = append(t.mounts, &pluginapi.Mount{
+		ContainerPath: vulkanICDContainerDir,
+		HostPath:      m.config.VulkanICDDir,
+		ReadOnly:      m.readOnlyMounts(),
+	})
+}
+
+// prepareLimiter mounts the CUDA limiter.
+func prepareLimiter(m *NvidiaDevicePlugin, t *preparedTemplate) {
+	t.mounts = append(t.mounts, &pluginapi.Mount{
+		ContainerPath: cudaLimiterContainerDir,
+		HostPath:      m.config.CUDALimiterDir,
+		ReadOnly:      true,
+	})
+}
+
+// composeLimiter sets the limits of the CUDA limiter.
+func composeLimiter(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	m.limit(a.response, a.gpus, a.ids)
+	return nil
+}
+
+// composeBudget writes the GPU budget file of the container and mounts it.
+func composeBudget(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	mount, err := m.budgetMount(a.ids)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to write GPU budget file: %v", err)
+	}
+	a.response.Mounts = append(a.response.Mounts, mount)
+	return nil
+}
+
+// prepareMPS connects the containers to the MPS control daemon of the node.
+func prepareMPS(m *NvidiaDevicePlugin, t *preparedTemplate) {
+	t.mounts = append(t.mounts, &pluginapi.Mount{
+		ContainerPath: mpsPipeContainerDir,
+		HostPath:      m.config.MPSPipeDir,
+	})
+	t.envs[envMPSPipeDirectory] = mpsPipeContainerDir
+}
+
+// composeMPS caps the active threads of the container to its compute share,
+// the largest of its GPUs as with the CUDA limiter.
+func composeMPS(m *NvidiaDevicePlugin, a *containerAllocation) error {
+	perGPU := make(map[string]int, len(a.gpus))
+	for _, id := range m.vGPUs(a.ids) {
+		perGPU[getPhysicalDeviceID(id)]++
+	}
+	max := 0
+	for id, n := range perGPU {
+		if share := m.computeShare(m.gpus[id], n); share > max {
+			max = share
+		}
+	}
+	if max > 0 {
+		a.response.Envs[envMPSThreadPercentage] = strconv.Itoa(max)
+	}
+	return nil
+}
+
+// minimalProfile reports whether only the devices strictly needed for compute
+// are injected.
+func (m *NvidiaDevicePlugin) minimalProfile() bool {
+	return m.config.DeviceProfile == DeviceProfileMinimal
+}
+
+// minimalDeviceNodes are the device nodes of the minimal profile: those of the
+// GPUs of the allocation, the control and the unified memory devices. The
+// NVSwitch and IMEX devices of the backend are left out.
+func (m *NvidiaDevicePlugin) minimalDeviceNodes(a *containerAllocation) []string {
+	gpus := make([]GPU, len(a.gpus))
+	for i, id := range a.gpus {
+		gpus[i] = m.gpus[id]
+	}
+	return append(gpuDeviceNodes(gpus), nvidiaControlDevice, nvidiaUVMDevice)
+}
+
+// readOnlyMounts reports whether the mounts of the driver and the graphics
+// libraries are read-only, they always are with the minimal profile.
+func (m *NvidiaDevicePlugin) readOnlyMounts() bool {
+	return m.config.ReadOnlyMounts || m.minimalProfile()
+}
+
+// devicePermissions are the cgroup permissions granted on a device node: "r"
+// to read, "w" to write and "m" to mknod.
+type devicePermissions map[rune]bool
+
+// devicePermissionsOrder is the order permissions are written in.
+const devicePermissionsOrder = "mrw"
+
+// parseDevicePermissions parses validated permissions, e.g. "mrw".
+func parseDevicePermissions(s string) devicePermissions {
+	p := make(devicePermissions, len(s))
+	for _, r := range s {
+		p[r] = true
+	}
+	return p
+}
+
+func (p devicePermissions) String() string {
+	var b strings.Builder
+	for _, r := range devicePermissionsOrder {
+		if p[r] {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,338 @@
+package nvidia
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of testdata with the current responses")
+
+// fabricBackend is a MockBackend whose containers also need the device nodes
+// of a NVLink fabric.
+type fabricBackend struct {
+	*MockBackend
+}
+
+func (b fabricBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	edits := b.MockBackend.ContainerEdits(ids, gpus)
+	edits.DeviceNodes = append(edits.DeviceNodes, "/dev/nvidia-nvswitch0", "/dev/nvidia-caps-imex-channels/channel0")
+	return edits
+}
+
+// composedDevices returns the device nodes and their permissions, and whether
+// every mount is read-only, in the response to a container request for ids.
+func composedDevices(t *testing.T, config Config, ids []string) (map[string]string, bool) {
+	t.Helper()
+	p := newTestManager(t, config, fabricBackend{NewMockBackend(2)}).newDevicePlugins()[0]
+	resp, err := p.composeContainer(ids)
+	if err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+
+	devices := make(map[string]string)
+	for _, d := range resp.Devices {
+		devices[d.HostPath] = d.Permissions
+	}
+	readOnly := true
+	for _, m := range resp.Mounts {
+		readOnly = readOnly && m.ReadOnly
+	}
+	return devices, readOnly
+}
+
+func TestMinimalProfileInjectsOnlyComputeDevices(t *testing.T) {
+	config := testConfig()
+	config.DeviceProfile = DeviceProfileMinimal
+	devices, readOnly := composedDevices(t, config, []string{"1-0", "1-1"})
+
+	want := map[string]string{
+		"/dev/nvidia1":      "rw",
+		nvidiaControlDevice: "rw",
+		nvidiaUVMDevice:     "rw",
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("got devices %v, want %v", devices, want)
+	}
+	if !readOnly {
+		t.Error("got writable mounts with the minimal profile")
+	}
+
+	config.DevicePermissions = "r"
+	devices, _ = composedDevices(t, config, []string{"0-0"})
+	if got := devices["/dev/nvidia0"]; got != "r" {
+		t.Errorf("got permissions %q, want %q", got, "r")
+	}
+}
+
+func TestDefaultProfileInjectsTheBackendDevices(t *testing.T) {
+	devices, readOnly := composedDevices(t, testConfig(), []string{"1-0"})
+
+	want := map[string]string{
+		"/dev/nvidia1":                            DefaultDevicePermissions,
+		nvidiaControlDevice:                       DefaultDevicePermissions,
+		nvidiaUVMDevice:                           DefaultDevicePermissions,
+		"/dev/nvidia-nvswitch0":                   DefaultDevicePermissions,
+		"/dev/nvidia-caps-imex-channels/channel0": DefaultDevicePermissions,
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("got devices %v, want %v", devices, want)
+	}
+	if readOnly {
+		t.Error("got read-only mounts without --read-only-mounts")
+	}
+}
+
+func TestDevicePermissions(t *testing.T) {
+	for s, want := range map[string]string{"mrw": "mrw", "wrm": "mrw", "wr": "rw", "m": "m"} {
+		if got := parseDevicePermissions(s).String(); got != want {
+			t.Errorf("permissions %q written as %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestResponseTemplatesSelection(t *testing.T) {
+	config := testConfig()
+	config.ResponseTemplates = []string{"toolkit"}
+	p := newTestManager(t, config, NewMockBackend(2)).newDevicePlugins()[0]
+	resp, err := p.composeContainer([]string{"1-0"})
+	if err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+	if resp.Envs["NVIDIA_VISIBLE_DEVICES"] != "1" || len(resp.Mounts) != 0 || len(resp.Devices) != 0 {
+		t.Errorf("toolkit passthrough got %v, want the environment only", resp)
+	}
+
+	config.ResponseTemplates = []string{"devices", "toolkit"}
+	p = newTestManager(t, config, NewMockBackend(2)).newDevicePlugins()[0]
+	if resp, err = p.composeContainer([]string{"1-0"}); err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+	if len(resp.Mounts) != 0 || len(resp.Devices) != 3 {
+		t.Errorf("got %d mounts and %d devices without the driver template, want only the 3 devices", len(resp.Mounts), len(resp.Devices))
+	}
+}
+
+func TestResponseTemplatesValidation(t *testing.T) {
+	cases := []struct {
+		name   string
+		config func(c *Config)
+	}{
+		{"empty", func(c *Config) { c.ResponseTemplates = nil }},
+		{"unknown", func(c *Config) { c.ResponseTemplates = []string{"toolkit", "mig"} }},
+		{"duplicate", func(c *Config) { c.ResponseTemplates = []string{"toolkit", "devices", "toolkit"} }},
+		{"graphics disabled", func(c *Config) {
+			c.Graphics = true
+			c.ResponseTemplates = []string{"toolkit", "driver", "devices"}
+		}},
+		{"mps disabled", func(c *Config) {
+			c.MPSPipeDir = "/tmp/nvidia-mps"
+			c.ResponseTemplates = []string{"toolkit"}
+		}},
+	}
+	for _, c := range cases {
+		config := testConfig()
+		c.config(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: invalid templates %v accepted", c.name, config.ResponseTemplates)
+		}
+	}
+
+	config := testConfig()
+	config.ResponseTemplates = ParseResponseTemplates(" cdi, toolkit ,,")
+	if err := config.Validate(); err != nil {
+		t.Errorf("valid templates %v rejected: %v", config.ResponseTemplates, err)
+	}
+}
+
+func TestMPSTemplate(t *testing.T) {
+	config := testConfig()
+	config.MPSPipeDir = "/run/nvidia-mps"
+	p := newTestManager(t, config, NewMockBackend(2)).newDevicePlugins()[0]
+	// A quarter of GPU 0 and half of GPU 1.
+	resp, err := p.composeContainer([]string{"0-0", "1-0", "1-1"})
+	if err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+
+	if got := resp.Envs[envMPSPipeDirectory]; got != mpsPipeContainerDir {
+		t.Errorf("got pipe directory %q, want %q", got, mpsPipeContainerDir)
+	}
+	if got := resp.Envs[envMPSThreadPercentage]; got != "50" {
+		t.Errorf("got active thread percentage %q, want the largest share, 50", got)
+	}
+	found := false
+	for _, m := range resp.Mounts {
+		found = found || (m.HostPath == config.MPSPipeDir && m.ContainerPath == mpsPipeContainerDir)
+	}
+	if !found {
+		t.Errorf("MPS pipe directory not mounted: %v", resp.Mounts)
+	}
+}
+
+func TestPreparedTemplatesAreSharedByTheResponses(t *testing.T) {
+	config := testConfig()
+	config.MPSPipeDir = "/run/nvidia-mps"
+	p := newTestManager(t, config, NewMockBackend(2)).newDevicePlugins()[0]
+	first, err := p.composeContainer([]string{"0-0"})
+	if err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+	second, err := p.composeContainer([]string{"1-0", "1-1"})
+	if err != nil {
+		t.Fatalf("failed to compose the response: %v", err)
+	}
+
+	last := len(first.Mounts) - 1
+	if first.Mounts[last] != second.Mounts[len(second.Mounts)-1] || first.Mounts[last].HostPath != config.MPSPipeDir {
+		t.Errorf("the MPS pipe mount is not prepared once: %v and %v", first.Mounts[last], second.Mounts[len(second.Mounts)-1])
+	}
+	if first.Envs[envMPSThreadPercentage] == second.Envs[envMPSThreadPercentage] {
+		t.Errorf("got active thread percentage %q for both allocations", first.Envs[envMPSThreadPercentage])
+	}
+}
+
+// goldenCases is the matrix of configurations whose allocation responses are
+// compared with the golden files of testdata. Each case edits testConfig, the
+// defaults of the command line on a GKE node with the driver in
+// /home/kubernetes/bin/nvidia, and allocates goldenRequest on two mock GPUs
+// unless it sets its own request and backend.
+var goldenCases = []struct {
+	name    string
+	config  func(c *Config)
+	request *pluginapi.AllocateRequest
+	backend func(t *testing.T) (DeviceBackend, func())
+}{
+	{name: "gke-root", config: func(c *Config) {}},
+	{name: "read-only-mounts", config: func(c *Config) { c.ReadOnlyMounts = true }},
+	{name: "graphics", config: func(c *Config) { c.Graphics = true }},
+	{name: "minimal-profile", config: func(c *Config) { c.DeviceProfile = DeviceProfileMinimal }},
+	{name: "device-permissions-rw", config: func(c *Config) { c.DevicePermissions = "rw" }},
+	{name: "cuda-limiter", config: func(c *Config) { c.CUDALimiterDir = "/home/kubernetes/bin/vgpu" }},
+	{name: "compute-throttle", config: func(c *Config) {
+		c.CUDALimiterDir = "/home/kubernetes/bin/vgpu"
+		c.ComputeEnforcement = ComputeEnforcementThrottle
+	}},
+	{name: "fake-gpus", config: func(c *Config) { c.FakeGPUs = 2 }},
+	{name: "allocation-annotations", config: func(c *Config) {
+		c.AllocationAnnotations = true
+		c.CDIAnnotations = true
+	}},
+	{name: "toolkit-passthrough", config: func(c *Config) { c.ResponseTemplates = []string{"toolkit"} }},
+	{name: "mps", config: func(c *Config) { c.MPSPipeDir = "/tmp/nvidia-mps" }},
+	{name: "mig", config: func(c *Config) { c.MIGDevices = true }, request: migGoldenRequest, backend: migGoldenBackend},
+}
+
+// migGoldenRequest allocates the virtual GPUs of GPU 0 and of the two MIG
+// devices of GPU 1 as goldenRequest does.
+var migGoldenRequest = &pluginapi.AllocateRequest{
+	ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+		{DevicesIDs: []string{"0-0", "0-1", "1mig0-0"}},
+		{DevicesIDs: []string{"1mig1-1"}},
+		{DevicesIDs: []string{"1mig1-3", "0-2", "1mig0-2"}},
+	},
+}
+
+func migGoldenBackend(t *testing.T) (DeviceBackend, func()) {
+	b, _, cleanup := newTestMIGBackend(t)
+	return b, cleanup
+}
+
+// goldenRequest allocates virtual GPUs of both physical GPUs to a first
+// container, of the second one to a second container, and of both, out of
+// order, to a third container.
+var goldenRequest = &pluginapi.AllocateRequest{
+	ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+		{DevicesIDs: []string{"0-0", "0-1", "1-0"}},
+		{DevicesIDs: []string{"1-1"}},
+		{DevicesIDs: []string{"1-3", "0-2", "1-2"}},
+	},
+}
+
+// formatResponse renders resp in a stable, diff friendly text form.
+func formatResponse(resp *pluginapi.AllocateResponse) []byte {
+	var b bytes.Buffer
+	for i, c := range resp.ContainerResponses {
+		fmt.Fprintf(&b, "container %d\n", i)
+
+		var envs []string
+		for k, v := range c.Envs {
+			envs = append(envs, k+"="+v)
+		}
+		sort.Strings(envs)
+		for _, e := range envs {
+			fmt.Fprintf(&b, "  env %s\n", e)
+		}
+		for _, m := range c.Mounts {
+			mode := "rw"
+			if m.ReadOnly {
+				mode = "ro"
+			}
+			fmt.Fprintf(&b, "  mount %s:%s %s\n", m.HostPath, m.ContainerPath, mode)
+		}
+		for _, d := range c.Devices {
+			fmt.Fprintf(&b, "  device %s:%s %s\n", d.HostPath, d.ContainerPath, d.Permissions)
+		}
+
+		var annotations []string
+		for k, v := range c.Annotations {
+			annotations = append(annotations, k+"="+v)
+		}
+		sort.Strings(annotations)
+		for _, a := range annotations {
+			fmt.Fprintf(&b, "  annotation %s\n", a)
+		}
+	}
+	return b.Bytes()
+}
+
+// TestGoldenAllocateResponses compares what containers receive with the
+// golden files of testdata. Run it with -update to accept an intended change.
+func TestGoldenAllocateResponses(t *testing.T) {
+	for _, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			config := testConfig()
+			c.config(&config)
+			var backend DeviceBackend = NewMockBackend(2)
+			cleanup := func() { backend.Shutdown() }
+			if c.backend != nil {
+				backend, cleanup = c.backend(t)
+			}
+			defer cleanup()
+			req := goldenRequest
+			if c.request != nil {
+				req = c.request
+			}
+
+			resp, err := newTestManager(t, config, backend).newDevicePlugins()[0].Allocate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("allocation failed: %v", err)
+			}
+			got := formatResponse(resp)
+
+			golden := filepath.Join("testdata", c.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s:\n--- want\n%s--- got\n%s", golden, want, got)
+			}
+		})
+	}
+}
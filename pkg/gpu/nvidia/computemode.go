@@ -0,0 +1,151 @@
+package nvidia
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const (
+	// Compute modes of nvidia-smi.
+	ComputeModeDefault          = "DEFAULT"
+	ComputeModeExclusiveProcess = "EXCLUSIVE_PROCESS"
+	ComputeModeProhibited       = "PROHIBITED"
+
+	computeModeInterval = 10 * time.Second
+)
+
+// validComputeMode reports whether mode is a compute mode of nvidia-smi.
+func validComputeMode(mode string) bool {
+	switch mode {
+	case ComputeModeDefault, ComputeModeExclusiveProcess, ComputeModeProhibited:
+		return true
+	}
+	return false
+}
+
+// computeModeManager sets the compute mode of every physical GPU, the shared
+// one while its virtual GPUs are handed out to several containers and the
+// whole one while a container received all of them, restoring the shared
+// one once that container is gone.
+type computeModeManager struct {
+	config  Config
+	backend DeviceBackend
+	// gpus are the physical GPUs by ID.
+	gpus map[string]GPU
+
+	sync.Mutex
+	// modes are the compute modes set on the GPUs.
+	modes map[string]string
+	// wholeSince records when a GPU was handed out whole, kubelet only
+	// reports the container some time after Allocate.
+	wholeSince map[string]time.Time
+}
+
+func newComputeModeManager(config Config, backend DeviceBackend, gpus []GPU) *computeModeManager {
+	c := &computeModeManager{
+		config:     config,
+		backend:    backend,
+		gpus:       make(map[string]GPU, len(gpus)),
+		modes:      make(map[string]string),
+		wholeSince: make(map[string]time.Time),
+	}
+	for _, gpu := range gpus {
+		c.gpus[config.gpuID(gpu)] = gpu
+	}
+	return c
+}
+
+// wholeGPUs returns the physical GPUs all the virtual GPUs of which are in
+// ids.
+func (c *computeModeManager) wholeGPUs(ids []string) []string {
+	perGPU := make(map[string]int)
+	for _, id := range ids {
+		perGPU[getPhysicalDeviceID(id)]++
+	}
+
+	var whole []string
+	for gpu, n := range perGPU {
+		if n == c.config.vGPUCount(c.gpus[gpu]) {
+			whole = append(whole, gpu)
+		}
+	}
+	return whole
+}
+
+// set sets the compute mode of the GPU, unless already set. It must be
+// called with the lock held.
+func (c *computeModeManager) set(id, mode string) {
+	gpu, ok := c.gpus[id]
+	if !ok || c.modes[id] == mode {
+		return
+	}
+	if err := c.backend.SetComputeMode(gpu.UUID, mode); err != nil {
+		log.Printf("Failed to set compute mode of GPU %s to %s: %v", id, mode, err)
+		return
+	}
+	log.Printf("Compute mode of GPU %s set to %s.", id, mode)
+	c.modes[id] = mode
+}
+
+// allocated sets the whole compute mode on the GPUs handed out whole to a
+// container.
+func (c *computeModeManager) allocated(ids []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, gpu := range c.wholeGPUs(ids) {
+		c.wholeSince[gpu] = time.Now()
+		c.set(gpu, c.config.WholeComputeMode)
+	}
+}
+
+// reconcile sets the compute mode of every GPU from the devices of the
+// running containers.
+func (c *computeModeManager) reconcile(pods []*podresourcesapi.PodResources) {
+	whole := make(map[string]bool)
+	for _, pod := range pods {
+		for _, container := range pod.Containers {
+			for _, gpu := range c.wholeGPUs(containerVGPUs(c.config, c.gpus, container)) {
+				whole[gpu] = true
+			}
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for id := range c.gpus {
+		switch {
+		case whole[id]:
+			c.set(id, c.config.WholeComputeMode)
+		case time.Since(c.wholeSince[id]) < assignmentTimeout:
+			// Kubelet does not report the container yet.
+		default:
+			delete(c.wholeSince, id)
+			c.set(id, c.config.SharedComputeMode)
+		}
+	}
+}
+
+// run reconciles the compute modes of the GPUs until stop is closed.
+func (c *computeModeManager) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(computeModeInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, err := listPodResources()
+		if err != nil {
+			log.Printf("Failed to list pod resources: %v", err)
+		} else {
+			c.reconcile(pods)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
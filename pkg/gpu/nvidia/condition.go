@@ -0,0 +1,90 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// healthCondition is the node condition cluster monitoring can alert on
+	// when GPU sharing is degraded on the node.
+	healthCondition v1.NodeConditionType = "VGPUPluginHealthy"
+
+	healthConditionInterval = 30 * time.Second
+)
+
+// healthConditionOf returns the health condition of the plugin, without its
+// times.
+func (vgm *vGPUManager) healthConditionOf() v1.NodeCondition {
+	if !vgm.isRegistered() {
+		return v1.NodeCondition{
+			Type:    healthCondition,
+			Status:  v1.ConditionFalse,
+			Reason:  "NotRegistered",
+			Message: "The device plugins are not registered with kubelet",
+		}
+	}
+
+	var unhealthy []string
+	for _, gpu := range vgm.gpus {
+		if status := vgm.healthLog.status(gpu.UUID); !status.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%d (%s)", gpu.Index, status.LastError))
+		}
+	}
+	if len(unhealthy) > 0 {
+		return v1.NodeCondition{
+			Type:    healthCondition,
+			Status:  v1.ConditionFalse,
+			Reason:  "UnhealthyGPUs",
+			Message: fmt.Sprintf("GPUs %s are unhealthy", strings.Join(unhealthy, ", ")),
+		}
+	}
+	return v1.NodeCondition{
+		Type:    healthCondition,
+		Status:  v1.ConditionTrue,
+		Reason:  "PluginHealthy",
+		Message: fmt.Sprintf("The device plugins are registered and the %d GPUs are healthy", len(vgm.gpus)),
+	}
+}
+
+// reportHealthCondition keeps the health condition of the node up to date,
+// as soon as a GPU turns unhealthy and periodically, until stop is closed or
+// the node is handed over.
+func (vgm *vGPUManager) reportHealthCondition(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(healthConditionInterval)
+	defer ticker.Stop()
+
+	unhealthy := vgm.healthLog.subscribe()
+	var lastStatus v1.ConditionStatus
+	var lastTransition metav1.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-vgm.handedOver:
+			return
+		case <-ticker.C:
+		case <-unhealthy:
+		}
+
+		condition := vgm.healthConditionOf()
+		condition.LastHeartbeatTime = metav1.Now()
+		if condition.Status != lastStatus {
+			lastStatus = condition.Status
+			lastTransition = condition.LastHeartbeatTime
+			log.Printf("%s: %s", healthCondition, condition.Message)
+		}
+		condition.LastTransitionTime = lastTransition
+
+		if err := kube.SetNodeCondition(client, vgm.config.NodeName, condition); err != nil {
+			log.Printf("Failed to set %s condition on node %s: %v", healthCondition, vgm.config.NodeName, err)
+		}
+	}
+}
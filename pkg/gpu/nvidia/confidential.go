@@ -0,0 +1,39 @@
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	confidentialOnce sync.Once
+	confidential     bool
+)
+
+// parseConfidentialComputing parses the output of nvidia-smi conf-compute -f,
+// e.g. "CC status: ON".
+func parseConfidentialComputing(out []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "CC status" {
+			return strings.TrimSpace(fields[1]) == "ON"
+		}
+	}
+	return false
+}
+
+// confidentialComputing reports whether the GPUs run in the confidential
+// computing mode of Hopper and later GPUs. The mode only changes with a reset
+// of the GPUs, so nvidia-smi is only run once. Drivers without confidential
+// computing support report it off.
+func confidentialComputing() bool {
+	confidentialOnce.Do(func() {
+		out, err := exec.Command("nvidia-smi", "conf-compute", "-f").CombinedOutput()
+		confidential = err == nil && parseConfidentialComputing(out)
+	})
+	return confidential
+}
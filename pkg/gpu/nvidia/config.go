@@ -0,0 +1,681 @@
+package nvidia
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMPSClients is the number of clients a Volta or newer MPS server
+	// serves, hence the number of virtual GPUs of a physical GPU.
+	maxMPSClients = 48
+
+	// DefaultVulkanICDDir is where GKE installs the Vulkan ICD files on the host.
+	DefaultVulkanICDDir = "/home/kubernetes/bin/vulkan/icd.d"
+
+	// DefaultDriverRoot is where GKE installs the driver libraries on the host.
+	DefaultDriverRoot = "/home/kubernetes/bin/nvidia"
+
+	// DefaultExclusiveResourceName is the resource of the GPUs handed out whole.
+	DefaultExclusiveResourceName = "hkube.io/gpu"
+
+	// DefaultDevicePermissions are the cgroup permissions granted on injected device nodes.
+	DefaultDevicePermissions = "mrw"
+
+	// DefaultSELinuxLabel is a container file label without MCS categories so
+	// that every container, whatever its categories, can access the file.
+	DefaultSELinuxLabel = "system_u:object_r:container_file_t:s0"
+
+	// DeviceProfileDefault injects the devices and mounts as configured.
+	DeviceProfileDefault = "default"
+	// DeviceProfileMinimal only injects what compute workloads need: the
+	// device nodes of the GPUs, the control and UVM devices, without mknod
+	// permission nor the NVSwitch and IMEX devices, and read-only mounts.
+	DeviceProfileMinimal = "minimal"
+
+	// ComputeEnforcementNone leaves the compute share of containers advisory.
+	ComputeEnforcementNone = "none"
+	// ComputeEnforcementThrottle throttles the SM usage of every container to
+	// its share with the CUDA limiter.
+	ComputeEnforcementThrottle = "throttle"
+
+	// MemoryQuotaNone does not watch the GPU memory used by pods.
+	MemoryQuotaNone = "none"
+	// MemoryQuotaMetric reports the pods using more GPU memory than their
+	// budget through metrics and logs.
+	MemoryQuotaMetric = "metric"
+	// MemoryQuotaEvent also records a warning event on the pod.
+	MemoryQuotaEvent = "event"
+	// MemoryQuotaKill also kills the GPU processes of the pod.
+	MemoryQuotaKill = "kill"
+	// MemoryQuotaEvict also evicts the pod.
+	MemoryQuotaEvict = "evict"
+
+	// PersistenceNone leaves the persistence of the GPUs to the host.
+	PersistenceNone = "none"
+	// PersistenceMode enables the legacy persistence mode of every GPU.
+	PersistenceMode = "mode"
+	// PersistenceDaemon starts and supervises nvidia-persistenced.
+	PersistenceDaemon = "daemon"
+
+	vulkanICDContainerDir = "/etc/vulkan/icd.d"
+)
+
+// Config holds the settings of the virtual GPU device plugin
+type Config struct {
+	// DevicePluginPath is the directory holding the kubelet and device plugin
+	// sockets, pluginapi.DevicePluginPath on nodes.
+	DevicePluginPath string
+
+	// VGPUCount is the number of virtual GPUs exposed for every physical GPU.
+	VGPUCount int
+	// TotalVGPUs is the number of virtual GPUs exposed by the node, in place
+	// of VGPUCount, distributed across the shared physical GPUs in
+	// proportion to their memory. Zero exposes VGPUCount virtual GPUs for
+	// every physical GPU instead.
+	TotalVGPUs int
+	// ExclusiveGPUs are the indexes of the physical GPUs handed out whole as
+	// ExclusiveResourceName, the other GPUs are shared.
+	ExclusiveGPUs         []int
+	ExclusiveResourceName string
+	// MemoryChunk also advertises the memory of every shared physical GPU
+	// as MemoryResourceName devices of MemoryChunk MiB, accounted against the
+	// same capacity as the virtual GPUs. Zero disables the resource.
+	MemoryChunk        uint64
+	MemoryResourceName string
+	// ModelResources also advertises the virtual GPUs of every GPU model as
+	// hkube.io/<model>-vgpu, e.g. hkube.io/t4-vgpu.
+	ModelResources bool
+	// Tiers are the sizes of virtual GPUs advertised as their own resource,
+	// besides the virtual GPUs.
+	Tiers []Tier
+	// GuaranteedPercent also advertises the virtual GPUs of every shared
+	// physical GPU as two device classes: this percentage of them as
+	// GuaranteedResourceName, the others as BestEffortResourceName. Zero
+	// disables the classes.
+	GuaranteedPercent uint
+	// GRIDPartitioning advertises the NVIDIA vGPUs (GRID) of the node as a
+	// single virtual GPU each, instead of sharing them in software.
+	GRIDPartitioning bool
+	// MIGDevices shares the MIG devices of the GPUs with MIG enabled instead
+	// of the GPUs.
+	MIGDevices bool
+	// Tegra shares the integrated GPU of Jetson (Tegra) devices, where NVML
+	// is not available, instead of the GPUs found by NVML.
+	Tegra bool
+	// WSL shares the GPUs of a WSL2 distribution through the dxg device of
+	// the Windows host, e.g. to develop against the GPU of a laptop.
+	WSL bool
+	// FakeGPUs emulates the given number of physical GPUs instead of using
+	// NVML, and injects no device or mount into containers.
+	FakeGPUs uint
+	// SequentialDeviceIDs identifies the physical GPUs by their index instead
+	// of their UUID, so that the virtual GPU IDs are stable across nodes and
+	// runs, e.g. 0-0, 0-1, 1-0.
+	SequentialDeviceIDs bool
+	// PerGPUResources additionally advertises the virtual GPUs of every
+	// physical GPU under their own resource, e.g. hkube.io/gpu-0-vgpu.
+	PerGPUResources bool
+
+	// Graphics enables graphics support by mounting the Vulkan ICD directory.
+	Graphics bool
+	// VulkanICDDir is the host directory holding the Vulkan ICD files.
+	VulkanICDDir string
+
+	// DriverRoot is the host directory of the driver libraries mounted into
+	// the containers of NVIDIA GPUs, or the root of the driver install, e.g.
+	// /run/nvidia/driver of a driver container, resolved to its library
+	// directory. Nothing is mounted when empty, the container runtime then
+	// injects the driver.
+	DriverRoot string
+	// DriverRoots are the host directories of the driver libraries of the
+	// physical GPUs by index, on nodes where GPUs are driven by different
+	// driver installs, e.g. a driver container for the newer GPUs. The other
+	// GPUs use DriverRoot.
+	DriverRoots map[int]string
+
+	// CUDALimiterDir is the host directory holding the CUDA interception
+	// library enforcing the GPU memory share of every container. Memory
+	// shares are advisory when empty.
+	CUDALimiterDir string
+	// ComputeEnforcement is ComputeEnforcementNone or
+	// ComputeEnforcementThrottle.
+	ComputeEnforcement string
+
+	// BudgetDir is the host directory holding the GPU budget files mounted
+	// into containers. No budget file is mounted when empty.
+	BudgetDir string
+
+	// ManageComputeMode sets the compute mode of every physical GPU to
+	// SharedComputeMode while its virtual GPUs are handed out to several
+	// containers, and to WholeComputeMode while a container received all of
+	// them.
+	ManageComputeMode bool
+	SharedComputeMode string
+	WholeComputeMode  string
+
+	// Persistence keeps the GPUs initialized without users, sparing the first
+	// container of every GPU the initialization of the driver: one of
+	// PersistenceNone, PersistenceMode or PersistenceDaemon.
+	Persistence string
+
+	// ResponseTemplates are the names of the templates composing the
+	// responses to container requests, in order, e.g.
+	// DefaultResponseTemplates. A template composes nothing unless its
+	// configuration applies, e.g. Graphics for the graphics template.
+	ResponseTemplates []string
+	// MPSPipeDir is the host directory of the pipes of the MPS control
+	// daemon of the node, mounted into containers by the mps template.
+	// Containers do not use MPS when empty.
+	MPSPipeDir string
+	// ReadOnlyMounts marks every mount injected into containers as read-only.
+	ReadOnlyMounts bool
+	// DevicePermissions are the cgroup permissions ("r", "w", "m") granted on
+	// the injected device nodes.
+	DevicePermissions string
+	// DeviceProfile is DeviceProfileDefault or DeviceProfileMinimal.
+	DeviceProfile string
+	// ResourceConfigFile is a JSON file of ResourceConfig by resource name,
+	// overriding the allocation settings of single resources. It is watched,
+	// and the device plugins of the resources whose configuration changed are
+	// restarted. Nothing is overridden when empty.
+	ResourceConfigFile string
+
+	// SELinuxLabel is applied to the injected device nodes and mounts so that
+	// confined containers can use them without running as spc_t.
+	SELinuxLabel string
+
+	// AllocationAnnotations describes the physical GPUs, virtual GPUs and
+	// share of every GPU a container received in the annotations of its
+	// allocation, passed to the container runtime.
+	AllocationAnnotations bool
+	// CDIAnnotations requests the GPUs of every container from the container
+	// runtime through Container Device Interface annotations, resolved with
+	// the CDI specification of the NVIDIA container toolkit.
+	CDIAnnotations bool
+
+	// VerifySocketPeer rejects connections to the plugin socket from users
+	// other than AllowedPeerUIDs, checked through SO_PEERCRED.
+	VerifySocketPeer bool
+	// AllowedPeerUIDs are the users, usually kubelet's root, allowed to call
+	// the plugin. The user running the plugin is always allowed.
+	AllowedPeerUIDs []uint32
+
+	// AllocateTimeout bounds the processing of an Allocate call, on top of
+	// the deadline set by kubelet. Zero only honors kubelet's deadline.
+	AllocateTimeout time.Duration
+	// PreStartCheck has kubelet call PreStartContainer before starting a
+	// container, which checks that its GPUs answer the driver.
+	PreStartCheck bool
+	// DrainTimeout bounds how long in-flight calls may run when the plugin
+	// server stops, before their connections are closed.
+	DrainTimeout time.Duration
+
+	// Kubeconfig is the kubeconfig used to reach the API server. The
+	// in-cluster configuration is used when empty.
+	Kubeconfig string
+	// NodeName is the name of the node the plugin runs on.
+	NodeName string
+	// NodeLabels labels the node with the GPU product, memory, driver and
+	// CUDA versions and the virtual GPU capacity, and keeps the labels up to
+	// date.
+	NodeLabels bool
+	// NoGPUTaint, in the key[=value]:effect format, taints the node while
+	// the plugin finds no usable GPU, e.g. before the driver is installed.
+	// The taint is removed once the plugin starts with GPUs. The node is not
+	// tainted when empty.
+	NoGPUTaint string
+	// NFDFeaturesDir is the features.d directory of node feature discovery
+	// the plugin describes the GPUs and the virtual GPU configuration in, so
+	// that node feature discovery labels the node. Nothing is written when
+	// empty.
+	NFDFeaturesDir string
+	// PublishInventory publishes the per-GPU occupancy as a node annotation.
+	PublishInventory bool
+	// DetectCapacityDrift compares the capacity and allocatable of the
+	// resources of the plugin in the status of the node with the advertised
+	// devices, and registers the plugin again with kubelet when they drift
+	// apart.
+	DetectCapacityDrift bool
+	// PublishGPUNode publishes the GPUs of the node, their virtual GPUs,
+	// health and allocations, and the devices of every resource in the
+	// GPUNode custom resource named after the node.
+	PublishGPUNode bool
+	// HealthCondition reports whether the device plugins are registered
+	// with kubelet and the GPUs healthy in the VGPUPluginHealthy node
+	// condition.
+	HealthCondition bool
+	// PublishHealth publishes the health of every physical GPU and its last
+	// error as a node annotation.
+	PublishHealth bool
+	// WatchDrain marks the devices of the physical GPUs listed in the
+	// DrainAnnotation of the node unhealthy, to drain them before
+	// maintenance.
+	WatchDrain bool
+	// PublishTopology adds the IDs of the virtual GPUs sharing every physical
+	// GPU to the published inventory.
+	PublishTopology bool
+	// StateNamespace is the namespace of the hkube-vgpu-state-<node>
+	// ConfigMap the state of the plugin is published to for debugging. The
+	// state is not published when empty.
+	StateNamespace string
+
+	// AnnotatePods records the physical GPUs received by every container in
+	// an annotation of its pod.
+	AnnotatePods bool
+	// AuditLog is the file every allocation is appended to, along with the
+	// pod and the physical GPUs it received. No log is written when empty.
+	AuditLog string
+	// AuditSigningKey is the file holding the node key signing the audit log
+	// records, e.g. mounted from a Secret. Records are unsigned when empty.
+	AuditSigningKey string
+	// AllocationWebhook is the http or https URL every allocation and release
+	// of virtual GPUs is posted to. No event is sent when empty.
+	AllocationWebhook string
+	// AllocationWebhookKey is the file holding the key signing the
+	// webhook events with HMAC-SHA256. Events are unsigned when empty.
+	AllocationWebhookKey string
+
+	// MetricsAddress is the address serving Prometheus metrics on /metrics.
+	// Metrics are not served when empty.
+	MetricsAddress string
+	// MetricsTLSCertFile and MetricsTLSKeyFile serve the metrics over TLS.
+	MetricsTLSCertFile string
+	MetricsTLSKeyFile  string
+
+	// MemoryQuotaEnforcement is how pods using more GPU memory than the
+	// share of their virtual GPUs are handled, one of the MemoryQuota levels.
+	MemoryQuotaEnforcement string
+	// VerifyDevicePolicy checks, on cgroup v2 nodes, that the containers
+	// using the GPUs are confined by a device controller program.
+	VerifyDevicePolicy bool
+
+	// MaxPodsPerGPU is the number of pods a physical GPU may be shared by,
+	// its free virtual GPUs are no longer advertised beyond. Zero disables
+	// the limit.
+	MaxPodsPerGPU uint
+	// MaxMemoryBudgetPercent is the percentage of the memory of a physical
+	// GPU its allocated virtual GPUs may hold, its free virtual GPUs are no
+	// longer advertised beyond. Zero disables the limit.
+	MaxMemoryBudgetPercent uint
+
+	// OverloadThreshold is the utilization percentage above which a physical
+	// GPU is considered busy. Zero disables overload detection.
+	OverloadThreshold uint
+	// IdleThreshold is the utilization percentage below which a physical GPU
+	// is considered idle.
+	IdleThreshold uint
+	// OverloadPeriod is how long a GPU must stay busy, while another GPU is
+	// idle, to be reported as overloaded.
+	OverloadPeriod time.Duration
+
+	// ReconcileAllocations keeps the allocations recorded by the plugin in
+	// sync with the devices kubelet assigned to the running containers. It
+	// is implied by the features reading the allocations, see
+	// reconcilesAllocations.
+	ReconcileAllocations bool
+
+	// CheckpointFile keeps the IDs of the physical GPUs and the allocations
+	// of their virtual GPUs across restarts and upgrades of the plugin.
+	// Nothing is kept when empty.
+	CheckpointFile string
+	// Handover lets a new instance of the plugin take over the node from the
+	// running one during an upgrade: every instance serves its own sockets,
+	// and the running one stops serving once the new one registered with
+	// kubelet. It requires a CheckpointFile.
+	Handover bool
+
+	// FaultInjectionAddress is the address serving the debug endpoints
+	// injecting Xid errors, NVML errors and socket removals. Faults can not
+	// be injected when empty.
+	FaultInjectionAddress string
+
+	// gpuIDs are the IDs of the physical GPUs by UUID restored from the
+	// checkpoint, the IDs derive from the GPUs when nil.
+	gpuIDs map[string]string
+	// vgpuCounts are the numbers of virtual GPUs of the shared physical GPUs
+	// by UUID, distributed from TotalVGPUs once the GPUs are discovered.
+	vgpuCounts map[string]int
+	// instance tells the sockets of the instances of the plugin apart when
+	// they hand over the node, empty otherwise.
+	instance string
+}
+
+// pluginSocket returns the path of the device plugin socket named name,
+// suffixed with the instance when instances hand over the node.
+func (c Config) pluginSocket(name string) string {
+	if c.instance != "" {
+		name = strings.TrimSuffix(name, ".sock") + "-" + c.instance + ".sock"
+	}
+	return filepath.Join(c.DevicePluginPath, name)
+}
+
+// gpuID returns the ID of the physical GPU the virtual GPU IDs derive from.
+func (c Config) gpuID(gpu GPU) string {
+	if id, ok := c.gpuIDs[gpu.UUID]; ok {
+		return id
+	}
+	if c.SequentialDeviceIDs {
+		// MIG devices share the index of their physical GPU.
+		if gpu.MIG != nil {
+			return fmt.Sprintf("%dmig%d", gpu.Index, gpu.MIG.Index)
+		}
+		return strconv.Itoa(gpu.Index)
+	}
+	return gpu.UUID
+}
+
+// vGPUCount returns the number of virtual GPUs exposed for the physical GPU.
+func (c Config) vGPUCount(gpu GPU) int {
+	if c.GRIDPartitioning && gpu.VGPUProfile != "" || c.exclusive(gpu) {
+		return 1
+	}
+	if n, ok := c.vgpuCounts[gpu.UUID]; ok {
+		return n
+	}
+	return c.VGPUCount
+}
+
+// exclusive reports whether the physical GPU is handed out whole.
+func (c Config) exclusive(gpu GPU) bool {
+	for _, i := range c.ExclusiveGPUs {
+		if i == gpu.Index {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedAccounting reports whether the devices of every resource must be
+// reconciled with the running containers, when several resources hold the
+// same virtual GPUs or when the guardrails are enabled.
+func (c Config) sharedAccounting() bool {
+	return c.PerGPUResources || c.ModelResources || len(c.Tiers) > 0 || c.MemoryChunk > 0 || c.GuaranteedPercent > 0 ||
+		c.MaxPodsPerGPU > 0 || c.MaxMemoryBudgetPercent > 0
+}
+
+// reconcilesAllocations reports whether the allocations recorded by the
+// plugin are reconciled with the running containers, always when they are
+// published or kept, so that the allocations of deleted pods do not pile up
+// until every GPU looks full.
+func (c Config) reconcilesAllocations() bool {
+	return c.ReconcileAllocations || c.PublishInventory || c.StateNamespace != "" || c.CheckpointFile != "" || c.BudgetDir != ""
+}
+
+// kubeletSocket returns the path of the kubelet registration socket.
+func (c Config) kubeletSocket() string {
+	return filepath.Join(c.DevicePluginPath, kubeletSock)
+}
+
+// validateVGPUCounts checks that no physical GPU exposes more virtual GPUs
+// than its MPS server has clients, with VGPUCount and with the counts
+// distributed from TotalVGPUs once the GPUs are discovered.
+func (c Config) validateVGPUCounts() error {
+	if c.TotalVGPUs == 0 && c.VGPUCount > maxMPSClients {
+		return fmt.Errorf("number of virtual GPUs %d can not exceed the maximum number of MPS clients %d", c.VGPUCount, maxMPSClients)
+	}
+	for uuid, n := range c.vgpuCounts {
+		if n > maxMPSClients {
+			return fmt.Errorf("%d virtual GPUs distributed to GPU %s, more than the maximum number of MPS clients %d, lower the total virtual GPUs", n, uuid, maxMPSClients)
+		}
+	}
+	return nil
+}
+
+// invalidConfigError is returned by discover when the configuration does not
+// fit the discovered GPUs.
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration: %v", e.err)
+}
+
+// Validate checks the configuration for invalid values
+func (c Config) Validate() error {
+	if c.DevicePluginPath == "" {
+		return fmt.Errorf("device plugin path can not be empty")
+	}
+	if c.DevicePermissions == "" {
+		return fmt.Errorf("device permissions can not be empty")
+	}
+	if c.TotalVGPUs < 0 {
+		return fmt.Errorf("total virtual GPUs can not be negative")
+	}
+	if err := c.validateVGPUCounts(); err != nil {
+		return err
+	}
+	for _, p := range c.DevicePermissions {
+		if !strings.ContainsRune(devicePermissionsOrder, p) || strings.Count(c.DevicePermissions, string(p)) > 1 {
+			return fmt.Errorf("invalid device permissions %q, expected a combination of \"r\", \"w\" and \"m\"", c.DevicePermissions)
+		}
+	}
+	if err := c.validateResponseTemplates(); err != nil {
+		return err
+	}
+	if c.MPSPipeDir != "" {
+		if !filepath.IsAbs(c.MPSPipeDir) {
+			return fmt.Errorf("MPS pipe directory %q must be an absolute path", c.MPSPipeDir)
+		}
+		if c.FakeGPUs > 0 || c.Tegra || c.WSL {
+			return fmt.Errorf("MPS is not available with emulated, Tegra or WSL2 GPUs")
+		}
+	}
+	switch c.DeviceProfile {
+	case DeviceProfileDefault:
+	case DeviceProfileMinimal:
+		if c.Graphics {
+			return fmt.Errorf("graphics support is not available with the %s device profile", DeviceProfileMinimal)
+		}
+		if c.Tegra || c.WSL {
+			return fmt.Errorf("the %s device profile only applies to the device nodes of NVIDIA GPUs, not to Tegra or WSL2 GPUs", DeviceProfileMinimal)
+		}
+	default:
+		return fmt.Errorf("invalid device profile %q, expected %q or %q", c.DeviceProfile, DeviceProfileDefault, DeviceProfileMinimal)
+	}
+	names := map[string]bool{resourceName: true}
+	if c.GuaranteedPercent > 100 {
+		return fmt.Errorf("guaranteed percentage %d can not exceed 100", c.GuaranteedPercent)
+	}
+	if c.GuaranteedPercent > 0 {
+		names[GuaranteedResourceName] = true
+		names[BestEffortResourceName] = true
+	}
+	if len(c.ExclusiveGPUs) > 0 {
+		if !strings.Contains(c.ExclusiveResourceName, "/") || names[c.ExclusiveResourceName] {
+			return fmt.Errorf("invalid exclusive resource name %q", c.ExclusiveResourceName)
+		}
+		names[c.ExclusiveResourceName] = true
+	}
+	if c.MemoryChunk > 0 {
+		if !strings.Contains(c.MemoryResourceName, "/") || names[c.MemoryResourceName] {
+			return fmt.Errorf("invalid GPU memory resource name %q", c.MemoryResourceName)
+		}
+		names[c.MemoryResourceName] = true
+	}
+	// The virtual GPUs of a GPU are only known once discovered with a total
+	// capacity, a tier can not exceed it.
+	maxVGPUs := c.VGPUCount
+	if c.TotalVGPUs > 0 {
+		maxVGPUs = c.TotalVGPUs
+	}
+	for _, t := range c.Tiers {
+		if t.VGPUs < 1 || t.VGPUs > maxVGPUs {
+			return fmt.Errorf("tier %s must hold between 1 and %d virtual GPUs", t.ResourceName, maxVGPUs)
+		}
+		if !strings.Contains(t.ResourceName, "/") || names[t.ResourceName] {
+			return fmt.Errorf("invalid or duplicate tier resource name %q", t.ResourceName)
+		}
+		names[t.ResourceName] = true
+	}
+	if c.Tegra && (c.FakeGPUs > 0 || c.GRIDPartitioning) {
+		return fmt.Errorf("Tegra GPUs can not be emulated nor partitioned by GRID")
+	}
+	if c.WSL && (c.Tegra || c.FakeGPUs > 0) {
+		return fmt.Errorf("WSL2 GPUs can not be emulated nor be Tegra GPUs")
+	}
+	if c.GRIDPartitioning && c.FakeGPUs > 0 {
+		return fmt.Errorf("GRID partitioning can not be used with emulated GPUs")
+	}
+	if c.MIGDevices && (c.FakeGPUs > 0 || c.Tegra || c.WSL) {
+		return fmt.Errorf("MIG devices can not be shared on emulated, Tegra or WSL2 GPUs")
+	}
+	if c.DriverRoot != "" && !filepath.IsAbs(c.DriverRoot) {
+		return fmt.Errorf("driver root %q must be an absolute path", c.DriverRoot)
+	}
+	for i, dir := range c.DriverRoots {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("driver root %q of GPU %d must be an absolute path", dir, i)
+		}
+	}
+	if len(c.DriverRoots) > 0 && c.FakeGPUs > 0 {
+		return fmt.Errorf("driver roots can not be used with emulated GPUs")
+	}
+	if c.CUDALimiterDir != "" && c.FakeGPUs > 0 {
+		return fmt.Errorf("the CUDA limiter can not be used with emulated GPUs")
+	}
+	switch c.ComputeEnforcement {
+	case ComputeEnforcementNone:
+	case ComputeEnforcementThrottle:
+		if c.CUDALimiterDir == "" {
+			return fmt.Errorf("the CUDA limiter directory is required to throttle the compute usage")
+		}
+	default:
+		return fmt.Errorf("invalid compute enforcement %q, expected %q or %q", c.ComputeEnforcement, ComputeEnforcementNone, ComputeEnforcementThrottle)
+	}
+	if c.ManageComputeMode {
+		if c.FakeGPUs > 0 {
+			return fmt.Errorf("the compute mode of emulated GPUs can not be managed")
+		}
+		for _, mode := range []string{c.SharedComputeMode, c.WholeComputeMode} {
+			if !validComputeMode(mode) {
+				return fmt.Errorf("invalid compute mode %q, expected %q, %q or %q", mode,
+					ComputeModeDefault, ComputeModeExclusiveProcess, ComputeModeProhibited)
+			}
+		}
+	}
+	if c.CDIAnnotations && (c.FakeGPUs > 0 || c.Tegra || c.WSL) {
+		return fmt.Errorf("CDI devices of emulated, Tegra or WSL2 GPUs can not be requested")
+	}
+	switch c.Persistence {
+	case PersistenceNone:
+	case PersistenceMode, PersistenceDaemon:
+		if c.FakeGPUs > 0 || c.Tegra || c.WSL {
+			return fmt.Errorf("the persistence of emulated, Tegra or WSL2 GPUs can not be managed")
+		}
+	default:
+		return fmt.Errorf("invalid persistence %q, expected %q, %q or %q", c.Persistence, PersistenceNone, PersistenceMode, PersistenceDaemon)
+	}
+	switch c.MemoryQuotaEnforcement {
+	case MemoryQuotaNone:
+	case MemoryQuotaMetric, MemoryQuotaEvent, MemoryQuotaKill, MemoryQuotaEvict:
+		if c.NodeName == "" {
+			return fmt.Errorf("node name is required to watch the GPU memory quotas")
+		}
+	default:
+		return fmt.Errorf("invalid memory quota enforcement %q, expected one of %q, %q, %q, %q or %q", c.MemoryQuotaEnforcement,
+			MemoryQuotaNone, MemoryQuotaMetric, MemoryQuotaEvent, MemoryQuotaKill, MemoryQuotaEvict)
+	}
+	if c.MaxMemoryBudgetPercent > 100 {
+		return fmt.Errorf("maximum memory budget %d%% can not exceed 100%%", c.MaxMemoryBudgetPercent)
+	}
+	if c.OverloadThreshold > 100 {
+		return fmt.Errorf("overload threshold %d%% can not exceed 100%%", c.OverloadThreshold)
+	}
+	if c.OverloadThreshold > 0 && c.IdleThreshold >= c.OverloadThreshold {
+		return fmt.Errorf("idle threshold %d%% must be lower than overload threshold %d%%", c.IdleThreshold, c.OverloadThreshold)
+	}
+	if c.PublishInventory && c.NodeName == "" {
+		return fmt.Errorf("node name is required to publish the GPU inventory")
+	}
+	if c.Handover && c.CheckpointFile == "" {
+		return fmt.Errorf("the checkpoint file is required to hand over the node")
+	}
+	if c.StateNamespace != "" && c.NodeName == "" {
+		return fmt.Errorf("node name is required to publish the plugin state")
+	}
+	if c.DetectCapacityDrift && c.NodeName == "" {
+		return fmt.Errorf("node name is required to detect capacity drifts")
+	}
+	if c.PublishGPUNode && c.NodeName == "" {
+		return fmt.Errorf("node name is required to publish the GPUNode")
+	}
+	if c.HealthCondition && c.NodeName == "" {
+		return fmt.Errorf("node name is required to report the node condition")
+	}
+	if c.PublishHealth && c.NodeName == "" {
+		return fmt.Errorf("node name is required to publish the GPU health")
+	}
+	if c.WatchDrain && c.NodeName == "" {
+		return fmt.Errorf("node name is required to drain GPUs through the node annotation")
+	}
+	if c.NoGPUTaint != "" {
+		if c.NodeName == "" {
+			return fmt.Errorf("node name is required to taint the node")
+		}
+		if _, err := parseTaint(c.NoGPUTaint); err != nil {
+			return err
+		}
+	}
+	if c.NodeLabels && c.NodeName == "" {
+		return fmt.Errorf("node name is required to label the node")
+	}
+	if c.AuditSigningKey != "" && c.AuditLog == "" {
+		return fmt.Errorf("an audit log is required to sign allocation records")
+	}
+	if c.AllocationWebhook != "" {
+		u, err := url.Parse(c.AllocationWebhook)
+		if err != nil {
+			return fmt.Errorf("invalid allocation webhook: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid allocation webhook %q: an http or https URL is required", c.AllocationWebhook)
+		}
+	}
+	if c.AllocationWebhookKey != "" && c.AllocationWebhook == "" {
+		return fmt.Errorf("an allocation webhook is required to sign allocation events")
+	}
+	return nil
+}
+
+// validateResponseTemplates checks that the response templates are known,
+// selected once, and that those the configuration needs are selected.
+func (c Config) validateResponseTemplates() error {
+	if len(c.ResponseTemplates) == 0 {
+		return fmt.Errorf("response templates can not be empty")
+	}
+	selected := make(map[string]bool, len(c.ResponseTemplates))
+	for _, name := range c.ResponseTemplates {
+		if _, ok := findResponseTemplate(name); !ok {
+			return fmt.Errorf("invalid response template %q, expected one of %s", name, strings.Join(responseTemplateNames(), ", "))
+		}
+		if selected[name] {
+			return fmt.Errorf("response template %q selected twice", name)
+		}
+		selected[name] = true
+	}
+
+	needed := []struct {
+		template string
+		enabled  bool
+		option   string
+	}{
+		{"graphics", c.Graphics, "graphics support"},
+		{"cuda-limiter", c.CUDALimiterDir != "", "the CUDA limiter"},
+		{"budget", c.BudgetDir != "", "GPU budget files"},
+		{"mps", c.MPSPipeDir != "", "MPS"},
+		{"cdi", c.CDIAnnotations, "CDI annotations"},
+		{"annotations", c.AllocationAnnotations, "allocation annotations"},
+	}
+	for _, n := range needed {
+		if n.enabled && !selected[n.template] {
+			return fmt.Errorf("%s needs the %s response template", n.option, n.template)
+		}
+	}
+	return nil
+}
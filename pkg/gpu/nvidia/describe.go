@@ -0,0 +1,108 @@
+package nvidia
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Describe discovers the GPUs of backend and writes to w what the plugin
+// configured with config would do on the node, without serving it: the
+// physical GPUs, the resources with their virtual GPUs, and what a container
+// receiving one virtual GPU of every physical GPU of a resource would get
+// injected. Budget files, which are written by the running plugin, are left
+// out.
+func Describe(config Config, backend DeviceBackend, w io.Writer) error {
+	vgm := NewVirtualGPUManagerWithBackend(config, backend)
+	if err := vgm.discover(); err != nil {
+		return err
+	}
+	defer backend.Shutdown()
+
+	if info, err := backend.GetDriverInfo(); err == nil {
+		fmt.Fprintf(w, "Driver: %s", info.Version)
+		if info.CUDAMajor != nil && info.CUDAMinor != nil {
+			fmt.Fprintf(w, ", CUDA %d.%d", *info.CUDAMajor, *info.CUDAMinor)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "\nPhysical GPUs:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  INDEX\tID\tUUID\tMODEL\tMEMORY\tCOMPUTE\tVGPU PROFILE")
+	for _, gpu := range vgm.gpus {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%d MiB\t%d\t%s\n", gpu.Index, config.gpuID(gpu), gpu.UUID,
+			gpu.Model, gpu.Memory, gpu.ComputeCapability, gpu.VGPUProfile)
+	}
+	tw.Flush()
+
+	for _, p := range vgm.newDevicePlugins() {
+		devs := p.devices.snapshot()
+		fmt.Fprintf(w, "\nResource %s, %d virtual GPUs, served on %s:\n", p.resourceName, len(devs), p.socket)
+		ids := make([]string, 0, len(devs))
+		for _, d := range devs {
+			ids = append(ids, d.ID)
+		}
+		fmt.Fprintf(w, "  Devices: %s\n", strings.Join(ids, " "))
+		if len(devs) == 0 {
+			continue
+		}
+
+		// One virtual GPU of every physical GPU, so that the injection of
+		// every GPU shows.
+		var sample []string
+		seen := make(map[string]bool)
+		for _, id := range ids {
+			if gpu := getPhysicalDeviceID(id); !seen[gpu] {
+				seen[gpu] = true
+				sample = append(sample, id)
+			}
+		}
+		resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: sample}},
+		})
+		if err != nil {
+			fmt.Fprintf(w, "  Allocation of %s failed: %v\n", strings.Join(sample, " "), err)
+			continue
+		}
+		describeContainerResponse(w, sample, resp.ContainerResponses[0])
+	}
+	return nil
+}
+
+// describeContainerResponse writes what a container allocated the devices
+// ids gets injected.
+func describeContainerResponse(w io.Writer, ids []string, resp *pluginapi.ContainerAllocateResponse) {
+	fmt.Fprintf(w, "  Allocation of %s:\n", strings.Join(ids, " "))
+	envs := make([]string, 0, len(resp.Envs))
+	for k, v := range resp.Envs {
+		envs = append(envs, k+"="+v)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		fmt.Fprintf(w, "    env %s\n", env)
+	}
+	for _, d := range resp.Devices {
+		fmt.Fprintf(w, "    device %s -> %s (%s)\n", d.HostPath, d.ContainerPath, d.Permissions)
+	}
+	for _, m := range resp.Mounts {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		fmt.Fprintf(w, "    mount %s -> %s (%s)\n", m.HostPath, m.ContainerPath, mode)
+	}
+	annotations := make([]string, 0, len(resp.Annotations))
+	for k, v := range resp.Annotations {
+		annotations = append(annotations, k+"="+v)
+	}
+	sort.Strings(annotations)
+	for _, a := range annotations {
+		fmt.Fprintf(w, "    annotation %s\n", a)
+	}
+}
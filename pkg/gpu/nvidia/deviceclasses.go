@@ -0,0 +1,42 @@
+package nvidia
+
+import (
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// GuaranteedResourceName is the device class of the virtual GPUs which
+	// are never oversubscribed beyond the guaranteed share of their GPU.
+	GuaranteedResourceName = "hkube.io/vgpu-guaranteed"
+	// BestEffortResourceName is the device class of the other virtual GPUs.
+	BestEffortResourceName = "hkube.io/vgpu-best-effort"
+
+	guaranteedServerSock = "hkube-vgpu-guaranteed.sock"
+	bestEffortServerSock = "hkube-vgpu-best-effort.sock"
+)
+
+// guaranteedVGPUs returns the number of virtual GPUs of the physical GPU in
+// the guaranteed class, rounded down so that the share is never exceeded.
+func (c Config) guaranteedVGPUs(gpu GPU) int {
+	return c.vGPUCount(gpu) * int(c.GuaranteedPercent) / 100
+}
+
+// getClassDevices returns the virtual GPUs of the guaranteed class, the
+// first ones of every shared physical GPU, or of the best-effort class, the
+// remaining ones.
+func getClassDevices(gpus []GPU, config Config, guaranteed bool) []*pluginapi.Device {
+	var devs []*pluginapi.Device
+	for _, d := range gpus {
+		if config.exclusive(d) {
+			continue
+		}
+		first, last := 0, config.guaranteedVGPUs(d)
+		if !guaranteed {
+			first, last = last, config.vGPUCount(d)
+		}
+		for j := first; j < last; j++ {
+			devs = append(devs, &pluginapi.Device{ID: getVGPUID(config.gpuID(d), uint(j)), Health: pluginapi.Healthy})
+		}
+	}
+	return devs
+}
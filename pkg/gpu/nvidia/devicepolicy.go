@@ -0,0 +1,179 @@
+package nvidia
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+)
+
+const (
+	devicePolicyInterval = 30 * time.Second
+
+	// cgroupRoot is where the host cgroup hierarchy is mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// bpfProgQuery and bpfCgroupDevice are the BPF_PROG_QUERY command and
+	// the BPF_CGROUP_DEVICE attach type of the bpf system call.
+	bpfProgQuery    = 16
+	bpfCgroupDevice = 6
+)
+
+var gpuProcessesWithoutDevicePolicy = metrics.NewGaugeVec("vgpu_gpu_processes_without_device_policy",
+	"Processes of pods using the physical GPU from a cgroup without a device controller program.", "gpu")
+
+// bpfProgQueryAttr is the BPF_PROG_QUERY part of union bpf_attr.
+type bpfProgQueryAttr struct {
+	targetFD    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIDs     uint64
+	progCount   uint32
+	_           uint32
+}
+
+// isCgroupV2 returns whether the host runs the cgroup v2 unified hierarchy,
+// where the device controller is an eBPF program instead of devices.allow.
+func isCgroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// processCgroup returns the cgroup v2 path of the process.
+func processCgroup(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			// Paths out of the cgroup namespace of the plugin are relative.
+			if strings.HasPrefix(path, "/..") {
+				return "", fmt.Errorf("cgroup %s of process %d is out of the plugin cgroup namespace", path, pid)
+			}
+			return path, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("process %d has no cgroup v2 path", pid)
+}
+
+// devicePrograms returns the number of device controller programs attached
+// to the cgroup, effective ones inherited from the parents included.
+func devicePrograms(cgroup string) (uint32, error) {
+	if sysBPF == 0 {
+		return 0, fmt.Errorf("the bpf system call is not supported on this architecture")
+	}
+	dir, err := os.Open(filepath.Join(cgroupRoot, cgroup))
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+
+	const bpfFQueryEffective = 1
+	attr := bpfProgQueryAttr{
+		targetFD:   uint32(dir.Fd()),
+		attachType: bpfCgroupDevice,
+		queryFlags: bpfFQueryEffective,
+	}
+	_, _, errno := syscall.Syscall(sysBPF, bpfProgQuery, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errno
+	}
+	return attr.progCount, nil
+}
+
+// devicePolicyVerifier checks that the containers using the GPUs are confined
+// by a device controller program, as runtimes may ignore the permissions of
+// the injected device nodes on cgroup v2, leaving every device accessible.
+type devicePolicyVerifier struct {
+	vgm *vGPUManager
+	// reported records the cgroups already logged, so that they are only
+	// logged once.
+	reported map[string]bool
+}
+
+// check looks up the device controller programs of the cgroups of the
+// processes running on the GPUs.
+func (v *devicePolicyVerifier) check() error {
+	processes, err := v.vgm.backend.GetProcesses()
+	if err != nil {
+		return err
+	}
+
+	gpuIDs := v.vgm.gpuIDs()
+	gpuProcessesWithoutDevicePolicy.Reset()
+	reported := make(map[string]bool)
+	for uuid, procs := range processes {
+		gpu, ok := gpuIDs[uuid]
+		if !ok {
+			continue
+		}
+		for _, p := range procs {
+			uid, err := processPodUID(p.PID)
+			if err != nil || uid == "" {
+				continue
+			}
+			cgroup, err := processCgroup(p.PID)
+			if err != nil {
+				log.Printf("Failed to verify the device policy of process %d of pod %s: %v", p.PID, uid, err)
+				continue
+			}
+			n, err := devicePrograms(cgroup)
+			if err != nil {
+				log.Printf("Failed to query the device programs of cgroup %s: %v", cgroup, err)
+				continue
+			}
+			if n > 0 {
+				continue
+			}
+
+			gpuProcessesWithoutDevicePolicy.Inc(gpu)
+			reported[cgroup] = true
+			if !v.reported[cgroup] {
+				log.Printf("Warning: process %d of pod %s uses GPU %s from cgroup %s without device controller, "+
+					"its container can access every device of the node", p.PID, uid, gpu, cgroup)
+			}
+		}
+	}
+	v.reported = reported
+	return nil
+}
+
+// verifyDevicePolicy checks the device policy of the containers using the
+// GPUs until stop is closed.
+func (vgm *vGPUManager) verifyDevicePolicy(stop <-chan struct{}) {
+	if !isCgroupV2() {
+		log.Println("The node does not run cgroup v2, the device policy is enforced through devices.allow.")
+		return
+	}
+
+	ticker := time.NewTicker(devicePolicyInterval)
+	defer ticker.Stop()
+
+	v := &devicePolicyVerifier{vgm: vgm}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := v.check(); err != nil {
+			log.Printf("Failed to verify the device policy: %v", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package nvidia
+
+import (
+	"sync"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// deviceStore guards the virtual GPUs of a device plugin, whose health is
+// updated by the health checks while ListAndWatch and Allocate read them.
+// Devices never leave the store, readers get copies. Devices are indexed by
+// ID and by physical GPU so that large allocations stay cheap.
+type deviceStore struct {
+	sync.RWMutex
+	devs  []*pluginapi.Device
+	byID  map[string]*pluginapi.Device
+	byGPU map[string][]*pluginapi.Device
+	// withheld are the healthy devices no longer advertised by the
+	// oversubscription guardrails.
+	withheld map[string]bool
+}
+
+func newDeviceStore(devs []*pluginapi.Device) *deviceStore {
+	s := &deviceStore{
+		devs:  copyDevices(devs),
+		byID:  make(map[string]*pluginapi.Device, len(devs)),
+		byGPU: make(map[string][]*pluginapi.Device),
+	}
+	for _, d := range s.devs {
+		s.byID[d.ID] = d
+		gpu := getPhysicalDeviceID(d.ID)
+		s.byGPU[gpu] = append(s.byGPU[gpu], d)
+	}
+	return s
+}
+
+func copyDevices(devs []*pluginapi.Device) []*pluginapi.Device {
+	copies := make([]*pluginapi.Device, 0, len(devs))
+	for _, d := range devs {
+		dev := *d
+		copies = append(copies, &dev)
+	}
+	return copies
+}
+
+// snapshot returns a copy of the devices, the withheld ones reported
+// unhealthy so that kubelet stops counting them as allocatable.
+func (s *deviceStore) snapshot() []*pluginapi.Device {
+	s.RLock()
+	defer s.RUnlock()
+
+	devs := copyDevices(s.devs)
+	for _, d := range devs {
+		if s.withheld[d.ID] {
+			d.Health = pluginapi.Unhealthy
+		}
+	}
+	return devs
+}
+
+// count returns the number of devices.
+func (s *deviceStore) count() int {
+	return len(s.devs)
+}
+
+// get returns a copy of the device with the given ID.
+func (s *deviceStore) get(id string) (pluginapi.Device, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	dev, ok := s.byID[id]
+	if !ok {
+		return pluginapi.Device{}, false
+	}
+	return *dev, true
+}
+
+// devicesOf returns a copy of the devices backed by the physical GPU.
+func (s *deviceStore) devicesOf(gpu string) []*pluginapi.Device {
+	s.RLock()
+	defer s.RUnlock()
+
+	return copyDevices(s.byGPU[gpu])
+}
+
+// withhold replaces the withheld devices by those of ids in the store and
+// reports whether they changed.
+func (s *deviceStore) withhold(ids map[string]bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	withheld := make(map[string]bool)
+	changed := false
+	for id := range ids {
+		if _, ok := s.byID[id]; !ok {
+			continue
+		}
+		withheld[id] = true
+		changed = changed || !s.withheld[id]
+	}
+	changed = changed || len(withheld) != len(s.withheld)
+	s.withheld = withheld
+	return changed
+}
+
+// setHealth updates the health of the device and reports whether it changed.
+func (s *deviceStore) setHealth(id, health string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	dev, ok := s.byID[id]
+	if !ok || dev.Health == health {
+		return false
+	}
+	dev.Health = health
+	return true
+}
+
+// unavailable returns the IDs of the unhealthy devices and of the withheld
+// ones.
+func (s *deviceStore) unavailable() (unhealthy, withheld []string) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, d := range s.devs {
+		switch {
+		case d.Health != pluginapi.Healthy:
+			unhealthy = append(unhealthy, d.ID)
+		case s.withheld[d.ID]:
+			withheld = append(withheld, d.ID)
+		}
+	}
+	return unhealthy, withheld
+}
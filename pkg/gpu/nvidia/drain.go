@@ -0,0 +1,119 @@
+package nvidia
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// DrainAnnotation is the node annotation listing the physical GPUs to
+	// drain before maintenance, by index or UUID, e.g. "1,3".
+	DrainAnnotation = "hkube.io/gpu-drain"
+
+	drainInterval = 30 * time.Second
+	// gpuDrained is the health error of the drained GPUs.
+	gpuDrained = "drained for maintenance"
+)
+
+var gpuDrainedGauge = metrics.NewGaugeVec("vgpu_gpu_drained",
+	"Whether the GPU is drained through the "+DrainAnnotation+" node annotation.", "uuid")
+
+// drainedGPUs returns the UUIDs of the GPUs listed in the value of the drain
+// annotation, by index or UUID. Unknown GPUs are returned separately.
+func drainedGPUs(value string, gpus []GPU) (map[string]bool, []string) {
+	drained := make(map[string]bool)
+	var unknown []string
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		found := false
+		for _, gpu := range gpus {
+			if f == gpu.UUID || f == strconv.Itoa(gpu.Index) {
+				drained[gpu.UUID] = true
+				found = true
+			}
+		}
+		if !found {
+			unknown = append(unknown, f)
+		}
+	}
+	return drained, unknown
+}
+
+// watchDrain marks the devices of the GPUs listed in the drain annotation of
+// the node unhealthy, so that kubelet stops handing them out while their
+// running containers finish, and healthy again once they are removed from
+// it, until stop is closed. The other GPUs of the node are not affected.
+func (vgm *vGPUManager) watchDrain(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	drained := make(map[string]bool)
+	value := ""
+	for {
+		node, err := client.CoreV1().Nodes().Get(vgm.config.NodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Failed to get node %s to check the drained GPUs: %v", vgm.config.NodeName, err)
+		} else {
+			if v := node.Annotations[DrainAnnotation]; v != value {
+				value = v
+				if _, unknown := drainedGPUs(v, vgm.gpus); len(unknown) > 0 {
+					log.Printf("Warning: unknown GPUs %v in the %s annotation.", unknown, DrainAnnotation)
+				}
+			}
+			wanted, _ := drainedGPUs(value, vgm.gpus)
+			for _, gpu := range vgm.gpus {
+				vgm.drain(gpu, wanted[gpu.UUID], drained[gpu.UUID])
+				drained[gpu.UUID] = wanted[gpu.UUID]
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain marks the devices of the GPU unhealthy while it is drained, and
+// healthy once it was and is no longer. The devices are marked on every
+// check, including those of the device plugins started after the first check.
+// A GPU failing meanwhile stays unhealthy.
+func (vgm *vGPUManager) drain(gpu GPU, drain, wasDrained bool) {
+	health := pluginapi.Unhealthy
+	switch {
+	case drain:
+		gpuDrainedGauge.Set(1, gpu.UUID)
+		if !wasDrained {
+			log.Printf("Draining GPU %d (%s), its devices go unhealthy until it is undrained.", gpu.Index, gpu.UUID)
+			// The last error of a failed GPU is kept.
+			if vgm.healthLog.status(gpu.UUID).Healthy {
+				vgm.healthLog.unhealthy(gpu.UUID, nil, gpuDrained)
+			}
+		}
+	case wasDrained:
+		gpuDrainedGauge.Set(0, gpu.UUID)
+		if !vgm.healthLog.healthy(gpu.UUID, gpuDrained) {
+			log.Printf("Warning: GPU %d (%s) is undrained but failed meanwhile, it stays unhealthy.", gpu.Index, gpu.UUID)
+			return
+		}
+		log.Printf("GPU %d (%s) is undrained.", gpu.Index, gpu.UUID)
+		health = pluginapi.Healthy
+	default:
+		gpuDrainedGauge.Set(0, gpu.UUID)
+		return
+	}
+	for _, p := range vgm.devicePlugins() {
+		p.setGPUHealth(vgm.config.gpuID(gpu), health)
+	}
+}
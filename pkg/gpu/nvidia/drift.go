@@ -0,0 +1,99 @@
+package nvidia
+
+import (
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// capacityDriftInterval is how often the status of the node is compared with
+// the advertised devices. Kubelet updates the status within seconds, a drift
+// is only reported when seen twice in a row.
+const capacityDriftInterval = time.Minute
+
+var (
+	capacityDrift = metrics.NewGaugeVec("vgpu_capacity_drift",
+		"Allocatable devices of the resource reported by the node status minus the healthy devices advertised by the plugin.", "resource")
+	capacityReregistrations = metrics.NewCounterVec("vgpu_capacity_drift_reregistrations_total",
+		"Re-registrations with kubelet triggered by a drift between the node status and the advertised devices.")
+)
+
+// advertisedDevices returns the number of devices advertised by the plugin
+// and the number of healthy ones, which kubelet reports as the capacity and
+// the allocatable of the resource.
+func advertisedDevices(p *NvidiaDevicePlugin) (capacity, allocatable int64) {
+	for _, d := range p.devices.snapshot() {
+		capacity++
+		if d.Health == pluginapi.Healthy {
+			allocatable++
+		}
+	}
+	return capacity, allocatable
+}
+
+// capacityDrifts returns the resources whose capacity or allocatable in the
+// status of the node differ from the devices advertised by the plugins,
+// recording the differences in the drift metric.
+func capacityDrifts(node *v1.Node, plugins []*NvidiaDevicePlugin) []string {
+	var drifted []string
+	for _, p := range plugins {
+		name := v1.ResourceName(p.resourceName)
+		capacity, allocatable := advertisedDevices(p)
+		nodeCapacity := node.Status.Capacity[name]
+		nodeAllocatable := node.Status.Allocatable[name]
+
+		capacityDrift.Set(float64(nodeAllocatable.Value()-allocatable), p.resourceName)
+		if nodeCapacity.Value() != capacity || nodeAllocatable.Value() != allocatable {
+			log.Printf("Node reports %d/%d allocatable %s devices, the plugin advertises %d/%d.",
+				nodeAllocatable.Value(), nodeCapacity.Value(), p.resourceName, allocatable, capacity)
+			drifted = append(drifted, p.resourceName)
+		}
+	}
+	return drifted
+}
+
+// watchCapacityDrift compares the capacity and allocatable of the resources
+// of the plugin in the status of the node with the advertised devices until
+// stop is closed, and notifies reregister of the resources that drifted apart
+// on two checks in a row.
+func (vgm *vGPUManager) watchCapacityDrift(client kubernetes.Interface, stop <-chan struct{}, reregister chan<- []string) {
+	ticker := time.NewTicker(capacityDriftInterval)
+	defer ticker.Stop()
+
+	drifting := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		node, err := client.CoreV1().Nodes().Get(vgm.config.NodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Failed to get node %s to check its capacity: %v", vgm.config.NodeName, err)
+			continue
+		}
+		drifted := capacityDrifts(node, vgm.devicePlugins())
+		if len(drifted) == 0 {
+			drifting = false
+			continue
+		}
+		if !drifting {
+			drifting = true
+			continue
+		}
+
+		log.Printf("Capacity of %v drifted from the advertised devices, registering again with kubelet.", drifted)
+		capacityReregistrations.Inc()
+		drifting = false
+		select {
+		case reregister <- drifted:
+		default:
+		}
+	}
+}
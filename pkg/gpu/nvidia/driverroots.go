@@ -0,0 +1,73 @@
+package nvidia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseDriverRoots parses the driver roots of GPUs of the form
+// "0=/run/nvidia/driver/usr/lib64,1=/home/kubernetes/bin/nvidia".
+func ParseDriverRoots(s string) (map[int]string, error) {
+	roots := make(map[int]string)
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid driver root %q, expected <GPU index>=<directory>", f)
+		}
+		i, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPU index of driver root %q: %v", f, err)
+		}
+		roots[int(i)] = parts[1]
+	}
+	return roots, nil
+}
+
+// driverDir returns the host directory of the driver libraries to mount into
+// a container using the physical GPUs with the given IDs: the one of the
+// backend, or the driver root of the plugin when the backend only tells where
+// containers find the driver, unless the GPUs have their own driver root. A
+// container can not mount several drivers at the same place, its GPUs must
+// share their driver.
+func (m *NvidiaDevicePlugin) driverDir(edits ContainerEdits, ids []string) (string, error) {
+	base := m.driverBase(edits)
+	dir := base
+	for i, id := range ids {
+		d := base
+		if root, ok := m.config.DriverRoots[m.gpus[id].Index]; ok {
+			d = root
+		}
+		if i > 0 && d != dir {
+			return "", fmt.Errorf("GPUs %s are driven by different drivers, %s and %s", strings.Join(ids, ","), dir, d)
+		}
+		dir = d
+	}
+	return dir, nil
+}
+
+// driverBase returns the host directory of the driver libraries of edits,
+// the driver root of the plugin when the backend only tells where containers
+// find the driver.
+func (m *NvidiaDevicePlugin) driverBase(edits ContainerEdits) string {
+	if edits.DriverDir == "" && edits.DriverContainerDir != "" {
+		return m.config.DriverRoot
+	}
+	return edits.DriverDir
+}
+
+// driverRoots returns the driver roots of the physical GPUs of the plugin.
+func (m *NvidiaDevicePlugin) driverRoots() []string {
+	var roots []string
+	seen := make(map[string]bool)
+	for _, gpu := range m.gpus {
+		if root, ok := m.config.DriverRoots[gpu.Index]; ok && !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
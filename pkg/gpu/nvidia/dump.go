@@ -0,0 +1,40 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"log"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// stateDump is the full state of the plugin dumped to the log on SIGUSR1.
+type stateDump struct {
+	*pluginState
+	Registered bool               `json:"registered"`
+	Health     []gpuHealthSummary `json:"health"`
+	// Devices are the devices of every resource as advertised to kubelet.
+	Devices map[string][]*pluginapi.Device `json:"devices"`
+	Config  Config                         `json:"config"`
+}
+
+// dumpState logs the full state of the plugin as JSON, so that operators can
+// snapshot it on a live node without any network endpoint.
+func (vgm *vGPUManager) dumpState() {
+	dump := stateDump{
+		pluginState: vgm.state(),
+		Registered:  vgm.isRegistered(),
+		Health:      vgm.healthSummary(),
+		Devices:     make(map[string][]*pluginapi.Device),
+		Config:      vgm.config,
+	}
+	for _, p := range vgm.devicePlugins() {
+		dump.Devices[p.resourceName] = p.devices.snapshot()
+	}
+
+	b, err := json.Marshal(dump)
+	if err != nil {
+		log.Printf("Failed to encode the state dump: %v", err)
+		return
+	}
+	log.Printf("State dump: %s", b)
+}
@@ -0,0 +1,128 @@
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// fabricInterval is how often the fabric state of the GPUs is checked.
+	fabricInterval = time.Minute
+	// fabricUnready is the health error of the GPUs whose NVLink fabric is
+	// not set up by the fabric manager.
+	fabricUnready = "NVLink fabric not ready"
+)
+
+var fabricReady = metrics.NewGaugeVec("vgpu_gpu_fabric_ready",
+	"Whether the fabric manager set up the NVLink fabric of the GPU of an NVSwitch system.", "uuid")
+
+// nvswitchDevices returns the NVSwitch device nodes of the node, e.g.
+// /dev/nvidia-nvswitchctl and /dev/nvidia-nvswitch0, sorted by path. Only
+// NVSwitch systems, e.g. DGX and HGX, have them.
+func nvswitchDevices() []string {
+	paths, _ := filepath.Glob("/dev/nvidia-nvswitch*")
+	sort.Strings(paths)
+	return paths
+}
+
+// parseFabricStates parses the output of nvidia-smi -q and returns whether
+// the fabric of every GPU reporting one, by UUID, is set up, e.g. from the
+// "State : Completed" and "Status : Success" lines of its "Fabric" section.
+func parseFabricStates(out []byte) map[string]bool {
+	ready := make(map[string]bool)
+	var uuid, state string
+	inFabric := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		key := strings.TrimSpace(fields[0])
+		if len(fields) == 1 {
+			inFabric = key == "Fabric"
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch {
+		case key == "GPU UUID" || key == "UUID":
+			uuid, inFabric = value, false
+		case inFabric && key == "State":
+			state = value
+		case inFabric && key == "Status" && uuid != "":
+			ready[uuid] = state == "Completed" && value == "Success"
+			inFabric = false
+		}
+	}
+	return ready
+}
+
+// getFabricStates runs nvidia-smi, the NVML bindings do not expose the fabric
+// state of the GPUs.
+func getFabricStates() (map[string]bool, error) {
+	out, err := exec.Command("nvidia-smi", "-q").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseFabricStates(out), nil
+}
+
+// watchFabric marks the devices of the GPUs whose NVLink fabric is not set
+// up unhealthy, e.g. while the fabric manager is down, since CUDA fails to
+// initialize on them, and healthy again once it is, until stop is closed.
+// GPUs whose driver does not report their fabric are not checked.
+func (vgm *vGPUManager) watchFabric(stop <-chan struct{}) {
+	ticker := time.NewTicker(fabricInterval)
+	defer ticker.Stop()
+
+	unready := make(map[string]bool)
+	for {
+		states, err := getFabricStates()
+		if err != nil {
+			log.Printf("Failed to get the fabric state of the GPUs: %v", err)
+		}
+		for _, gpu := range vgm.gpus {
+			ready, ok := states[gpu.UUID]
+			if !ok {
+				continue
+			}
+			if ready {
+				fabricReady.Set(1, gpu.UUID)
+			} else {
+				fabricReady.Set(0, gpu.UUID)
+			}
+			if ready != unready[gpu.UUID] {
+				continue
+			}
+			unready[gpu.UUID] = !ready
+
+			health := pluginapi.Unhealthy
+			if !ready {
+				log.Printf("Warning: the NVLink fabric of GPU %d (%s) is not ready, is the fabric manager running?", gpu.Index, gpu.UUID)
+				vgm.healthLog.unhealthy(gpu.UUID, nil, fabricUnready)
+			} else if vgm.healthLog.healthy(gpu.UUID, fabricUnready) {
+				log.Printf("The NVLink fabric of GPU %d (%s) is ready.", gpu.Index, gpu.UUID)
+				health = pluginapi.Healthy
+			} else {
+				// The GPU failed meanwhile, it stays unhealthy.
+				continue
+			}
+			for _, p := range vgm.devicePlugins() {
+				p.setGPUHealth(vgm.config.gpuID(gpu), health)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package nvidia
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/httpserver"
+)
+
+// faultInjector serves debug endpoints injecting failures at runtime, so
+// that the recovery logic can be exercised in CI and during game days:
+//
+//	POST /faults/xid?uuid=<GPU UUID>&xid=<Xid>  reports a critical Xid error,
+//	                                            on every GPU without uuid
+//	POST /faults/backend?error=<message>        fails every backend call,
+//	                                            without error to recover
+//	POST /faults/remove-sockets                 removes the plugin sockets
+type faultInjector struct {
+	vgm *vGPUManager
+
+	sync.Mutex
+	backendErr error
+}
+
+// faultyBackend is a DeviceBackend failing with the error injected, if any.
+type faultyBackend struct {
+	DeviceBackend
+	faults *faultInjector
+}
+
+func (f *faultInjector) err() error {
+	f.Lock()
+	defer f.Unlock()
+	return f.backendErr
+}
+
+func (b faultyBackend) Discover() ([]GPU, error) {
+	if err := b.faults.err(); err != nil {
+		return nil, err
+	}
+	return b.DeviceBackend.Discover()
+}
+
+func (b faultyBackend) GetUtilization() (map[string]GPUUsage, error) {
+	if err := b.faults.err(); err != nil {
+		return nil, err
+	}
+	return b.DeviceBackend.GetUtilization()
+}
+
+func (b faultyBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	if err := b.faults.err(); err != nil {
+		return nil, err
+	}
+	return b.DeviceBackend.GetProcesses()
+}
+
+func (b faultyBackend) GetDriverInfo() (DriverInfo, error) {
+	if err := b.faults.err(); err != nil {
+		return DriverInfo{}, err
+	}
+	return b.DeviceBackend.GetDriverInfo()
+}
+
+func (b faultyBackend) SetComputeMode(uuid, mode string) error {
+	if err := b.faults.err(); err != nil {
+		return err
+	}
+	return b.DeviceBackend.SetComputeMode(uuid, mode)
+}
+
+// injectXid marks the virtual GPUs of the physical GPU as unhealthy, as a
+// critical Xid error would, on every device plugin serving them.
+func (f *faultInjector) injectXid(uuid string, xid uint64) int {
+	id := f.vgm.gpuIDs()[uuid]
+	count := 0
+	for _, p := range f.vgm.devicePlugins() {
+		for _, d := range p.devices.snapshot() {
+			if uuid == "" || getPhysicalDeviceID(d.ID) == id {
+				p.unhealthy(d)
+				count++
+			}
+		}
+	}
+	var uuids []string
+	for _, gpu := range f.vgm.gpus {
+		uuids = append(uuids, gpu.UUID)
+	}
+	f.vgm.healthLog.unhealthy(uuid, uuids, fmt.Sprintf("Xid %d", xid))
+	log.Printf("Fault injection: Xid=%d on GPU=%q, %d devices reported unhealthy", xid, uuid, count)
+	return count
+}
+
+func (f *faultInjector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/faults/xid", f.post(func(r *http.Request) (string, error) {
+		xid, err := strconv.ParseUint(r.FormValue("xid"), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid xid: %v", err)
+		}
+		count := f.injectXid(r.FormValue("uuid"), xid)
+		return fmt.Sprintf("%d devices reported unhealthy", count), nil
+	}))
+	mux.HandleFunc("/faults/backend", f.post(func(r *http.Request) (string, error) {
+		f.Lock()
+		defer f.Unlock()
+
+		if msg := r.FormValue("error"); msg != "" {
+			f.backendErr = errors.New(msg)
+			log.Printf("Fault injection: backend calls fail with %q", msg)
+			return "backend calls fail", nil
+		}
+		f.backendErr = nil
+		log.Println("Fault injection: backend calls succeed again")
+		return "backend calls succeed", nil
+	}))
+	mux.HandleFunc("/faults/remove-sockets", f.post(func(r *http.Request) (string, error) {
+		count := 0
+		for _, p := range f.vgm.devicePlugins() {
+			if err := os.Remove(p.socket); err != nil {
+				return "", err
+			}
+			count++
+		}
+		log.Printf("Fault injection: removed %d plugin sockets", count)
+		return fmt.Sprintf("%d sockets removed", count), nil
+	}))
+	return mux
+}
+
+// post adapts a fault injection to a POST only handler.
+func (f *faultInjector) post(inject func(r *http.Request) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		msg, err := inject(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, msg)
+	}
+}
+
+// serve serves the fault injection endpoints on address.
+func (f *faultInjector) serve(address string) {
+	log.Printf("WARNING: fault injection enabled on %s, never enable it in production.", address)
+	log.Printf("Fault injection server stopped: %v", httpserver.Serve(address, "", "", f.Handler()))
+}
+
+// isPluginSocket reports whether path is the socket of one of the plugins.
+func isPluginSocket(plugins []*NvidiaDevicePlugin, path string) bool {
+	for _, p := range plugins {
+		if p.socket == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// GPUNodeAPIVersion and GPUNodeKind identify the GPUNode custom
+	// resource, installed by manifests/gpunode-crd.yml.
+	GPUNodeAPIVersion = "hkube.io/v1alpha1"
+	GPUNodeKind       = "GPUNode"
+
+	gpuNodesPath    = "/apis/" + GPUNodeAPIVersion + "/gpunodes"
+	gpuNodeInterval = 30 * time.Second
+)
+
+// gpuNodeStatus is the inventory of the node published in its GPUNode.
+type gpuNodeStatus struct {
+	Driver    string          `json:"driver,omitempty"`
+	GPUs      []gpuNodeGPU    `json:"gpus"`
+	Resources []resourceState `json:"resources"`
+}
+
+// gpuNodeGPU is a physical GPU with its virtual GPUs, allocations and health.
+type gpuNodeGPU struct {
+	UUID   string `json:"uuid"`
+	Memory uint64 `json:"memory,omitempty"`
+	gpuState
+	gpuHealthStatus
+}
+
+// gpuNodeStatus returns the current inventory of the node.
+func (vgm *vGPUManager) gpuNodeStatus() *gpuNodeStatus {
+	state := vgm.state()
+	status := &gpuNodeStatus{Driver: state.Driver, Resources: state.Resources}
+	for i, gpu := range vgm.gpus {
+		status.GPUs = append(status.GPUs, gpuNodeGPU{
+			UUID:            gpu.UUID,
+			Memory:          gpu.Memory,
+			gpuState:        state.GPUs[i],
+			gpuHealthStatus: vgm.healthLog.status(gpu.UUID),
+		})
+	}
+	return status
+}
+
+// publishGPUNode keeps the GPUNode of the node up to date, on every
+// allocation or health change and periodically, until stop is closed. The
+// GPUNode is owned by the node, so that it is deleted along with it.
+func (vgm *vGPUManager) publishGPUNode(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(gpuNodeInterval)
+	defer ticker.Stop()
+
+	changes := vgm.ledger.subscribe()
+	unhealthy := vgm.healthLog.subscribe()
+	var owner []metav1.OwnerReference
+	var published string
+	for {
+		if owner == nil {
+			if node, err := client.CoreV1().Nodes().Get(vgm.config.NodeName, metav1.GetOptions{}); err != nil {
+				log.Printf("Failed to get node %s owning its GPUNode: %v", vgm.config.NodeName, err)
+			} else {
+				owner = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: node.Name, UID: node.UID}}
+			}
+		}
+
+		status := vgm.gpuNodeStatus()
+		if b, err := json.Marshal(status); err != nil {
+			log.Printf("Failed to encode GPUNode %s: %v", vgm.config.NodeName, err)
+		} else if value := string(b); owner != nil && value != published {
+			err := kube.ApplyCustomObject(client, gpuNodesPath, &kube.CustomObject{
+				TypeMeta:   metav1.TypeMeta{APIVersion: GPUNodeAPIVersion, Kind: GPUNodeKind},
+				ObjectMeta: metav1.ObjectMeta{Name: vgm.config.NodeName, OwnerReferences: owner},
+				Status:     status,
+			})
+			if err != nil {
+				log.Printf("Failed to publish GPUNode %s: %v", vgm.config.NodeName, err)
+			} else {
+				published = value
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-changes:
+		case <-unhealthy:
+		}
+	}
+}
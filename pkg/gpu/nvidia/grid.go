@@ -0,0 +1,151 @@
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// gridLicenseInterval is how often the license of the vGPUs is checked.
+	// Licensing completes shortly after the guest boots.
+	gridLicenseInterval = time.Minute
+	// gridUnlicensed is the health error of unlicensed vGPUs.
+	gridUnlicensed = "vGPU unlicensed"
+)
+
+var gridLicensed = metrics.NewGaugeVec("vgpu_grid_licensed",
+	"Whether the NVIDIA vGPU (GRID) is licensed, unlicensed vGPUs run with degraded performance.", "uuid")
+
+// gridProfilePattern matches the name NVML reports for an NVIDIA vGPU (GRID)
+// in a virtual machine, e.g. "GRID T4-4Q", "NVIDIA A10-24Q" or the MIG backed
+// "GRID A100-1-5C", and captures its profile. Physical GPUs, e.g.
+// "NVIDIA A100-SXM4-40GB", do not match.
+var gridProfilePattern = regexp.MustCompile(`^(?:GRID|NVIDIA) ([A-Z][A-Za-z0-9]*-(?:[0-9]+-)?[0-9]+[ABCQ])$`)
+
+// gridBackend recognizes the vGPUs handed to the node by a GRID licensed
+// hypervisor. They are already isolated by the hardware, each of them is
+// advertised as a single virtual GPU, while the other GPUs keep being shared
+// in software.
+type gridBackend struct {
+	DeviceBackend
+}
+
+// NewGRIDBackend returns the DeviceBackend mapping the virtual GPUs to the
+// vGPU profiles of the GPUs discovered by backend.
+func NewGRIDBackend(backend DeviceBackend) DeviceBackend {
+	return gridBackend{DeviceBackend: backend}
+}
+
+func (b gridBackend) Discover() ([]GPU, error) {
+	gpus, err := b.DeviceBackend.Discover()
+	if err != nil {
+		return nil, err
+	}
+	for i := range gpus {
+		m := gridProfilePattern.FindStringSubmatch(gpus[i].Model)
+		if m == nil {
+			log.Printf("GPU %d is not a vGPU, its virtual GPUs are shared in software.", gpus[i].Index)
+			continue
+		}
+		gpus[i].VGPUProfile = m[1]
+		log.Printf("GPU %d is a %s vGPU, advertised as a single virtual GPU.", gpus[i].Index, m[1])
+	}
+	return gpus, nil
+}
+
+// parseGRIDLicenses parses the output of nvidia-smi -q and returns whether
+// every GPU reporting a license status, by UUID, is licensed, e.g. from
+// "License Status : Licensed (Expiry: 2023-1-1 0:0:0 GMT)" or
+// "License Status : Unlicensed (Restricted)".
+func parseGRIDLicenses(out []byte) map[string]bool {
+	licenses := make(map[string]bool)
+	var uuid string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		switch key {
+		case "GPU UUID", "UUID":
+			uuid = value
+		case "License Status":
+			if uuid != "" {
+				licenses[uuid] = strings.HasPrefix(value, "Licensed")
+			}
+		}
+	}
+	return licenses
+}
+
+// getGRIDLicenses runs nvidia-smi, the NVML bindings do not expose the
+// licensable features of vGPUs.
+func getGRIDLicenses() (map[string]bool, error) {
+	out, err := exec.Command("nvidia-smi", "-q").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseGRIDLicenses(out), nil
+}
+
+// watchGRIDLicenses marks the devices of the unlicensed vGPUs unhealthy,
+// since they fail silently with degraded performance, and healthy again once
+// they are licensed, until stop is closed.
+func (vgm *vGPUManager) watchGRIDLicenses(stop <-chan struct{}) {
+	ticker := time.NewTicker(gridLicenseInterval)
+	defer ticker.Stop()
+
+	unlicensed := make(map[string]bool)
+	for {
+		licenses, err := getGRIDLicenses()
+		if err != nil {
+			log.Printf("Failed to get the license status of the vGPUs: %v", err)
+		}
+		for _, gpu := range vgm.gpus {
+			licensed, ok := licenses[gpu.UUID]
+			if gpu.VGPUProfile == "" || !ok {
+				continue
+			}
+			if licensed {
+				gridLicensed.Set(1, gpu.UUID)
+			} else {
+				gridLicensed.Set(0, gpu.UUID)
+			}
+			if licensed != unlicensed[gpu.UUID] {
+				continue
+			}
+			unlicensed[gpu.UUID] = !licensed
+
+			health := pluginapi.Unhealthy
+			if !licensed {
+				log.Printf("Warning: vGPU %d (%s) is unlicensed, its devices go unhealthy until it is licensed.", gpu.Index, gpu.UUID)
+				vgm.healthLog.unhealthy(gpu.UUID, nil, gridUnlicensed)
+			} else if vgm.healthLog.healthy(gpu.UUID, gridUnlicensed) {
+				log.Printf("vGPU %d (%s) is licensed again.", gpu.Index, gpu.UUID)
+				health = pluginapi.Healthy
+			} else {
+				// The vGPU failed meanwhile, it stays unhealthy.
+				continue
+			}
+			for _, p := range vgm.devicePlugins() {
+				p.setGPUHealth(vgm.config.gpuID(gpu), health)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
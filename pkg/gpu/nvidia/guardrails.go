@@ -0,0 +1,141 @@
+package nvidia
+
+import (
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const guardrailInterval = 10 * time.Second
+
+var gpuWithheldVGPUs = metrics.NewGaugeVec("vgpu_gpu_withheld_vgpus",
+	"Virtual GPUs of the physical GPU no longer advertised by the oversubscription guardrails.", "gpu")
+
+// gpuOccupancy is the use of a physical GPU by the running pods.
+type gpuOccupancy struct {
+	pods map[string]bool
+	// allocated are the virtual GPUs held by the pods, whatever the resource.
+	allocated map[string]bool
+}
+
+// occupancy returns the pods, by namespace/name, and the allocated virtual
+// GPUs of every physical GPU, along with the devices allocated through every
+// resource.
+func occupancy(config Config, gpus map[string]GPU, pods []*podresourcesapi.PodResources) (map[string]*gpuOccupancy, map[string]map[string]bool) {
+	occupancies := make(map[string]*gpuOccupancy)
+	devices := make(map[string]map[string]bool)
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			for _, id := range containerVGPUs(config, gpus, c) {
+				gpu := getPhysicalDeviceID(id)
+				o, ok := occupancies[gpu]
+				if !ok {
+					o = &gpuOccupancy{pods: make(map[string]bool), allocated: make(map[string]bool)}
+					occupancies[gpu] = o
+				}
+				o.pods[pod.Namespace+"/"+pod.Name] = true
+				o.allocated[id] = true
+			}
+			for _, d := range c.Devices {
+				if devices[d.ResourceName] == nil {
+					devices[d.ResourceName] = make(map[string]bool)
+				}
+				for _, id := range d.DeviceIds {
+					devices[d.ResourceName][id] = true
+				}
+			}
+		}
+	}
+	return occupancies, devices
+}
+
+// full reports whether the guardrails forbid handing out more virtual GPUs
+// of the physical GPU.
+func (o *gpuOccupancy) full(config Config, gpu GPU) bool {
+	if config.MaxPodsPerGPU > 0 && uint(len(o.pods)) >= config.MaxPodsPerGPU {
+		return true
+	}
+	budget := uint(len(o.allocated)) * 100 / uint(config.vGPUCount(gpu))
+	return config.MaxMemoryBudgetPercent > 0 && budget >= config.MaxMemoryBudgetPercent
+}
+
+// fullGPUs returns the physical GPUs the guardrails forbid handing out more
+// virtual GPUs of.
+func (vgm *vGPUManager) fullGPUs(gpus map[string]*gpuOccupancy) map[string]bool {
+	full := make(map[string]bool)
+	gpuWithheldVGPUs.Reset()
+	for _, gpu := range vgm.gpus {
+		id := vgm.config.gpuID(gpu)
+		o, ok := gpus[id]
+		if !ok || !o.full(vgm.config, gpu) {
+			continue
+		}
+		full[id] = true
+		gpuWithheldVGPUs.Set(float64(vgm.config.vGPUCount(gpu)-len(o.allocated)), id)
+	}
+	return full
+}
+
+// withheldDevices returns the free devices of the plugin which can not be
+// handed out: those of the full GPUs, and those holding virtual GPUs already
+// allocated through another resource. occupied holds the virtual GPUs of the
+// running containers and recent maps the virtual GPUs allocated since kubelet
+// may not report their container to their resource.
+func (m *NvidiaDevicePlugin) withheldDevices(full, occupied, allocated map[string]bool, recent map[string]string) map[string]bool {
+	withheld := make(map[string]bool)
+	for _, d := range m.devices.snapshot() {
+		if allocated[d.ID] {
+			continue
+		}
+		if full[getPhysicalDeviceID(d.ID)] {
+			withheld[d.ID] = true
+			continue
+		}
+		for _, id := range m.vGPUs([]string{d.ID}) {
+			if resource, ok := recent[id]; occupied[id] || ok && resource != m.resourceName {
+				withheld[d.ID] = true
+			}
+		}
+	}
+	return withheld
+}
+
+// reconcileDevices stops advertising the devices which can not be handed out
+// until stop is closed: the free devices of the physical GPUs used by too
+// many pods, or whose allocated virtual GPUs already hold too much of their
+// memory, and the devices of every resource holding virtual GPUs allocated
+// through another one.
+func (vgm *vGPUManager) reconcileDevices(stop <-chan struct{}) {
+	ticker := time.NewTicker(guardrailInterval)
+	defer ticker.Stop()
+	changes := vgm.ledger.subscribe()
+
+	for {
+		pods, err := listPodResources()
+		if err != nil {
+			log.Printf("Failed to list pod resources: %v", err)
+		} else {
+			gpus, devices := occupancy(vgm.config, vgm.gpusByID(), pods)
+			full := vgm.fullGPUs(gpus)
+			occupied := make(map[string]bool)
+			for _, o := range gpus {
+				for id := range o.allocated {
+					occupied[id] = true
+				}
+			}
+			recent := vgm.ledger.allocatedSince(time.Now().Add(-assignmentTimeout))
+			for _, p := range vgm.devicePlugins() {
+				p.withhold(p.withheldDevices(full, occupied, devices[p.resourceName], recent))
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package nvidia
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+)
+
+// handoverInterval is how often an instance checks whether a newer one took
+// over.
+const handoverInterval = 2 * time.Second
+
+// newInstanceID returns a random ID telling the instances of the plugin of a
+// node apart during an upgrade.
+func newInstanceID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Replace(time.Now().Format("150405.000000"), ".", "", 1)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ownerFile returns the file naming the instance owning the node, next to
+// the checkpoint.
+func (c Config) ownerFile() string {
+	return c.CheckpointFile + ".owner"
+}
+
+// readOwner returns the instance owning the node, empty when none does.
+func readOwner(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// claim records the instance as the owner of the node once its device
+// plugins are registered with kubelet, which then only talks to their
+// sockets. Only the first registration claims the node, so that an instance
+// re-registering on a kubelet restart does not take the node back from a
+// newer one.
+func (vgm *vGPUManager) claim() {
+	select {
+	case <-vgm.claimed:
+		return
+	default:
+	}
+
+	path := vgm.config.ownerFile()
+	if err := writeFileSync(path, []byte(vgm.config.instance+"\n"), 0600); err != nil {
+		log.Printf("Failed to claim the node in %s: %v", path, err)
+		return
+	}
+	log.Printf("Instance %s took over the node.", vgm.config.instance)
+	close(vgm.claimed)
+}
+
+// watchHandover closes handedOver once a newer instance claimed the node,
+// until stop is closed.
+func (vgm *vGPUManager) watchHandover(stop <-chan struct{}) {
+	ticker := time.NewTicker(handoverInterval)
+	defer ticker.Stop()
+
+	select {
+	case <-stop:
+		return
+	case <-vgm.claimed:
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if owner := readOwner(vgm.config.ownerFile()); owner != "" && owner != vgm.config.instance {
+			log.Printf("Instance %s took over the node, handing over.", owner)
+			close(vgm.handedOver)
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandoverToANewerInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vgpu-handover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testConfig()
+	config.DevicePluginPath = dir + "/"
+	config.CheckpointFile = filepath.Join(dir, "checkpoint")
+	config.Handover = true
+	previous := newTestManager(t, config, NewMockBackend(2))
+	next := newTestManager(t, config, NewMockBackend(2))
+	if previous.config.pluginSocket("vgpu.sock") == next.config.pluginSocket("vgpu.sock") {
+		t.Fatal("both instances serve the same socket")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	previous.claim()
+	go previous.watchHandover(stop)
+	if owner := readOwner(config.ownerFile()); owner != previous.config.instance {
+		t.Fatalf("the node is owned by %q, want the running instance %s", owner, previous.config.instance)
+	}
+
+	// The new instance registered all its resources.
+	next.claim()
+	select {
+	case <-previous.handedOver:
+	case <-time.After(3 * handoverInterval):
+		t.Fatal("the previous instance did not hand over the node")
+	}
+
+	// A kubelet restart registers the previous instance again, which does
+	// not take the node back.
+	previous.claim()
+	if owner := readOwner(config.ownerFile()); owner != next.config.instance {
+		t.Errorf("the node is owned by %q, want the new instance %s", owner, next.config.instance)
+	}
+}
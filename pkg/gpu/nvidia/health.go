@@ -0,0 +1,144 @@
+package nvidia
+
+import (
+	"sync"
+	"time"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// healthQueue collects the devices reported unhealthy without ever blocking
+// the reporter, even when no ListAndWatch stream is receiving. Repeated
+// reports of a device are coalesced until they are consumed.
+type healthQueue struct {
+	sync.Mutex
+	pending map[string]*pluginapi.Device
+
+	// changes is notified, without blocking, whenever a device is reported.
+	changes chan struct{}
+}
+
+func newHealthQueue() *healthQueue {
+	return &healthQueue{
+		pending: make(map[string]*pluginapi.Device),
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// push queues the device as unhealthy.
+func (q *healthQueue) push(dev *pluginapi.Device) {
+	q.Lock()
+	q.pending[dev.ID] = dev
+	q.Unlock()
+
+	select {
+	case q.changes <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns and forgets the queued devices.
+func (q *healthQueue) pop() []*pluginapi.Device {
+	q.Lock()
+	defer q.Unlock()
+
+	devs := make([]*pluginapi.Device, 0, len(q.pending))
+	for id, d := range q.pending {
+		devs = append(devs, d)
+		delete(q.pending, id)
+	}
+	return devs
+}
+
+// gpuHealthStatus is the health of a physical GPU along with its last error.
+type gpuHealthStatus struct {
+	Healthy       bool       `json:"healthy"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// gpuHealthLog records the health of the physical GPUs by UUID, the GPUs
+// missing from it are healthy.
+type gpuHealthLog struct {
+	sync.Mutex
+	gpus map[string]gpuHealthStatus
+
+	// subscribers are notified, without blocking, whenever a GPU is
+	// recorded.
+	subscribers []chan struct{}
+}
+
+func newGPUHealthLog() *gpuHealthLog {
+	return &gpuHealthLog{gpus: make(map[string]gpuHealthStatus)}
+}
+
+// subscribe returns a channel notified whenever a GPU is recorded.
+func (l *gpuHealthLog) subscribe() <-chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+
+	c := make(chan struct{}, 1)
+	l.subscribers = append(l.subscribers, c)
+	return c
+}
+
+// unhealthy records the GPU with the given UUID, every GPU of uuids when it
+// is empty, as unhealthy because of reason, e.g. "Xid 79". A nil log
+// records nothing.
+func (l *gpuHealthLog) unhealthy(uuid string, uuids []string, reason string) {
+	if l == nil {
+		return
+	}
+	if uuid != "" {
+		uuids = []string{uuid}
+	}
+
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	for _, uuid := range uuids {
+		l.gpus[uuid] = gpuHealthStatus{LastError: reason, LastErrorTime: &now}
+	}
+	for _, c := range l.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// healthy records the GPU with the given UUID as healthy again, keeping its
+// last error, when it is unhealthy because of reason only. It reports whether
+// the GPU is healthy.
+func (l *gpuHealthLog) healthy(uuid, reason string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	s, ok := l.gpus[uuid]
+	if !ok || s.Healthy {
+		return true
+	}
+	if s.LastError != reason {
+		return false
+	}
+	s.Healthy = true
+	l.gpus[uuid] = s
+	for _, c := range l.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// status returns the health of the GPU with the given UUID.
+func (l *gpuHealthLog) status(uuid string) gpuHealthStatus {
+	l.Lock()
+	defer l.Unlock()
+
+	if s, ok := l.gpus[uuid]; ok {
+		return s
+	}
+	return gpuHealthStatus{Healthy: true}
+}
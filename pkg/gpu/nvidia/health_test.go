@@ -0,0 +1,86 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthcheckReportsXid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vgpu-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testConfig()
+	config.DevicePluginPath = dir + "/"
+	backend := NewMockBackend(2)
+	vgm := newTestManager(t, config, backend)
+	p := vgm.newDevicePlugins()[0]
+	if err := p.Start(); err != nil {
+		t.Fatalf("failed to start the plugin: %v", err)
+	}
+	defer p.Stop()
+
+	uuid := backend.GPUs[1].UUID
+	select {
+	case backend.Events <- HealthEvent{UUID: uuid, Xid: 79}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the health checks are not watching the GPUs")
+	}
+
+	unhealthy := make(map[string]bool)
+	for deadline := time.Now().Add(5 * time.Second); len(unhealthy) < config.VGPUCount && time.Now().Before(deadline); {
+		for _, d := range p.health.pop() {
+			unhealthy[d.ID] = true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(unhealthy) != config.VGPUCount {
+		t.Fatalf("got %d unhealthy devices, want the %d of GPU 1", len(unhealthy), config.VGPUCount)
+	}
+	for id := range unhealthy {
+		if !strings.HasPrefix(id, "1-") {
+			t.Errorf("device %s of GPU 0 reported unhealthy", id)
+		}
+	}
+
+	summary := vgm.healthSummary()
+	if s := summary[1]; s.Healthy || s.LastError != "Xid 79" || s.LastErrorTime == nil {
+		t.Errorf("GPU 1 reported as %+v, want unhealthy with last error Xid 79", s.gpuHealthStatus)
+	}
+	if s := summary[0]; !s.Healthy || s.LastError != "" {
+		t.Errorf("GPU 0 reported as %+v, want healthy", s.gpuHealthStatus)
+	}
+}
+
+func TestHealthcheckIgnoresApplicationXids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vgpu-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testConfig()
+	config.DevicePluginPath = dir + "/"
+	backend := NewMockBackend(1)
+	vgm := newTestManager(t, config, backend)
+	p := vgm.newDevicePlugins()[0]
+	if err := p.Start(); err != nil {
+		t.Fatalf("failed to start the plugin: %v", err)
+	}
+	defer p.Stop()
+
+	// Xid 43 is raised when an application faults, the GPU stays healthy.
+	backend.Events <- HealthEvent{UUID: backend.GPUs[0].UUID, Xid: 43}
+	time.Sleep(100 * time.Millisecond)
+	if devs := p.health.pop(); len(devs) != 0 {
+		t.Errorf("got %d unhealthy devices after an application Xid", len(devs))
+	}
+	if s := vgm.healthSummary()[0]; !s.Healthy {
+		t.Errorf("GPU reported as %+v, want healthy", s.gpuHealthStatus)
+	}
+}
@@ -0,0 +1,68 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// HealthAnnotation is the node annotation summarizing the health of the
+	// physical GPUs.
+	HealthAnnotation = "hkube.io/gpu-health"
+
+	healthSummaryInterval = 30 * time.Second
+)
+
+// gpuHealthSummary is the health of a physical GPU published on the node.
+type gpuHealthSummary struct {
+	Index int    `json:"index"`
+	UUID  string `json:"uuid"`
+	gpuHealthStatus
+}
+
+// healthSummary returns the health of every physical GPU, by index.
+func (vgm *vGPUManager) healthSummary() []gpuHealthSummary {
+	summary := make([]gpuHealthSummary, 0, len(vgm.gpus))
+	for _, gpu := range vgm.gpus {
+		summary = append(summary, gpuHealthSummary{
+			Index:           gpu.Index,
+			UUID:            gpu.UUID,
+			gpuHealthStatus: vgm.healthLog.status(gpu.UUID),
+		})
+	}
+	return summary
+}
+
+// publishHealth keeps the health annotation of the node up to date, as soon
+// as a GPU turns unhealthy and periodically to retry failed updates, until
+// stop is closed.
+func (vgm *vGPUManager) publishHealth(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(healthSummaryInterval)
+	defer ticker.Stop()
+
+	unhealthy := vgm.healthLog.subscribe()
+	var published string
+	for {
+		if b, err := json.Marshal(vgm.healthSummary()); err != nil {
+			log.Printf("Failed to encode GPU health: %v", err)
+		} else if value := string(b); value != published {
+			err := kube.PatchNodeAnnotations(client, vgm.config.NodeName, map[string]*string{HealthAnnotation: &value})
+			if err != nil {
+				log.Printf("Failed to publish GPU health on node %s: %v", vgm.config.NodeName, err)
+			} else {
+				published = value
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-unhealthy:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package nvidia
+
+import (
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"k8s.io/client-go/kubernetes"
+)
+
+// inventoryRefreshInterval bounds how stale the published free memory can be.
+const inventoryRefreshInterval = 30 * time.Second
+
+// getGPUInventory returns the occupancy of the physical GPUs of the node.
+// The free memory is only reported when usage is known.
+func getGPUInventory(gpus []GPU, usage map[string]GPUUsage, config Config, ledger *allocationLedger) *inventory.Node {
+	allocated := ledger.allocatedPerGPU()
+	inv := &inventory.Node{}
+	for _, d := range gpus {
+		id := config.gpuID(d)
+		gpu := inventory.GPU{
+			Index:          d.Index,
+			UUID:           d.UUID,
+			Model:          d.Model,
+			TotalVGPUs:     config.vGPUCount(d),
+			AllocatedVGPUs: allocated[id],
+			MemoryTotal:    d.Memory,
+		}
+		if u, ok := usage[d.UUID]; ok {
+			gpu.MemoryFree = u.MemoryFree
+		}
+		if config.PublishTopology {
+			for j := uint(0); j < uint(config.vGPUCount(d)); j++ {
+				gpu.VGPUs = append(gpu.VGPUs, getVGPUID(id, j))
+			}
+		}
+		inv.GPUs = append(inv.GPUs, gpu)
+	}
+
+	return inv
+}
+
+// publishInventory keeps the inventory annotation of the node up to date,
+// on every allocation change and periodically for the free memory, until
+// stop is closed.
+func (vgm *vGPUManager) publishInventory(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(inventoryRefreshInterval)
+	defer ticker.Stop()
+
+	changes := vgm.ledger.subscribe()
+	var published string
+	for {
+		usage, err := vgm.backend.GetUtilization()
+		if err != nil {
+			log.Printf("Failed to get GPU memory usage: %v", err)
+		}
+
+		inv := getGPUInventory(vgm.gpus, usage, vgm.config, vgm.ledger)
+		if value, err := inv.Encode(); err != nil {
+			log.Printf("Failed to encode GPU inventory: %v", err)
+		} else if value != published {
+			err := kube.PatchNodeAnnotations(client, vgm.config.NodeName, map[string]*string{inventory.Annotation: &value})
+			if err != nil {
+				log.Printf("Failed to publish GPU inventory on node %s: %v", vgm.config.NodeName, err)
+			} else {
+				published = value
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
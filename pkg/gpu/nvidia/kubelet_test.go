@@ -0,0 +1,183 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kubelettest"
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const registrationTimeout = 10 * time.Second
+
+// testPlugins serves the device plugins of a manager as Run does: they are
+// restarted all when the kubelet socket is created, alone when their socket
+// is removed, and on reload.
+type testPlugins struct {
+	reload chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// servePlugins serves the device plugins of vgm until Stop is called.
+func servePlugins(t *testing.T, vgm *vGPUManager) *testPlugins {
+	t.Helper()
+	watcher, err := newFSWatcher(vgm.config.DevicePluginPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPlugins{
+		reload: make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	manager := newPluginManager(vgm)
+	manager.restartAll()
+	go func() {
+		defer close(p.done)
+		defer watcher.Close()
+		retry := time.NewTicker(pluginRetryInterval)
+		defer retry.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				manager.stopAll()
+				return
+			case event := <-watcher.Events:
+				if event.Name == vgm.config.kubeletSocket() && event.Op&fsnotify.Create == fsnotify.Create {
+					manager.restartAll()
+				} else if event.Op&fsnotify.Remove == fsnotify.Remove {
+					manager.restartSocket(event.Name)
+				}
+			case <-p.reload:
+				manager.restartAll()
+			case <-retry.C:
+				manager.retryFailed()
+			}
+		}
+	}()
+	return p
+}
+
+// Stop stops the device plugins.
+func (p *testPlugins) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// startKubelet starts a fake kubelet in a new directory, and returns the
+// configuration of plugins registering with it and a cleanup function.
+func startKubelet(t *testing.T) (*kubelettest.Kubelet, Config, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vgpu-kubelet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubelet, err := kubelettest.New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to start the fake kubelet: %v", err)
+	}
+
+	config := testConfig()
+	config.DevicePluginPath = dir + "/"
+	config.DrainTimeout = time.Second
+	return kubelet, config, func() {
+		kubelet.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// listAndAllocate watches the devices of the plugin registered with req, as
+// kubelet does, and allocates the first two, returning the devices and the
+// response.
+func listAndAllocate(t *testing.T, kubelet *kubelettest.Kubelet, req *pluginapi.RegisterRequest) ([]*pluginapi.Device, *pluginapi.ContainerAllocateResponse) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), registrationTimeout)
+	defer cancel()
+	client, conn, err := kubelet.Dial(ctx, req.Endpoint)
+	if err != nil {
+		t.Fatalf("failed to dial the plugin: %v", err)
+	}
+	defer conn.Close()
+
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		t.Fatalf("ListAndWatch failed: %v", err)
+	}
+	devices, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("ListAndWatch failed: %v", err)
+	}
+
+	resp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"0-0", "1-0"}}},
+	})
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if len(resp.ContainerResponses) != 1 {
+		t.Fatalf("got %d container responses, want 1", len(resp.ContainerResponses))
+	}
+	return devices.Devices, resp.ContainerResponses[0]
+}
+
+func TestKubeletRegisterListAndWatchAllocate(t *testing.T) {
+	kubelet, config, cleanup := startKubelet(t)
+	defer cleanup()
+	backend := NewMockBackend(2)
+	defer backend.Shutdown()
+	plugins := servePlugins(t, newTestManager(t, config, backend))
+	defer plugins.Stop()
+
+	req, err := kubelet.WaitForRegistration(registrationTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ResourceName != resourceName || req.Version != pluginapi.Version {
+		t.Errorf("registered %s with API version %s, want %s with %s", req.ResourceName, req.Version, resourceName, pluginapi.Version)
+	}
+
+	devices, resp := listAndAllocate(t, kubelet, req)
+	if len(devices) != 2*config.VGPUCount {
+		t.Errorf("got %d devices, want %d", len(devices), 2*config.VGPUCount)
+	}
+	for _, d := range devices {
+		if d.Health != pluginapi.Healthy {
+			t.Errorf("device %s is %s", d.ID, d.Health)
+		}
+	}
+	if got := resp.Envs["NVIDIA_VISIBLE_DEVICES"]; got != "0,1" {
+		t.Errorf("NVIDIA_VISIBLE_DEVICES=%q, want the two GPUs", got)
+	}
+}
+
+func TestKubeletRestartRegistersAgain(t *testing.T) {
+	kubelet, config, cleanup := startKubelet(t)
+	defer cleanup()
+	backend := NewMockBackend(2)
+	defer backend.Shutdown()
+	plugins := servePlugins(t, newTestManager(t, config, backend))
+	defer plugins.Stop()
+
+	if _, err := kubelet.WaitForRegistration(registrationTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := kubelet.Restart(); err != nil {
+		t.Fatalf("failed to restart the fake kubelet: %v", err)
+	}
+
+	req, err := kubelet.WaitForRegistration(registrationTimeout)
+	if err != nil {
+		t.Fatalf("the plugin did not register again: %v", err)
+	}
+	if _, resp := listAndAllocate(t, kubelet, req); resp.Envs["NVIDIA_VISIBLE_DEVICES"] == "" {
+		t.Error("the plugin registered again does not allocate")
+	}
+}
@@ -0,0 +1,136 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// kubeletCheckpoint is the checkpoint of the kubelet device manager, in the
+// device plugin directory.
+const kubeletCheckpoint = "kubelet_internal_checkpoint"
+
+// kubeletCheckpointData is the part of the kubelet device manager checkpoint
+// recording the devices allocated to containers.
+type kubeletCheckpointData struct {
+	Data struct {
+		PodDeviceEntries []struct {
+			PodUID        string
+			ContainerName string
+			ResourceName  string
+			// DeviceIDs is a list of device IDs up to Kubernetes 1.19, and a
+			// map of device IDs by NUMA node since.
+			DeviceIDs json.RawMessage
+		}
+	}
+}
+
+// deviceIDs returns the device IDs of a checkpoint entry, whatever the
+// version of kubelet.
+func deviceIDs(raw json.RawMessage) ([]string, error) {
+	var ids []string
+	if err := json.Unmarshal(raw, &ids); err == nil {
+		return ids, nil
+	}
+	var byNode map[string][]string
+	if err := json.Unmarshal(raw, &byNode); err != nil {
+		return nil, fmt.Errorf("unexpected device IDs %s", raw)
+	}
+	for _, nodeIDs := range byNode {
+		ids = append(ids, nodeIDs...)
+	}
+	return ids, nil
+}
+
+// kubeletAllocation is the allocation of devices of a resource to a
+// container, as recorded by kubelet.
+type kubeletAllocation struct {
+	resource string
+	ids      []string
+}
+
+// readKubeletCheckpoint returns the allocations kubelet believes are made.
+// The checksum of the checkpoint is not verified, it is only used as a hint.
+func readKubeletCheckpoint(path string) ([]kubeletAllocation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c kubeletCheckpointData
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid kubelet checkpoint %s: %v", path, err)
+	}
+
+	var allocations []kubeletAllocation
+	for _, e := range c.Data.PodDeviceEntries {
+		ids, err := deviceIDs(e.DeviceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubelet checkpoint entry of pod %s: %v", e.PodUID, err)
+		}
+		allocations = append(allocations, kubeletAllocation{resource: e.ResourceName, ids: ids})
+	}
+	return allocations, nil
+}
+
+// seedFromKubelet records in the ledger the virtual GPUs held by the devices
+// kubelet allocated before the plugin started, so that the occupancy is known
+// before the first reconciliation with the running containers. Allocations
+// already restored from the plugin checkpoint are kept.
+func (vgm *vGPUManager) seedFromKubelet() {
+	path := filepath.Join(vgm.config.DevicePluginPath, kubeletCheckpoint)
+	allocations, err := readKubeletCheckpoint(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: ignoring kubelet checkpoint: %v", err)
+		return
+	}
+
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+	gpus := vgm.gpusByID()
+	seeded := 0
+	for _, a := range allocations {
+		for _, id := range a.ids {
+			for _, vGPU := range vgm.config.heldVGPUs(a.resource, id, gpus) {
+				if _, ok := gpus[getPhysicalDeviceID(vGPU)]; ok && vgm.ledger.seed(vGPU, a.resource, modified) {
+					seeded++
+				}
+			}
+		}
+	}
+	if seeded > 0 {
+		log.Printf("Seeded %d allocated virtual GPUs from the kubelet checkpoint.", seeded)
+	}
+	vgm.kubeletAllocations = allocations
+}
+
+// recoverBudgets writes again the budget files of the containers kubelet
+// allocated devices to, which kubelet mounts again without calling Allocate
+// when it restarts the containers, e.g. after a reboot emptied the budget
+// directory. The files keep their path, which only depends on the devices.
+func (vgm *vGPUManager) recoverBudgets(plugins []*NvidiaDevicePlugin) {
+	recovered := 0
+	for _, a := range vgm.kubeletAllocations {
+		for _, p := range plugins {
+			if p.resourceName != a.resource {
+				continue
+			}
+			if _, err := p.budgetMount(a.ids); err != nil {
+				log.Printf("Failed to recover the GPU budget file of %s devices %v: %v", a.resource, a.ids, err)
+				continue
+			}
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		log.Printf("Recovered %d GPU budget files from the kubelet checkpoint.", recovered)
+	}
+}
@@ -0,0 +1,171 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Node labels, named after the ones of NVIDIA GPU feature discovery so that
+// existing selectors keep working without deploying it.
+const (
+	labelProduct      = "nvidia.com/gpu.product"
+	labelMemory       = "nvidia.com/gpu.memory"
+	labelCount        = "nvidia.com/gpu.count"
+	labelReplicas     = "nvidia.com/gpu.replicas"
+	labelDriverMajor  = "nvidia.com/cuda.driver.major"
+	labelDriverMinor  = "nvidia.com/cuda.driver.minor"
+	labelDriverRev    = "nvidia.com/cuda.driver.rev"
+	labelCUDAMajor    = "nvidia.com/cuda.runtime.major"
+	labelCUDAMinor    = "nvidia.com/cuda.runtime.minor"
+	labelVGPUCapacity = "hkube.io/vgpu.capacity"
+	labelVGPUHealthy  = "hkube.io/vgpu.healthy"
+	labelMemoryTotal  = "hkube.io/gpu.memory.total"
+	labelComputeMin   = "hkube.io/gpu.compute.min"
+	labelComputeMax   = "hkube.io/gpu.compute.max"
+	labelOpenModules  = "hkube.io/gpu.driver.open"
+	labelCC           = "hkube.io/gpu.cc"
+)
+
+// labelInterval is how often the labels of the node are checked for changes.
+const labelInterval = 30 * time.Second
+
+var invalidLabelChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// sanitizeLabelValue turns s into a valid label value.
+func sanitizeLabelValue(s string) string {
+	s = invalidLabelChars.ReplaceAllString(strings.TrimSpace(s), "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-_.")
+}
+
+// getNodeLabels returns the GPU feature labels of the node. The product and
+// memory labels describe the first GPU, nodes are expected to be homogeneous,
+// the total memory sums up all of them. healthy is the number of healthy
+// virtual GPUs.
+func getNodeLabels(gpus []GPU, driverInfo DriverInfo, config Config, healthy int) map[string]string {
+	n := uint(len(gpus))
+	capacity, replicas := 0, config.VGPUCount
+	var memory uint64
+	for i, gpu := range gpus {
+		capacity += config.vGPUCount(gpu)
+		memory += gpu.Memory
+		// With a total capacity the GPUs may differ, the largest count is
+		// reported.
+		if config.TotalVGPUs > 0 && (i == 0 || config.vGPUCount(gpu) > replicas) {
+			replicas = config.vGPUCount(gpu)
+		}
+	}
+	labels := map[string]string{
+		labelCount:        fmt.Sprintf("%d", n),
+		labelReplicas:     fmt.Sprintf("%d", replicas),
+		labelVGPUCapacity: fmt.Sprintf("%d", capacity),
+		labelVGPUHealthy:  fmt.Sprintf("%d", healthy),
+		labelMemoryTotal:  fmt.Sprintf("%d", memory),
+	}
+
+	if n > 0 {
+		if gpus[0].Model != "" {
+			labels[labelProduct] = sanitizeLabelValue(gpus[0].Model)
+		}
+		if gpus[0].Memory != 0 {
+			labels[labelMemory] = fmt.Sprintf("%d", gpus[0].Memory)
+		}
+	}
+
+	// The lowest and highest compute capabilities of the GPUs, as SM
+	// versions, let node affinities require e.g. sm_80 with Gt 79.
+	min, max := 0, 0
+	for _, gpu := range gpus {
+		if cc := gpu.ComputeCapability; cc > 0 {
+			if min == 0 || cc < min {
+				min = cc
+			}
+			if cc > max {
+				max = cc
+			}
+		}
+	}
+	if max > 0 {
+		labels[labelComputeMin] = fmt.Sprintf("%d", min)
+		labels[labelComputeMax] = fmt.Sprintf("%d", max)
+	}
+
+	for i, part := range strings.SplitN(driverInfo.Version, ".", 3) {
+		labels[[]string{labelDriverMajor, labelDriverMinor, labelDriverRev}[i]] = sanitizeLabelValue(part)
+	}
+
+	if driverInfo.Version != "" {
+		labels[labelOpenModules] = fmt.Sprintf("%t", driverInfo.OpenKernelModules)
+		labels[labelCC] = fmt.Sprintf("%t", driverInfo.ConfidentialComputing)
+	}
+
+	if driverInfo.CUDAMajor != nil && driverInfo.CUDAMinor != nil {
+		labels[labelCUDAMajor] = fmt.Sprintf("%d", *driverInfo.CUDAMajor)
+		labels[labelCUDAMinor] = fmt.Sprintf("%d", *driverInfo.CUDAMinor)
+	}
+
+	return labels
+}
+
+// healthyVGPUs returns the number of healthy devices of the virtual GPU
+// resource.
+func (vgm *vGPUManager) healthyVGPUs() int {
+	plugins := vgm.devicePlugins()
+	if len(plugins) == 0 {
+		return len(vgm.devs)
+	}
+	unhealthy, _ := plugins[0].devices.unavailable()
+	return plugins[0].devices.count() - len(unhealthy)
+}
+
+// labelNode keeps the GPU feature labels of the node up to date until stop
+// is closed, only patching the node when they changed. Labels that no longer
+// apply, e.g. the CUDA version once the driver no longer reports it, are
+// removed.
+func (vgm *vGPUManager) labelNode(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(labelInterval)
+	defer ticker.Stop()
+
+	var applied map[string]string
+	for {
+		if driverInfo, err := vgm.backend.GetDriverInfo(); err != nil {
+			log.Printf("Failed to get driver info for the node labels: %v", err)
+		} else {
+			labels := getNodeLabels(vgm.gpus, driverInfo, vgm.config, vgm.healthyVGPUs())
+			patch := make(map[string]*string)
+			for k, v := range labels {
+				if applied == nil || applied[k] != v {
+					v := v
+					patch[k] = &v
+				}
+			}
+			for k := range applied {
+				if _, ok := labels[k]; !ok {
+					patch[k] = nil
+				}
+			}
+
+			if len(patch) > 0 {
+				if err := kube.PatchNodeLabels(client, vgm.config.NodeName, patch); err != nil {
+					log.Printf("Failed to label node %s: %v", vgm.config.NodeName, err)
+				} else {
+					applied = labels
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
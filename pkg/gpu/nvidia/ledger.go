@@ -0,0 +1,162 @@
+package nvidia
+
+import (
+	"sync"
+	"time"
+)
+
+// allocationLedger keeps track of the virtual GPUs handed out to containers,
+// across restarts of the device plugin server.
+type allocationLedger struct {
+	sync.Mutex
+	allocated map[string]time.Time
+	// resources records the resource every virtual GPU was last allocated
+	// through.
+	resources map[string]string
+
+	// subscribers are notified, without blocking, whenever the allocations
+	// change.
+	subscribers []chan struct{}
+}
+
+func newAllocationLedger() *allocationLedger {
+	return &allocationLedger{
+		allocated: make(map[string]time.Time),
+		resources: make(map[string]string),
+	}
+}
+
+// allocate records the virtual GPUs as allocated through resource.
+func (l *allocationLedger) allocate(resource string, ids []string) {
+	l.Lock()
+	now := time.Now()
+	for _, id := range ids {
+		l.allocated[id] = now
+		l.resources[id] = resource
+	}
+	l.Unlock()
+
+	l.notify()
+}
+
+// restore records the virtual GPU as allocated through resource at t, when
+// restoring the allocations of a previous run.
+func (l *allocationLedger) restore(id, resource string, t time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.allocated[id] = t
+	l.resources[id] = resource
+}
+
+// seed records the virtual GPU as allocated through resource at t unless it
+// is already known, and reports whether it was recorded.
+func (l *allocationLedger) seed(id, resource string, t time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.allocated[id]; ok {
+		return false
+	}
+	l.allocated[id] = t
+	l.resources[id] = resource
+	return true
+}
+
+// sync replaces the allocations made before t with held, the resources of the
+// virtual GPUs actually allocated, and returns the virtual GPUs released and
+// recorded.
+func (l *allocationLedger) sync(held map[string]string, t time.Time) (stale, missing []string) {
+	l.Lock()
+	for id, at := range l.allocated {
+		if _, ok := held[id]; !ok && !at.After(t) {
+			delete(l.allocated, id)
+			delete(l.resources, id)
+			stale = append(stale, id)
+		}
+	}
+	for id, resource := range held {
+		if _, ok := l.allocated[id]; !ok {
+			l.allocated[id] = time.Time{}
+			l.resources[id] = resource
+			missing = append(missing, id)
+		}
+	}
+	l.Unlock()
+
+	if len(stale) > 0 || len(missing) > 0 {
+		l.notify()
+	}
+	return stale, missing
+}
+
+// holds reports whether every virtual GPU is recorded as allocated.
+func (l *allocationLedger) holds(ids []string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, id := range ids {
+		if _, ok := l.allocated[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// allocations returns every allocated virtual GPU by ID.
+func (l *allocationLedger) allocations() map[string]checkpointAllocation {
+	l.Lock()
+	defer l.Unlock()
+
+	allocations := make(map[string]checkpointAllocation, len(l.allocated))
+	for id, t := range l.allocated {
+		allocations[id] = checkpointAllocation{Resource: l.resources[id], Time: t}
+	}
+	return allocations
+}
+
+// subscribe returns a channel notified whenever the allocations change.
+func (l *allocationLedger) subscribe() <-chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+
+	c := make(chan struct{}, 1)
+	l.subscribers = append(l.subscribers, c)
+	return c
+}
+
+func (l *allocationLedger) notify() {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, c := range l.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// allocatedPerGPU returns the number of allocated virtual GPUs for every
+// physical GPU.
+func (l *allocationLedger) allocatedPerGPU() map[string]int {
+	l.Lock()
+	defer l.Unlock()
+
+	counts := make(map[string]int)
+	for id := range l.allocated {
+		counts[getPhysicalDeviceID(id)]++
+	}
+	return counts
+}
+
+// allocatedSince returns the resource of the virtual GPUs allocated since t.
+func (l *allocationLedger) allocatedSince(t time.Time) map[string]string {
+	l.Lock()
+	defer l.Unlock()
+
+	recent := make(map[string]string)
+	for id, at := range l.allocated {
+		if at.After(t) {
+			recent[id] = l.resources[id]
+		}
+	}
+	return recent
+}
@@ -0,0 +1,179 @@
+package nvidia
+
+import (
+	"flag"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kubelettest"
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var lifecycleDuration = flag.Duration("lifecycle-duration", 2*time.Second, "How long TestLifecycleStress stresses the plugin")
+
+// lifecycleInterval is the mean interval between two lifecycle events.
+const lifecycleInterval = 50 * time.Millisecond
+
+// every calls f at random intervals around lifecycleInterval until stop is
+// closed.
+func every(stop <-chan struct{}, wg *sync.WaitGroup, f func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(lifecycleInterval/2 + time.Duration(rand.Int63n(int64(lifecycleInterval)))):
+				f()
+			}
+		}
+	}()
+}
+
+// stressClient connects to the plugin as kubelet does, watches its devices
+// and allocates random healthy ones, reconnecting whenever the plugin
+// restarts, until stop is closed. Failures are expected while the plugin
+// restarts.
+func stressClient(kubelet *kubelettest.Kubelet, endpoint string, allocations *int64, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		client, conn, err := kubelet.Dial(ctx, endpoint)
+		cancel()
+		if err != nil {
+			continue
+		}
+		stressWatch(client, allocations, stop)
+		conn.Close()
+	}
+}
+
+func stressWatch(client pluginapi.DevicePluginClient, allocations *int64, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		return
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return
+		}
+
+		var healthy []string
+		for _, d := range resp.Devices {
+			if d.Health == pluginapi.Healthy {
+				healthy = append(healthy, d.ID)
+			}
+		}
+		if len(healthy) == 0 {
+			continue
+		}
+
+		actx, acancel := context.WithTimeout(ctx, time.Second)
+		_, err = client.Allocate(actx, &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{healthy[rand.Intn(len(healthy))]}}},
+		})
+		acancel()
+		if err == nil {
+			atomic.AddInt64(allocations, 1)
+		}
+	}
+}
+
+// TestLifecycleStress serves the plugin on mock GPUs and, concurrently,
+// restarts kubelet, reloads the plugin, reports Xid errors, removes the
+// plugin sockets and issues ListAndWatch and Allocate calls. Run with -race
+// and a longer -lifecycle-duration after changing the start, stop, serve or
+// health update paths.
+func TestLifecycleStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	kubelet, config, cleanup := startKubelet(t)
+	defer cleanup()
+	config.AllocateTimeout = time.Second
+	backend := NewMockBackend(2)
+	defer backend.Shutdown()
+	vgm := newTestManager(t, config, backend)
+	// Kubelet reads the registrations until the plugins stopped, they would
+	// otherwise wait for it.
+	registrations := make(chan struct{})
+	defer close(registrations)
+	plugins := servePlugins(t, vgm)
+	defer plugins.Stop()
+
+	req, err := kubelet.WaitForRegistration(registrationTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := config.pluginSocket(req.Endpoint)
+	go func() {
+		for {
+			select {
+			case <-registrations:
+				return
+			default:
+				kubelet.WaitForRegistration(lifecycleInterval)
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var allocations int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stressClient(kubelet, req.Endpoint, &allocations, stop)
+		}()
+	}
+	every(stop, &wg, func() {
+		if err := kubelet.Restart(); err != nil {
+			t.Errorf("failed to restart the fake kubelet: %v", err)
+		}
+	})
+	every(stop, &wg, func() {
+		select {
+		case plugins.reload <- struct{}{}:
+		case <-stop:
+		}
+	})
+	every(stop, &wg, func() {
+		select {
+		case backend.Events <- HealthEvent{UUID: backend.GPUs[rand.Intn(len(backend.GPUs))].UUID, Xid: 79}:
+		case <-time.After(lifecycleInterval):
+		}
+	})
+	every(stop, &wg, func() { os.Remove(socket) })
+
+	time.Sleep(*lifecycleDuration)
+	close(stop)
+	wg.Wait()
+
+	t.Logf("%d allocations succeeded", atomic.LoadInt64(&allocations))
+	if atomic.LoadInt64(&allocations) == 0 {
+		t.Error("no allocation succeeded")
+	}
+}
@@ -0,0 +1,73 @@
+package nvidia
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// cudaLimiterContainerDir is where the CUDA limiter directory is mounted.
+	cudaLimiterContainerDir = "/usr/local/vgpu"
+	// cudaLimiterLibrary is the CUDA interception library preloaded into
+	// every process of the container, HAMi-core's libvgpu.so or compatible.
+	cudaLimiterLibrary = "libvgpu.so"
+
+	// envMemoryLimit sets the memory limit, in MiB suffixed by "m", of the
+	// visible GPU of the given index.
+	envMemoryLimit = "CUDA_DEVICE_MEMORY_LIMIT_%d"
+	// envComputeLimit sets the percentage of the SMs of the visible GPUs
+	// the container may use. The limiter delays kernel launches exceeding it.
+	envComputeLimit = "CUDA_DEVICE_SM_LIMIT"
+)
+
+// memoryBudget returns the memory share, in MiB, of vGPUs virtual GPUs of the
+// physical GPU.
+func (m *NvidiaDevicePlugin) memoryBudget(gpu GPU, vGPUs int) uint64 {
+	return gpu.Memory * uint64(vGPUs) / uint64(m.config.vGPUCount(gpu))
+}
+
+// computeShare returns the SM percentage of vGPUs virtual GPUs of the physical
+// GPU, at least 1.
+func (m *NvidiaDevicePlugin) computeShare(gpu GPU, vGPUs int) int {
+	share := 100 * vGPUs / m.config.vGPUCount(gpu)
+	if share < 1 {
+		return 1
+	}
+	return share
+}
+
+// limit preloads the CUDA limiter into the container of response, with a
+// memory limit on every visible GPU, the memory held by the devices ids the
+// container received on it, and, when enforced, a compute limit.
+func (m *NvidiaDevicePlugin) limit(response *pluginapi.ContainerAllocateResponse, visible []string, ids []string) {
+	perGPU := make(map[string]int, len(visible))
+	for _, id := range m.vGPUs(ids) {
+		perGPU[getPhysicalDeviceID(id)]++
+	}
+	memory := m.memoryBudgets(ids)
+
+	if m.config.ComputeEnforcement == ComputeEnforcementThrottle {
+		// The limiter applies a single SM limit to every GPU, the one of the
+		// GPU the container has the largest share of.
+		max := 0
+		for id, n := range perGPU {
+			if share := m.computeShare(m.gpus[id], n); share > max {
+				max = share
+			}
+		}
+		response.Envs[envComputeLimit] = strconv.Itoa(max)
+	}
+
+	response.Envs["LD_PRELOAD"] = path.Join(cudaLimiterContainerDir, cudaLimiterLibrary)
+	for i, id := range visible {
+		// Without its memory size the GPU is left unlimited.
+		gpu, ok := m.gpus[id]
+		if !ok || gpu.Memory == 0 || perGPU[id] == 0 {
+			continue
+		}
+		response.Envs[fmt.Sprintf(envMemoryLimit, i)] = fmt.Sprintf("%dm", memory[id])
+	}
+}
@@ -0,0 +1,72 @@
+package nvidia
+
+import (
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// DefaultMemoryResourceName is the resource of the GPU memory chunks.
+	DefaultMemoryResourceName = "hkube.io/gpu-memory"
+
+	memoryServerSock = "hkube-vgpu-memory.sock"
+)
+
+// getMemoryDevices returns the GPU memory chunks of every shared physical GPU
+// of known memory size.
+func getMemoryDevices(gpus []GPU, config Config) []*pluginapi.Device {
+	var devs []*pluginapi.Device
+	for _, d := range gpus {
+		if config.exclusive(d) {
+			continue
+		}
+		for j := uint64(0); j < d.Memory/config.MemoryChunk; j++ {
+			devs = append(devs, &pluginapi.Device{
+				ID:     getVGPUID(config.gpuID(d), uint(j)),
+				Health: pluginapi.Healthy,
+			})
+		}
+	}
+	return devs
+}
+
+// chunkVGPUs returns the virtual GPUs whose memory share overlaps the memory
+// chunk id, so that the chunks and the virtual GPUs of a physical GPU are
+// never handed out twice.
+func (c Config) chunkVGPUs(id string, gpus map[string]GPU) []string {
+	gpuID, k, err := deviceIndex(id)
+	gpu, ok := gpus[gpuID]
+	if err != nil || !ok || gpu.Memory == 0 {
+		return nil
+	}
+
+	n := uint64(c.vGPUCount(gpu))
+	first := uint64(k) * c.MemoryChunk * n / gpu.Memory
+	last := ((uint64(k)+1)*c.MemoryChunk*n - 1) / gpu.Memory
+	var vGPUs []string
+	for j := first; j <= last && j < n; j++ {
+		vGPUs = append(vGPUs, getVGPUID(gpuID, uint(j)))
+	}
+	return vGPUs
+}
+
+// memoryBudgets returns the memory, in MiB, the devices of the plugin hold on
+// every physical GPU: the memory of the chunks, or the memory share of the
+// virtual GPUs.
+func (m *NvidiaDevicePlugin) memoryBudgets(ids []string) map[string]uint64 {
+	budgets := make(map[string]uint64)
+	if m.resourceName == m.config.MemoryResourceName && m.config.MemoryChunk > 0 {
+		for _, id := range ids {
+			budgets[getPhysicalDeviceID(id)] += m.config.MemoryChunk
+		}
+		return budgets
+	}
+
+	perGPU := make(map[string]int)
+	for _, id := range m.vGPUs(ids) {
+		perGPU[getPhysicalDeviceID(id)]++
+	}
+	for id, n := range perGPU {
+		budgets[id] = m.memoryBudget(m.gpus[id], n)
+	}
+	return budgets
+}
@@ -0,0 +1,346 @@
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// migCapabilitiesDir describes the device nodes granting access to the
+	// GPU and compute instances of every GPU, e.g.
+	// gpu0/mig/gi1/ci0/access.
+	migCapabilitiesDir = "/proc/driver/nvidia/capabilities"
+	// migCapsDir holds the capability device nodes, e.g. nvidia-cap21.
+	migCapsDir = "/dev/nvidia-caps"
+)
+
+// MIGDevice is a MIG (Multi-Instance GPU) device, a compute instance of a GPU
+// instance of a physical GPU with MIG enabled.
+type MIGDevice struct {
+	// Parent is the UUID of the physical GPU.
+	Parent string
+	// Index is the index of the MIG device on its physical GPU.
+	Index int
+	// Profile is the profile of the GPU instance, e.g. 3g.20gb.
+	Profile         string
+	GPUInstance     int
+	ComputeInstance int
+	// CapPaths are the device nodes granting access to the GPU and compute
+	// instances, the container needs them along with those of the physical
+	// GPU.
+	CapPaths []string
+}
+
+// migListing is a MIG device listed by nvidia-smi -L.
+type migListing struct {
+	uuid    string
+	parent  string
+	index   int
+	profile string
+}
+
+var (
+	// gpuListingPattern matches the GPUs listed by nvidia-smi -L, e.g.
+	// "GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5b0a...)".
+	gpuListingPattern = regexp.MustCompile(`^GPU [0-9]+: .*\(UUID: (GPU-[^)]+)\)`)
+	// migListingPattern matches their MIG devices, e.g.
+	// "  MIG 3g.20gb     Device  0: (UUID: MIG-1f2e...)".
+	migListingPattern = regexp.MustCompile(`^\s+MIG (\S+)\s+Device\s+([0-9]+): \(UUID: (MIG-[^)]+)\)`)
+	// migMemoryPattern captures the memory, in GB, of a MIG profile.
+	migMemoryPattern = regexp.MustCompile(`\.([0-9]+)gb$`)
+)
+
+// parseMIGListing parses the output of nvidia-smi -L and returns the MIG
+// devices of every GPU, in order.
+func parseMIGListing(out []byte) []migListing {
+	var devices []migListing
+	var parent string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if m := gpuListingPattern.FindStringSubmatch(scanner.Text()); m != nil {
+			parent = m[1]
+			continue
+		}
+		m := migListingPattern.FindStringSubmatch(scanner.Text())
+		if m == nil || parent == "" {
+			continue
+		}
+		index, _ := strconv.Atoi(m[2])
+		devices = append(devices, migListing{uuid: m[3], parent: parent, index: index, profile: m[1]})
+	}
+	return devices
+}
+
+// migInstanceKey identifies a MIG device by the UUID of its physical GPU and
+// its index.
+type migInstanceKey struct {
+	parent string
+	index  int
+}
+
+// parseMIGInstances parses the output of nvidia-smi -q and returns the GPU
+// and compute instances of every MIG device, e.g. from the "MIG Device"
+// sections "Index : 0", "GPU Instance ID : 1" and "Compute Instance ID : 0".
+func parseMIGInstances(out []byte) map[migInstanceKey][2]int {
+	instances := make(map[migInstanceKey][2]int)
+	var parent string
+	index, gi := -1, -1
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		n, err := strconv.Atoi(value)
+		switch {
+		case (key == "GPU UUID" || key == "UUID") && strings.HasPrefix(value, "GPU-"):
+			parent, index, gi = value, -1, -1
+		case key == "Index" && err == nil:
+			index, gi = n, -1
+		case key == "GPU Instance ID" && err == nil && index >= 0:
+			gi = n
+		case key == "Compute Instance ID" && err == nil && index >= 0 && gi >= 0:
+			instances[migInstanceKey{parent, index}] = [2]int{gi, n}
+			index, gi = -1, -1
+		}
+	}
+	return instances
+}
+
+// readCapMinor returns the minor number of the capability device node
+// described by the access file at path, e.g. "DeviceFileMinor: 21".
+func readCapMinor(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "DeviceFileMinor" {
+			return strconv.Atoi(strings.TrimSpace(fields[1]))
+		}
+	}
+	return 0, fmt.Errorf("no device minor in %s", path)
+}
+
+// migBackend shares the MIG devices of the GPUs with MIG enabled, found with
+// nvidia-smi since the NVML bindings do not expose MIG, instead of the GPUs.
+// The GPUs without MIG are shared as they are. The health events, usage,
+// processes and compute mode of a MIG device are those of its physical GPU.
+type migBackend struct {
+	DeviceBackend
+
+	// smi runs nvidia-smi with the given arguments.
+	smi func(args ...string) ([]byte, error)
+	// capabilitiesDir is migCapabilitiesDir.
+	capabilitiesDir string
+
+	mu sync.Mutex
+	// parents are the UUIDs of the physical GPUs by MIG device UUID.
+	parents map[string]string
+}
+
+// NewMIGBackend returns the DeviceBackend sharing the MIG devices of the GPUs
+// discovered by backend.
+func NewMIGBackend(backend DeviceBackend) DeviceBackend {
+	return &migBackend{
+		DeviceBackend:   backend,
+		smi:             runNvidiaSMI,
+		capabilitiesDir: migCapabilitiesDir,
+	}
+}
+
+// runNvidiaSMI runs nvidia-smi with the given arguments.
+func runNvidiaSMI(args ...string) ([]byte, error) {
+	out, err := exec.Command("nvidia-smi", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+func (b *migBackend) Discover() ([]GPU, error) {
+	gpus, err := b.DeviceBackend.Discover()
+	if err != nil {
+		return nil, err
+	}
+	listing, err := b.smi("-L")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the MIG devices: %v", err)
+	}
+	devices := parseMIGListing(listing)
+	if len(devices) == 0 {
+		log.Println("No MIG device found, the GPUs are shared whole.")
+		return gpus, nil
+	}
+	query, err := b.smi("-q")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the MIG devices: %v", err)
+	}
+	instances := parseMIGInstances(query)
+
+	byParent := make(map[string][]migListing)
+	for _, d := range devices {
+		byParent[d.parent] = append(byParent[d.parent], d)
+	}
+	parents := make(map[string]string, len(devices))
+	var shared []GPU
+	for _, gpu := range gpus {
+		if len(byParent[gpu.UUID]) == 0 {
+			shared = append(shared, gpu)
+			continue
+		}
+		for _, d := range byParent[gpu.UUID] {
+			mig, err := b.migDevice(gpu, d, instances)
+			if err != nil {
+				return nil, err
+			}
+			shared = append(shared, mig)
+			parents[mig.UUID] = gpu.UUID
+			log.Printf("GPU %d shares its MIG device %d (%s, %s) instead of the whole GPU.", gpu.Index, d.index, d.profile, d.uuid)
+		}
+	}
+
+	b.mu.Lock()
+	b.parents = parents
+	b.mu.Unlock()
+	return shared, nil
+}
+
+// migDevice returns the MIG device d of gpu, with the device nodes of its GPU
+// and compute instances.
+func (b *migBackend) migDevice(gpu GPU, d migListing, instances map[migInstanceKey][2]int) (GPU, error) {
+	instance, ok := instances[migInstanceKey{gpu.UUID, d.index}]
+	if !ok {
+		return GPU{}, fmt.Errorf("no GPU and compute instance reported for MIG device %d of GPU %d", d.index, gpu.Index)
+	}
+	mig := &MIGDevice{
+		Parent:          gpu.UUID,
+		Index:           d.index,
+		Profile:         d.profile,
+		GPUInstance:     instance[0],
+		ComputeInstance: instance[1],
+	}
+
+	// The capabilities are numbered by the minor of the GPU device node.
+	minor := strings.TrimPrefix(filepath.Base(gpu.Path), "nvidia")
+	dir := filepath.Join(b.capabilitiesDir, "gpu"+minor, "mig")
+	gi := filepath.Join(dir, fmt.Sprintf("gi%d", mig.GPUInstance))
+	for _, access := range []string{
+		filepath.Join(gi, "access"),
+		filepath.Join(gi, fmt.Sprintf("ci%d", mig.ComputeInstance), "access"),
+	} {
+		minor, err := readCapMinor(access)
+		if err != nil {
+			return GPU{}, fmt.Errorf("failed to find the device node of MIG device %d of GPU %d: %v", d.index, gpu.Index, err)
+		}
+		mig.CapPaths = append(mig.CapPaths, filepath.Join(migCapsDir, fmt.Sprintf("nvidia-cap%d", minor)))
+	}
+
+	device := gpu
+	device.UUID = d.uuid
+	device.MIG = mig
+	device.Memory = 0
+	if m := migMemoryPattern.FindStringSubmatch(d.profile); m != nil {
+		gb, _ := strconv.ParseUint(m[1], 10, 64)
+		device.Memory = gb * 1024
+	}
+	return device, nil
+}
+
+// parent returns the UUID of the physical GPU of the MIG device with the
+// given UUID, the UUID itself for the other GPUs.
+func (b *migBackend) parent(uuid string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.parents[uuid]; ok {
+		return p
+	}
+	return uuid
+}
+
+// GetHealthEvents watches the physical GPUs of the MIG devices, and reports
+// the events of a physical GPU for each of its watched MIG devices.
+func (b *migBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	devices := make(map[string][]string)
+	var parents []string
+	for _, uuid := range uuids {
+		p := b.parent(uuid)
+		if len(devices[p]) == 0 {
+			parents = append(parents, p)
+		}
+		devices[p] = append(devices[p], uuid)
+	}
+
+	physical := make(chan HealthEvent)
+	errs := make(chan error, 1)
+	go func() { errs <- b.DeviceBackend.GetHealthEvents(ctx, parents, physical) }()
+	for {
+		var e HealthEvent
+		select {
+		case err := <-errs:
+			return err
+		case e = <-physical:
+		}
+
+		targets := devices[e.UUID]
+		if e.UUID == "" {
+			targets = []string{""}
+		}
+		for _, uuid := range targets {
+			event := e
+			event.UUID = uuid
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (b *migBackend) GetUtilization() (map[string]GPUUsage, error) {
+	usage, err := b.DeviceBackend.GetUtilization()
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for uuid, p := range b.parents {
+		if u, ok := usage[p]; ok {
+			usage[uuid] = u
+		}
+	}
+	return usage, nil
+}
+
+func (b *migBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	processes, err := b.DeviceBackend.GetProcesses()
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for uuid, p := range b.parents {
+		if procs, ok := processes[p]; ok {
+			processes[uuid] = procs
+		}
+	}
+	return processes, nil
+}
+
+func (b *migBackend) SetComputeMode(uuid, mode string) error {
+	return b.DeviceBackend.SetComputeMode(b.parent(uuid), mode)
+}
@@ -0,0 +1,168 @@
+package nvidia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// migListingOutput is what nvidia-smi -L lists for a MockBackend of two GPUs
+// whose second one is split into two 3g.20gb MIG devices.
+const migListingOutput = `GPU 0: Mock GPU (UUID: GPU-00000000-0000-0000-0000-000000000000)
+GPU 1: Mock GPU (UUID: GPU-00000000-0000-0000-0000-000000000001)
+  MIG 3g.20gb     Device  0: (UUID: MIG-11111111-1111-1111-1111-111111111110)
+  MIG 3g.20gb     Device  1: (UUID: MIG-11111111-1111-1111-1111-111111111111)
+`
+
+// migQueryOutput is what nvidia-smi -q reports for them.
+const migQueryOutput = `GPU 00000000:07:00.0
+    GPU UUID                              : GPU-00000000-0000-0000-0000-000000000001
+    MIG Mode
+        Current                           : Enabled
+    MIG devices
+        MIG Device
+            Index                         : 0
+            GPU Instance ID               : 1
+            Compute Instance ID           : 0
+        MIG Device
+            Index                         : 1
+            GPU Instance ID               : 2
+            Compute Instance ID           : 0
+    Processes
+        GPU instance ID                   : 1
+        Compute instance ID               : 0
+`
+
+// newTestMIGBackend returns a MIG backend on a MockBackend of two GPUs, the
+// second one with MIG enabled, and a cleanup function.
+func newTestMIGBackend(t *testing.T) (*migBackend, *MockBackend, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vgpu-mig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, minor := range map[string]int{
+		"gpu1/mig/gi1/access":     12,
+		"gpu1/mig/gi1/ci0/access": 13,
+		"gpu1/mig/gi2/access":     21,
+		"gpu1/mig/gi2/ci0/access": 22,
+	} {
+		path = filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("DeviceFileMinor: %d\nDeviceFileMode: 292\n", minor)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock := NewMockBackend(2)
+	b := NewMIGBackend(mock).(*migBackend)
+	b.capabilitiesDir = dir
+	b.smi = func(args ...string) ([]byte, error) {
+		if args[0] == "-L" {
+			return []byte(migListingOutput), nil
+		}
+		return []byte(migQueryOutput), nil
+	}
+	return b, mock, func() {
+		mock.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMIGBackendSharesTheMIGDevices(t *testing.T) {
+	b, mock, cleanup := newTestMIGBackend(t)
+	defer cleanup()
+
+	gpus, err := b.Discover()
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if len(gpus) != 3 || gpus[0].UUID != mock.GPUs[0].UUID || gpus[0].MIG != nil {
+		t.Fatalf("got GPUs %v, want GPU 0 and the two MIG devices of GPU 1", gpus)
+	}
+	want := &MIGDevice{
+		Parent:          mock.GPUs[1].UUID,
+		Index:           1,
+		Profile:         "3g.20gb",
+		GPUInstance:     2,
+		ComputeInstance: 0,
+		CapPaths:        []string{"/dev/nvidia-caps/nvidia-cap21", "/dev/nvidia-caps/nvidia-cap22"},
+	}
+	mig := gpus[2]
+	if mig.UUID != "MIG-11111111-1111-1111-1111-111111111111" || mig.Index != 1 || mig.Path != "/dev/nvidia1" || mig.Memory != 20480 || !reflect.DeepEqual(mig.MIG, want) {
+		t.Errorf("got MIG device %+v of %+v, want %+v", mig, mig.MIG, want)
+	}
+
+	config := testConfig()
+	if id := config.gpuID(mig); id != "1mig1" {
+		t.Errorf("got ID %s, want 1mig1", id)
+	}
+
+	edits := b.ContainerEdits([]string{"0", "1mig1"}, []GPU{gpus[0], mig})
+	if got := edits.Envs["NVIDIA_VISIBLE_DEVICES"]; got != "0,"+mig.UUID {
+		t.Errorf("NVIDIA_VISIBLE_DEVICES=%q, want GPU 0 and the MIG device by UUID", got)
+	}
+	nodes := []string{"/dev/nvidia0", "/dev/nvidia1", "/dev/nvidia-caps/nvidia-cap21", "/dev/nvidia-caps/nvidia-cap22", nvidiaControlDevice, nvidiaUVMDevice}
+	if !reflect.DeepEqual(edits.DeviceNodes, nodes) {
+		t.Errorf("got device nodes %v, want %v", edits.DeviceNodes, nodes)
+	}
+}
+
+func TestMIGBackendWithoutMIGDevices(t *testing.T) {
+	b, mock, cleanup := newTestMIGBackend(t)
+	defer cleanup()
+	b.smi = func(args ...string) ([]byte, error) {
+		return []byte("GPU 0: Mock GPU (UUID: GPU-00000000-0000-0000-0000-000000000000)\n"), nil
+	}
+
+	gpus, err := b.Discover()
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if !reflect.DeepEqual(gpus, mock.GPUs) {
+		t.Errorf("got GPUs %v, want the GPUs of the backend", gpus)
+	}
+}
+
+func TestMIGBackendReportsTheEventsOfTheGPUForItsMIGDevices(t *testing.T) {
+	b, mock, cleanup := newTestMIGBackend(t)
+	defer cleanup()
+	gpus, err := b.Discover()
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan HealthEvent)
+	go b.GetHealthEvents(ctx, []string{gpus[0].UUID, gpus[1].UUID, gpus[2].UUID}, events)
+
+	mock.Events <- HealthEvent{UUID: mock.GPUs[1].UUID, Xid: 79}
+	got := make(map[string]uint64)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			got[e.UUID] = e.Xid
+		case <-time.After(time.Second):
+			t.Fatal("the event of the GPU was not reported for its MIG devices")
+		}
+	}
+	if want := map[string]uint64{gpus[1].UUID: 79, gpus[2].UUID: 79}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	mock.SetUsage(mock.GPUs[1].UUID, GPUUsage{Utilization: 42})
+	usage, err := b.GetUtilization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage[gpus[2].UUID].Utilization != 42 {
+		t.Errorf("got usage %v of the MIG device, want that of its GPU", usage[gpus[2].UUID])
+	}
+}
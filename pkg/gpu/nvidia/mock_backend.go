@@ -0,0 +1,160 @@
+package nvidia
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// MockBackend is a DeviceBackend serving preset GPUs, usage and driver
+// versions, and forwarding the health events sent on Events. It lets the
+// discovery, health and allocation logic run without a GPU node, containers
+// are given the devices of NVIDIA GPUs.
+type MockBackend struct {
+	sync.Mutex
+	GPUs   []GPU
+	Usage  map[string]GPUUsage
+	Driver DriverInfo
+	// Processes are the processes using every GPU by UUID.
+	Processes map[string][]GPUProcess
+	// ComputeModes are the compute modes set on every GPU by UUID.
+	ComputeModes map[string]string
+	// Err, when set, is returned by every method.
+	Err error
+
+	Events chan HealthEvent
+}
+
+// NewMockBackend returns a MockBackend with count idle GPUs.
+func NewMockBackend(count int) *MockBackend {
+	b := &MockBackend{
+		Usage:        make(map[string]GPUUsage),
+		Driver:       DriverInfo{Version: "0.0.0"},
+		Processes:    make(map[string][]GPUProcess),
+		ComputeModes: make(map[string]string),
+		Events:       make(chan HealthEvent),
+	}
+	for i := 0; i < count; i++ {
+		gpu := GPU{
+			Index:  i,
+			UUID:   fmt.Sprintf("GPU-00000000-0000-0000-0000-%012d", i),
+			Path:   fmt.Sprintf("/dev/nvidia%d", i),
+			Model:  "Mock GPU",
+			Memory: 16384,
+		}
+		b.GPUs = append(b.GPUs, gpu)
+		b.Usage[gpu.UUID] = GPUUsage{MemoryFree: gpu.Memory}
+	}
+	return b
+}
+
+// SetUsage sets the usage of the GPU with the given UUID.
+func (b *MockBackend) SetUsage(uuid string, usage GPUUsage) {
+	b.Lock()
+	defer b.Unlock()
+	b.Usage[uuid] = usage
+}
+
+// SetProcesses sets the processes using the GPU with the given UUID.
+func (b *MockBackend) SetProcesses(uuid string, processes []GPUProcess) {
+	b.Lock()
+	defer b.Unlock()
+	b.Processes[uuid] = processes
+}
+
+// SetErr makes every method fail with err, or succeed again when nil.
+func (b *MockBackend) SetErr(err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.Err = err
+}
+
+func (b *MockBackend) err() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.Err
+}
+
+func (b *MockBackend) Init() error {
+	return b.err()
+}
+
+func (b *MockBackend) Shutdown() error {
+	return b.err()
+}
+
+func (b *MockBackend) Discover() ([]GPU, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.Err != nil {
+		return nil, b.Err
+	}
+	return append([]GPU(nil), b.GPUs...), nil
+}
+
+func (b *MockBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	if err := b.err(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-b.Events:
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (b *MockBackend) GetUtilization() (map[string]GPUUsage, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.Err != nil {
+		return nil, b.Err
+	}
+
+	usage := make(map[string]GPUUsage, len(b.Usage))
+	for uuid, u := range b.Usage {
+		usage[uuid] = u
+	}
+	return usage, nil
+}
+
+func (b *MockBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.Err != nil {
+		return nil, b.Err
+	}
+
+	processes := make(map[string][]GPUProcess, len(b.Processes))
+	for uuid, p := range b.Processes {
+		processes[uuid] = append([]GPUProcess(nil), p...)
+	}
+	return processes, nil
+}
+
+func (b *MockBackend) GetDriverInfo() (DriverInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Driver, b.Err
+}
+
+func (b *MockBackend) SetComputeMode(uuid, mode string) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.Err != nil {
+		return b.Err
+	}
+	b.ComputeModes[uuid] = mode
+	return nil
+}
+
+func (b *MockBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	return nvidiaContainerEdits(ids, gpus)
+}
@@ -0,0 +1,76 @@
+package nvidia
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	modelResourceName = "hkube.io/%s-vgpu"
+	modelServerSock   = "hkube-vgpu-model-%s.sock"
+)
+
+// modelBrands are the words of the GPU names which do not tell models apart.
+var modelBrands = map[string]bool{"nvidia": true, "tesla": true, "geforce": true, "quadro": true, "grid": true}
+
+// modelSlug returns the short name of the GPU model used in resource names,
+// e.g. "t4" for "Tesla T4", "a100" for "NVIDIA A100-SXM4-40GB" and "rtx-a6000"
+// for "NVIDIA RTX A6000". It is empty when the model is unknown.
+func modelSlug(model string) string {
+	var words []string
+	for _, w := range strings.Fields(strings.ToLower(model)) {
+		if !modelBrands[w] {
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+
+	// The form factor and memory size follow the model, e.g. A100-SXM4-40GB.
+	slug := strings.SplitN(words[0], "-", 2)[0]
+	if len(words) > 1 && strings.IndexFunc(slug, unicode.IsDigit) < 0 {
+		// A family, e.g. RTX, needs the model that follows.
+		slug += "-" + strings.SplitN(words[1], "-", 2)[0]
+	}
+	return sanitizeLabelValue(slug)
+}
+
+// gpusByModel returns the physical GPUs of every model by slug, in the order
+// the models are first found.
+func gpusByModel(gpus []GPU) ([]string, map[string][]GPU) {
+	var models []string
+	byModel := make(map[string][]GPU)
+	for _, gpu := range gpus {
+		slug := modelSlug(gpu.Model)
+		if slug == "" {
+			continue
+		}
+		if _, ok := byModel[slug]; !ok {
+			models = append(models, slug)
+		}
+		byModel[slug] = append(byModel[slug], gpu)
+	}
+	return models, byModel
+}
+
+// computeCapabilities are the CUDA compute capabilities of the GPU models by
+// slug, as SM versions, e.g. 80 for sm_80. The NVML bindings do not report
+// them.
+var computeCapabilities = map[string]int{
+	"k80": 37, "m4": 52, "m40": 52, "m60": 52,
+	"p4": 61, "p40": 61, "p100": 60,
+	"v100": 70, "v100s": 70,
+	"t4": 75, "rtx-6000": 75, "rtx-8000": 75, "rtx-2080": 75,
+	"a100": 80, "a30": 80,
+	"a2": 86, "a10": 86, "a10g": 86, "a16": 86, "a40": 86,
+	"rtx-a4000": 86, "rtx-a5000": 86, "rtx-a6000": 86, "rtx-3080": 86, "rtx-3090": 86,
+	"l4": 89, "l40": 89, "l40s": 89, "rtx-4090": 89,
+	"h100": 90, "h200": 90,
+}
+
+// computeCapability returns the CUDA compute capability of the GPU model as
+// an SM version, zero when the model is unknown.
+func computeCapability(model string) int {
+	return computeCapabilities[modelSlug(model)]
+}
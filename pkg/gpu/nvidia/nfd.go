@@ -0,0 +1,84 @@
+package nvidia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// nfdFeatureFile is the name of the feature file of the plugin in the
+	// features.d directory of node feature discovery.
+	nfdFeatureFile = "hkube-vgpu"
+	// nfdFeaturePrefix prefixes the features of the plugin, labeled
+	// feature.node.kubernetes.io/<feature> by node feature discovery.
+	nfdFeaturePrefix = "hkube-vgpu."
+)
+
+// nfdFeatures returns the content of the feature file describing the GPUs
+// and the virtual GPU configuration of the node, one feature per line, named
+// after the node labels without their domain.
+func nfdFeatures(labels map[string]string, config Config) string {
+	features := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		features[k[strings.Index(k, "/")+1:]] = v
+	}
+	if config.MemoryChunk > 0 {
+		features["vgpu.memory-chunk"] = fmt.Sprintf("%d", config.MemoryChunk)
+	}
+	if config.GuaranteedPercent > 0 {
+		features["vgpu.guaranteed-percent"] = fmt.Sprintf("%d", config.GuaranteedPercent)
+	}
+
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s%s=%s\n", nfdFeaturePrefix, name, features[name])
+	}
+	return b.String()
+}
+
+// writeNFDFeatures keeps the feature file of the plugin in the features.d
+// directory of node feature discovery up to date until stop is closed. The
+// file is replaced through a hidden temporary file, which node feature
+// discovery skips.
+func (vgm *vGPUManager) writeNFDFeatures(stop <-chan struct{}) {
+	ticker := time.NewTicker(labelInterval)
+	defer ticker.Stop()
+
+	path := filepath.Join(vgm.config.NFDFeaturesDir, nfdFeatureFile)
+	tmp := filepath.Join(vgm.config.NFDFeaturesDir, "."+nfdFeatureFile+".tmp")
+	var written string
+	for {
+		if driverInfo, err := vgm.backend.GetDriverInfo(); err != nil {
+			log.Printf("Failed to get driver info for the node features: %v", err)
+		} else if features := nfdFeatures(getNodeLabels(vgm.gpus, driverInfo, vgm.config, vgm.healthyVGPUs()), vgm.config); features != written {
+			err := ioutil.WriteFile(tmp, []byte(features), 0644)
+			if err == nil {
+				err = os.Rename(tmp, path)
+			}
+			if err != nil {
+				os.Remove(tmp)
+				log.Printf("Failed to write node features to %s: %v", path, err)
+			} else {
+				written = features
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,35 +3,25 @@ package nvidia
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 
-	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
-
 	"golang.org/x/net/context"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
-func check(err error) {
-	if err != nil {
-		log.Panicln("Fatal:", err)
-	}
-}
-
 // Instead of returning physical GPU devices, device plugin returns vGPU devices here.
 // Total number of vGPU depends on the vGPU count user specify.
-func getVGPUDevices(vGPUCount int) []*pluginapi.Device {
-	n, err := nvml.GetDeviceCount()
-	check(err)
-
+func getVGPUDevices(gpus []GPU, config Config) []*pluginapi.Device {
 	var devs []*pluginapi.Device
-	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDevice(i)
-		check(err)
-
-		log.Printf("Device Memory: %d, vGPU Count: %d", uint(*d.Memory), vGPUCount)
+	for _, d := range gpus {
+		if config.exclusive(d) {
+			continue
+		}
+		log.Printf("Device Memory: %d, vGPU Count: %d", d.Memory, config.vGPUCount(d))
 
-		for j := uint(0); j < uint(vGPUCount); j++ {
-			vGPUDeviceID := getVGPUID(d.UUID, j)
+		for j := uint(0); j < uint(config.vGPUCount(d)); j++ {
+			vGPUDeviceID := getVGPUID(config.gpuID(d), j)
 			dev := pluginapi.Device{
 				ID:     vGPUDeviceID,
 				Health: pluginapi.Healthy,
@@ -55,23 +45,15 @@ func getVGPUDevices(vGPUCount int) []*pluginapi.Device {
 	return devs
 }
 
-func getDeviceCount() uint {
-	n, err := nvml.GetDeviceCount()
-	check(err)
-	return n
-}
-
-func getPhysicalGPUDevices() []string {
-	n, err := nvml.GetDeviceCount()
-	check(err)
-
-	var devs []string
-	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDevice(i)
-		check(err)
-		devs = append(devs, d.UUID)
+// getExclusiveDevices returns a single device for every physical GPU handed
+// out whole.
+func getExclusiveDevices(gpus []GPU, config Config) []*pluginapi.Device {
+	var devs []*pluginapi.Device
+	for _, d := range gpus {
+		if config.exclusive(d) {
+			devs = append(devs, &pluginapi.Device{ID: getVGPUID(config.gpuID(d), 0), Health: pluginapi.Healthy})
+		}
 	}
-
 	return devs
 }
 
@@ -81,16 +63,32 @@ func getVGPUID(deviceID string, vGPUIndex uint) string {
 
 func getPhysicalDeviceID(vGPUDeviceID string) string {
 	lastDashIndex := strings.LastIndex(vGPUDeviceID, "-")
+	if lastDashIndex < 0 {
+		return vGPUDeviceID
+	}
 	return vGPUDeviceID[0:lastDashIndex]
 }
 
-func deviceExists(devs []*pluginapi.Device, id string) bool {
-	for _, d := range devs {
-		if d.ID == id {
-			return true
-		}
+// maxDeviceIDLength bounds the virtual GPU IDs accepted from kubelet. NVML
+// UUIDs are at most 96 bytes long.
+const maxDeviceIDLength = 128
+
+// vGPUIDPattern matches the virtual GPU IDs built by getVGPUID from an NVML
+// UUID, e.g. GPU-5b0a1f3c-8e2d-4c7a-9b1e-2f6d3a4c5e7f-3, or from a GPU index,
+// e.g. 0-3.
+var vGPUIDPattern = regexp.MustCompile(`^[A-Za-z0-9]+(-[A-Za-z0-9]+)*-[0-9]+$`)
+
+// validateDeviceID rejects malformed virtual GPU IDs before they are used in
+// container environment variables, where separators such as "," or newlines
+// would inject additional devices or variables.
+func validateDeviceID(id string) error {
+	if len(id) == 0 || len(id) > maxDeviceIDLength {
+		return fmt.Errorf("device ID length %d out of bounds", len(id))
 	}
-	return false
+	if !vGPUIDPattern.MatchString(id) {
+		return fmt.Errorf("malformed device ID %q", id)
+	}
+	return nil
 }
 
 func physicialDeviceExists(devs []string, id string) bool {
@@ -102,65 +100,66 @@ func physicialDeviceExists(devs []string, id string) bool {
 	return false
 }
 
-func watchXIDs(ctx context.Context, devs []*pluginapi.Device, xids chan<- *pluginapi.Device) {
-	eventSet := nvml.NewEventSet()
-	defer nvml.DeleteEventSet(eventSet)
+// watchHealth reports the virtual GPUs of the physical GPUs raising critical
+// Xid or double bit ECC errors as unhealthy until ctx is done, and records
+// the errors in healthLog. gpuIDs maps the UUIDs of the physical GPUs to the
+// IDs the virtual GPU IDs derive from.
+func watchHealth(ctx context.Context, backend DeviceBackend, gpuIDs map[string]string, devs []*pluginapi.Device, xids chan<- *pluginapi.Device, healthLog *gpuHealthLog) {
 	var physicalDeviceIDs []string
 
 	// We don't have to loop all virtual GPUs here. Only need to check physical CPUs.
-	for _, d := range devs {
-		physicalDeviceID := getPhysicalDeviceID(d.ID)
-		if physicialDeviceExists(physicalDeviceIDs, physicalDeviceID) {
-			continue
-		}
-		physicalDeviceIDs = append(physicalDeviceIDs, physicalDeviceID)
-
-		log.Printf("virtual id %s physical id %s", d.ID, physicalDeviceID)
-		err := nvml.RegisterEventForDevice(eventSet, nvml.XidCriticalError, physicalDeviceID)
-		if err != nil && strings.HasSuffix(err.Error(), "Not Supported") {
-			log.Printf("Warning: %s is too old to support healthchecking: %s. Marking it unhealthy.", physicalDeviceID, err)
-
-			xids <- d
-			continue
+	for uuid, id := range gpuIDs {
+		for _, d := range devs {
+			if getPhysicalDeviceID(d.ID) == id {
+				physicalDeviceIDs = append(physicalDeviceIDs, uuid)
+				log.Printf("virtual id %s physical id %s", d.ID, uuid)
+				break
+			}
 		}
+	}
 
-		if err != nil {
-			log.Panicln("Fatal:", err)
+	events := make(chan HealthEvent)
+	go func() {
+		if err := backend.GetHealthEvents(ctx, physicalDeviceIDs, events); err != nil {
+			log.Printf("Failed to watch the health of the GPUs: %v", err)
 		}
-	}
+	}()
 
 	for {
+		var e HealthEvent
 		select {
 		case <-ctx.Done():
 			return
-		default:
-		}
-
-		e, err := nvml.WaitForEvent(eventSet, 5000)
-		if err != nil && e.Etype != nvml.XidCriticalError {
-			continue
+		case e = <-events:
 		}
 
 		// FIXME: formalize the full list and document it.
 		// http://docs.nvidia.com/deploy/xid-errors/index.html#topic_4
 		// Application errors: the GPU should still be healthy
-		if e.Edata == 31 || e.Edata == 43 || e.Edata == 45 {
+		if !e.ECC && (e.Xid == 31 || e.Xid == 43 || e.Xid == 45) {
 			continue
 		}
 
-		if e.UUID == nil || len(*e.UUID) == 0 {
-			// All devices are unhealthy
-			for _, d := range devs {
-				log.Printf("XidCriticalError: Xid=%d, All devices will go unhealthy.", e.Edata)
-				xids <- d
-			}
-			continue
+		reason := fmt.Sprintf("Xid %d", e.Xid)
+		switch {
+		case e.ECC:
+			reason = "double bit ECC error"
+		case e.Xid == 0:
+			reason = "health checks not supported"
 		}
+		healthLog.unhealthy(e.UUID, physicalDeviceIDs, reason)
 
 		for _, d := range devs {
-			if d.ID == *e.UUID {
-				log.Printf("XidCriticalError: Xid=%d on GPU=%s, the device will go unhealthy.", e.Edata, d.ID)
-				xids <- d
+			if e.UUID != "" && getPhysicalDeviceID(d.ID) != gpuIDs[e.UUID] {
+				continue
+			}
+			log.Printf("XidCriticalError: Xid=%d on GPU=%s, the device will go unhealthy.", e.Xid, d.ID)
+			// The sends give up once the health checks stop, so that the
+			// watcher does not leak.
+			select {
+			case xids <- d:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}
@@ -0,0 +1,38 @@
+package nvidia
+
+import (
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// testConfig returns the defaults of the command line, with 4 virtual GPUs
+// per GPU numbered sequentially.
+func testConfig() Config {
+	return Config{
+		DevicePluginPath:       pluginapi.DevicePluginPath,
+		VGPUCount:              4,
+		DriverRoot:             DefaultDriverRoot,
+		VulkanICDDir:           DefaultVulkanICDDir,
+		DevicePermissions:      DefaultDevicePermissions,
+		DeviceProfile:          DeviceProfileDefault,
+		ResponseTemplates:      DefaultResponseTemplates,
+		ComputeEnforcement:     ComputeEnforcementNone,
+		MemoryQuotaEnforcement: MemoryQuotaNone,
+		Persistence:            PersistenceNone,
+		SequentialDeviceIDs:    true,
+	}
+}
+
+// newTestManager returns the manager of the GPUs of backend, discovered.
+func newTestManager(t *testing.T, config Config, backend DeviceBackend) *vGPUManager {
+	t.Helper()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+	vgm := NewVirtualGPUManagerWithBackend(config, backend)
+	if err := vgm.discover(); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	return vgm
+}
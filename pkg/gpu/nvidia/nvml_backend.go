@@ -0,0 +1,260 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvml"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Device nodes every container of NVIDIA GPUs needs, along with those of
+	// its GPUs.
+	nvidiaControlDevice = "/dev/nvidiactl"
+	nvidiaUVMDevice     = "/dev/nvidia-uvm"
+
+	// nvidiaDriverContainerDir is where containers find the driver libraries.
+	nvidiaDriverContainerDir = "/usr/local/nvidia"
+)
+
+// nvmlBackend accesses the GPUs through NVML.
+type nvmlBackend struct{}
+
+// NewNVMLBackend returns the DeviceBackend using NVML.
+func NewNVMLBackend() DeviceBackend {
+	return nvmlBackend{}
+}
+
+func (nvmlBackend) Init() error {
+	return nvml.Init()
+}
+
+func (nvmlBackend) Shutdown() error {
+	return nvml.Shutdown()
+}
+
+func (nvmlBackend) Discover() ([]GPU, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []GPU
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDevice(i)
+		if err != nil {
+			return nil, err
+		}
+
+		gpu := GPU{Index: int(i), UUID: d.UUID, Path: d.Path}
+		if d.Model != nil {
+			gpu.Model = *d.Model
+			gpu.ComputeCapability = computeCapability(gpu.Model)
+		}
+		if d.Memory != nil {
+			gpu.Memory = *d.Memory
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+func (nvmlBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	eventSet := nvml.NewEventSet()
+	defer nvml.DeleteEventSet(eventSet)
+
+	send := func(e HealthEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for _, uuid := range uuids {
+		err := nvml.RegisterEventForDevice(eventSet, nvml.XidCriticalError, uuid)
+		if err != nil && strings.HasSuffix(err.Error(), "Not Supported") {
+			log.Printf("Warning: %s is too old to support healthchecking: %s. Marking it unhealthy.", uuid, err)
+			if !send(HealthEvent{UUID: uuid}) {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// GPUs without ECC memory do not support the event.
+		if err := nvml.RegisterEventForDevice(eventSet, nvml.DoubleBitEccError, uuid); err != nil {
+			log.Printf("Not watching the ECC errors of %s: %s", uuid, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		e, err := nvml.WaitForEvent(eventSet, 5000)
+		if err != nil && e.Etype != nvml.XidCriticalError && e.Etype != nvml.DoubleBitEccError {
+			continue
+		}
+
+		event := HealthEvent{Xid: e.Edata}
+		if e.Etype == nvml.DoubleBitEccError {
+			event = HealthEvent{ECC: true}
+		}
+		if e.UUID != nil {
+			event.UUID = *e.UUID
+		}
+		if !send(event) {
+			return nil
+		}
+	}
+}
+
+func (nvmlBackend) GetUtilization() (map[string]GPUUsage, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]GPUUsage)
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDevice(i)
+		if err != nil {
+			return nil, err
+		}
+		status, err := d.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		var u GPUUsage
+		if status.Utilization != nil {
+			u.Utilization = *status.Utilization
+		}
+		if status.MemoryFree != nil {
+			u.MemoryFree = *status.MemoryFree
+		}
+		usage[d.UUID] = u
+	}
+	return usage, nil
+}
+
+func (nvmlBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make(map[string][]GPUProcess)
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDevice(i)
+		if err != nil {
+			return nil, err
+		}
+		procs, err := d.Processes()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range procs {
+			processes[d.UUID] = append(processes[d.UUID], GPUProcess{PID: int(p.PID), MemoryUsed: p.MemoryUsed})
+		}
+	}
+	return processes, nil
+}
+
+func (nvmlBackend) GetDriverInfo() (DriverInfo, error) {
+	version, err := nvml.GetDriverVersion()
+	if err != nil {
+		return DriverInfo{}, err
+	}
+	major, minor, err := nvml.GetCudaDriverVersion()
+	if err != nil {
+		return DriverInfo{}, err
+	}
+	return DriverInfo{
+		Version:               version,
+		CUDAMajor:             major,
+		CUDAMinor:             minor,
+		OpenKernelModules:     openKernelModules(),
+		ConfidentialComputing: confidentialComputing(),
+	}, nil
+}
+
+// SetComputeMode runs nvidia-smi, the NVML bindings do not expose
+// nvmlDeviceSetComputeMode.
+func (nvmlBackend) SetComputeMode(uuid, mode string) error {
+	out, err := exec.Command("nvidia-smi", "--id="+uuid, "--compute-mode="+mode).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ContainerEdits also injects the IMEX channels of the open kernel modules, so
+// that containers can share GPU memory across nodes, and the NVSwitch devices
+// to containers spanning several GPUs of NVSwitch systems, for their NVLink
+// peer to peer traffic.
+func (nvmlBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	return fabricContainerEdits(ids, gpus)
+}
+
+// fabricContainerEdits returns the edits of the GPUs gpus with the given IDs
+// along with the NVSwitch devices and IMEX channels they need.
+func fabricContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	edits := nvidiaContainerEdits(ids, gpus)
+	if len(ids) > 1 {
+		edits.DeviceNodes = append(edits.DeviceNodes, nvswitchDevices()...)
+	}
+	if openKernelModules() {
+		edits.DeviceNodes = append(edits.DeviceNodes, imexChannels()...)
+	}
+	return edits
+}
+
+// nvidiaContainerEdits selects the GPUs gpus with the given IDs through the
+// NVIDIA container runtime, MIG devices by UUID, and injects their device
+// nodes along with the control and unified memory ones. The driver root of
+// the plugin is mounted at nvidiaDriverContainerDir.
+func nvidiaContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	visible := make([]string, len(ids))
+	for i, id := range ids {
+		visible[i] = id
+		if i < len(gpus) && gpus[i].MIG != nil {
+			visible[i] = gpus[i].UUID
+		}
+	}
+	edits := ContainerEdits{
+		Envs: map[string]string{
+			"NVIDIA_VISIBLE_DEVICES": strings.Join(visible, ","),
+		},
+		DriverContainerDir: nvidiaDriverContainerDir,
+	}
+	edits.DeviceNodes = append(gpuDeviceNodes(gpus), nvidiaControlDevice, nvidiaUVMDevice)
+	return edits
+}
+
+// gpuDeviceNodes returns the device nodes of the GPUs gpus, those of the
+// physical GPU of MIG devices once along with the capabilities of their GPU
+// and compute instances.
+func gpuDeviceNodes(gpus []GPU) []string {
+	var nodes []string
+	seen := make(map[string]bool, len(gpus))
+	for _, gpu := range gpus {
+		if gpu.Path != "" && !seen[gpu.Path] {
+			seen[gpu.Path] = true
+			nodes = append(nodes, gpu.Path)
+		}
+		if gpu.MIG != nil {
+			nodes = append(nodes, gpu.MIG.CapPaths...)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,65 @@
+package nvidia
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestContainerEditsInjectsTheDiscoveredGPUs(t *testing.T) {
+	backend := NewMockBackend(3)
+	// The minor numbers of the device nodes need not follow the indexes.
+	backend.GPUs[1].Path = "/dev/nvidia2"
+	backend.GPUs[2].Path = "/dev/nvidia1"
+	p := newTestManager(t, testConfig(), backend).newDevicePlugins()[0]
+	config := testConfig()
+	config.SequentialDeviceIDs = false
+	byUUID := newTestManager(t, config, backend).newDevicePlugins()[0]
+	// The GPUs discovered at startup are enough, the backend is not queried
+	// on allocations.
+	backend.SetErr(errors.New("GPUs queried"))
+
+	cases := []struct {
+		p    *NvidiaDevicePlugin
+		ids  []string
+		want []string
+	}{
+		{p, []string{"1"}, []string{"/dev/nvidia2", nvidiaControlDevice, nvidiaUVMDevice}},
+		{p, []string{"0", "2"}, []string{"/dev/nvidia0", "/dev/nvidia1", nvidiaControlDevice, nvidiaUVMDevice}},
+		{byUUID, []string{backend.GPUs[2].UUID}, []string{"/dev/nvidia1", nvidiaControlDevice, nvidiaUVMDevice}},
+	}
+	for _, c := range cases {
+		edits := c.p.containerEdits(c.ids)
+		if !reflect.DeepEqual(edits.DeviceNodes, c.want) {
+			t.Errorf("GPUs %v got device nodes %v, want %v", c.ids, edits.DeviceNodes, c.want)
+		}
+		if edits.DriverDir != "" || edits.DriverContainerDir != nvidiaDriverContainerDir {
+			t.Errorf("GPUs %v got driver %q at %q, want the driver root of the plugin at %q", c.ids, edits.DriverDir, edits.DriverContainerDir, nvidiaDriverContainerDir)
+		}
+	}
+}
+
+func TestDriverDirDefaultsToTheDriverRoot(t *testing.T) {
+	config := testConfig()
+	config.DriverRoot = "/run/nvidia/driver/usr/lib64"
+	config.DriverRoots = map[int]string{1: "/opt/nvidia/lib64"}
+	backend := NewMockBackend(2)
+	p := newTestManager(t, config, backend).newDevicePlugins()[0]
+
+	if dir, err := p.driverDir(p.containerEdits([]string{"0"}), []string{"0"}); err != nil || dir != config.DriverRoot {
+		t.Errorf("GPU 0 got driver %q (%v), want %q", dir, err, config.DriverRoot)
+	}
+	if dir, err := p.driverDir(p.containerEdits([]string{"1"}), []string{"1"}); err != nil || dir != "/opt/nvidia/lib64" {
+		t.Errorf("GPU 1 got driver %q (%v), want its own driver root", dir, err)
+	}
+	if _, err := p.driverDir(p.containerEdits([]string{"0", "1"}), []string{"0", "1"}); err == nil {
+		t.Error("GPUs of different drivers were allocated to a single container")
+	}
+
+	config.DriverRoot = ""
+	config.DriverRoots = nil
+	p = newTestManager(t, config, backend).newDevicePlugins()[0]
+	if dir, err := p.driverDir(p.containerEdits([]string{"0"}), []string{"0"}); err != nil || dir != "" {
+		t.Errorf("got driver %q (%v) without driver root, want none", dir, err)
+	}
+}
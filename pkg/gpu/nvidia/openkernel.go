@@ -0,0 +1,37 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// nvidiaVersionFile reports the version and the flavor of the loaded
+	// kernel modules.
+	nvidiaVersionFile = "/proc/driver/nvidia/version"
+	// imexChannelsDir holds the IMEX channel device nodes of the open kernel
+	// modules, which CUDA needs to share memory over NVLink across nodes.
+	imexChannelsDir = "/dev/nvidia-caps-imex-channels"
+)
+
+// openKernelModules reports whether the node runs the open kernel modules of
+// NVIDIA, e.g. "NVRM version: NVIDIA UNIX Open Kernel Module for x86_64
+// 535.104.05", rather than the proprietary ones.
+func openKernelModules() bool {
+	b, err := ioutil.ReadFile(nvidiaVersionFile)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(b), "Open Kernel Module")
+}
+
+// imexChannels returns the IMEX channel device nodes of the node, sorted by
+// path. They only exist with the open kernel modules, once created by
+// nvidia-modprobe or the IMEX daemon.
+func imexChannels() []string {
+	paths, _ := filepath.Glob(filepath.Join(imexChannelsDir, "channel*"))
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,94 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+const peerCredAuthType = "peercred"
+
+// peerAuthInfo holds the credentials of the process connected to the socket.
+type peerAuthInfo struct {
+	Ucred syscall.Ucred
+}
+
+func (peerAuthInfo) AuthType() string {
+	return peerCredAuthType
+}
+
+// peerCredentials authenticates the process connected to the plugin unix
+// socket through SO_PEERCRED and rejects the users that are not allowed. It
+// does not encrypt the connection, so clients keep dialing insecurely.
+type peerCredentials struct {
+	allowedUIDs map[uint32]bool
+}
+
+func newPeerCredentials(uids []uint32) credentials.TransportCredentials {
+	allowed := make(map[uint32]bool)
+	for _, uid := range uids {
+		allowed[uid] = true
+	}
+	return &peerCredentials{allowedUIDs: allowed}
+}
+
+// getPeerCred returns the credentials of the process at the other end of the
+// unix socket connection.
+func getPeerCred(conn net.Conn) (*syscall.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T connection", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+func (c *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cred, err := getPeerCred(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to get peer credentials: %v", err)
+	}
+	if !c.allowedUIDs[cred.Uid] {
+		conn.Close()
+		log.Printf("Rejected connection from pid %d uid %d gid %d", cred.Pid, cred.Uid, cred.Gid)
+		return nil, nil, fmt.Errorf("uid %d is not allowed to connect", cred.Uid)
+	}
+	return conn, peerAuthInfo{Ucred: *cred}, nil
+}
+
+func (c *peerCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, peerAuthInfo{}, nil
+}
+
+func (c *peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: peerCredAuthType}
+}
+
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	uids := make([]uint32, 0, len(c.allowedUIDs))
+	for uid := range c.allowedUIDs {
+		uids = append(uids, uid)
+	}
+	return newPeerCredentials(uids)
+}
+
+func (c *peerCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// access(2) modes
+const (
+	accessRead  = 0x4
+	accessWrite = 0x2
+	accessExec  = 0x1
+)
+
+// permissionCheck is a host path the plugin needs access to.
+type permissionCheck struct {
+	path   string
+	mode   uint32
+	reason string
+}
+
+// requiredPermissions returns the host paths the plugin needs to access with
+// the given configuration. The plugin does not need to run as root, only to
+// have these permissions.
+func requiredPermissions(config Config) []permissionCheck {
+	checks := []permissionCheck{
+		{config.DevicePluginPath, accessWrite | accessExec, "create the device plugin socket"},
+		{config.kubeletSocket(), accessWrite, "register with kubelet"},
+	}
+	if config.WSL {
+		checks = append(checks, permissionCheck{wslGPUDevice, accessRead | accessWrite, "query the GPUs through NVML"})
+	} else if config.FakeGPUs == 0 && !config.Tegra {
+		checks = append(checks, permissionCheck{"/dev/nvidiactl", accessRead | accessWrite, "query the GPUs through NVML"})
+	}
+	if config.AnnotatePods || config.AuditLog != "" || config.AllocationWebhook != "" || config.MemoryQuotaEnforcement != MemoryQuotaNone || config.ManageComputeMode ||
+		config.sharedAccounting() || config.reconcilesAllocations() {
+		checks = append(checks, permissionCheck{podResourcesSocket, accessWrite, "list the pod resources"})
+	}
+	if config.Persistence == PersistenceDaemon {
+		checks = append(checks, permissionCheck{filepath.Dir(persistencedDir), accessWrite | accessExec, "start nvidia-persistenced"})
+	}
+	if config.NFDFeaturesDir != "" {
+		checks = append(checks, permissionCheck{config.NFDFeaturesDir, accessWrite | accessExec, "write the node features"})
+	}
+	if config.CheckpointFile != "" {
+		checks = append(checks, permissionCheck{filepath.Dir(config.CheckpointFile), accessWrite | accessExec, "write the checkpoint"})
+	}
+	return checks
+}
+
+// checkPermissions reports the host paths the plugin can not access. Missing
+// paths are not reported, they are handled when the plugin uses them.
+func checkPermissions(config Config) []error {
+	var errs []error
+	for _, c := range requiredPermissions(config) {
+		err := syscall.Access(c.path, c.mode)
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("no %s access to %s, needed to %s: %v", accessModeString(c.mode), c.path, c.reason, err))
+	}
+	return errs
+}
+
+func accessModeString(mode uint32) string {
+	s := ""
+	for _, m := range []struct {
+		bit  uint32
+		name string
+	}{{accessRead, "r"}, {accessWrite, "w"}, {accessExec, "x"}} {
+		if mode&m.bit != 0 {
+			s += m.name
+		}
+	}
+	return s
+}
+
+// verifyPermissions logs every missing permission along with the identity
+// of the plugin and fails when any is missing.
+func verifyPermissions(config Config) error {
+	errs := checkPermissions(config)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	groups, _ := os.Getgroups()
+	log.Printf("Running as uid=%d gid=%d groups=%v", os.Getuid(), os.Getgid(), groups)
+	for _, err := range errs {
+		log.Printf("Missing permission: %v", err)
+	}
+	log.Printf("Grant the missing permissions to the plugin user or group, see https://github.com/awslabs/aws-virtual-gpu-device-plugin#running-as-non-root")
+	return fmt.Errorf("%d missing permissions", len(errs))
+}
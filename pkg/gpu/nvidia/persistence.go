@@ -0,0 +1,111 @@
+package nvidia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+)
+
+const (
+	// persistencedDir holds the socket and the PID file of
+	// nvidia-persistenced.
+	persistencedDir     = "/var/run/nvidia-persistenced"
+	persistencedPIDFile = persistencedDir + "/nvidia-persistenced.pid"
+
+	persistencedInterval = 30 * time.Second
+)
+
+var persistencedRunning = metrics.NewGaugeVec("vgpu_persistenced_running",
+	"Whether the nvidia-persistenced daemon supervised by the plugin is running.")
+
+// enablePersistenceMode enables the legacy persistence mode of every GPU, so
+// that the driver keeps them initialized between their users. It runs
+// nvidia-smi, the NVML bindings do not expose nvmlDeviceSetPersistenceMode.
+func (vgm *vGPUManager) enablePersistenceMode() {
+	for _, gpu := range vgm.gpus {
+		out, err := exec.Command("nvidia-smi", "--id="+gpu.UUID, "--persistence-mode=1").CombinedOutput()
+		if err != nil {
+			log.Printf("Failed to enable persistence mode of GPU %s: %v: %s", vgm.config.gpuID(gpu), err, strings.TrimSpace(string(out)))
+			continue
+		}
+		log.Printf("Persistence mode of GPU %s enabled.", vgm.config.gpuID(gpu))
+	}
+}
+
+// persistencedPID returns the PID of the running nvidia-persistenced, 0 when
+// it is not running.
+func persistencedPID() int {
+	b, err := ioutil.ReadFile(persistencedPIDFile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return 0
+	}
+	return pid
+}
+
+// startPersistenced starts nvidia-persistenced unless it is already running,
+// e.g. started by the host. The daemon detaches itself once the GPUs are
+// initialized.
+func startPersistenced() error {
+	if pid := persistencedPID(); pid != 0 {
+		return nil
+	}
+	out, err := exec.Command("nvidia-persistenced").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if persistencedPID() == 0 {
+		return fmt.Errorf("nvidia-persistenced exited, see the system log")
+	}
+	return nil
+}
+
+// supervisePersistenced starts nvidia-persistenced and starts it again
+// whenever it is no longer running, until stop is closed. It is left running
+// when the plugin stops, so that the GPUs stay initialized across restarts of
+// the plugin.
+func (vgm *vGPUManager) supervisePersistenced(stop <-chan struct{}) {
+	ticker := time.NewTicker(persistencedInterval)
+	defer ticker.Stop()
+
+	// Failures are only logged once until the daemon runs again, the
+	// attempts are repeated every persistencedInterval.
+	running, failed := false, false
+	for {
+		if err := startPersistenced(); err != nil {
+			if running {
+				log.Printf("Warning: nvidia-persistenced stopped and failed to start again: %v", err)
+			} else if !failed {
+				log.Printf("Failed to start nvidia-persistenced: %v", err)
+			}
+			running, failed = false, true
+		} else if !running {
+			log.Printf("nvidia-persistenced is running with PID %d.", persistencedPID())
+			running, failed = true, false
+		}
+		if running {
+			persistencedRunning.Set(1)
+		} else {
+			persistencedRunning.Set(0)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
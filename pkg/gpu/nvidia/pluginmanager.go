@@ -0,0 +1,125 @@
+package nvidia
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// pluginRetryInterval is how often the device plugins that failed to serve
+// are started again.
+const pluginRetryInterval = 30 * time.Second
+
+// pluginManager owns the device plugin servers, one for every advertised
+// resource, and restarts them individually: a server whose socket was
+// removed, whose resource drifted or which failed to register is restarted
+// alone, with the devices it advertised, while the other servers keep
+// serving. Only a restart of kubelet, which forgets every plugin, restarts
+// all of them.
+type pluginManager struct {
+	vgm     *vGPUManager
+	plugins []*NvidiaDevicePlugin
+	// failed are the sockets of the plugins that failed to serve, retried
+	// every pluginRetryInterval.
+	failed map[string]bool
+}
+
+func newPluginManager(vgm *vGPUManager) *pluginManager {
+	return &pluginManager{vgm: vgm, failed: make(map[string]bool)}
+}
+
+// serve serves p, recording whether it failed.
+func (pm *pluginManager) serve(p *NvidiaDevicePlugin) {
+	if err := p.Serve(); err != nil {
+		log.Printf("You can check the prerequisites at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#prerequisites")
+		log.Printf("You can learn how to set the runtime at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#quick-start")
+		pm.failed[p.socket] = true
+		return
+	}
+	delete(pm.failed, p.socket)
+}
+
+// update records the served plugins, registered once none failed.
+func (pm *pluginManager) update() {
+	pm.vgm.setDevicePlugins(pm.plugins)
+	pm.vgm.setRegistered(len(pm.failed) == 0)
+	if len(pm.failed) == 0 && pm.vgm.config.Handover {
+		pm.vgm.claim()
+	}
+}
+
+// restartAll stops every plugin and serves new ones for the devices of the
+// node.
+func (pm *pluginManager) restartAll() {
+	pm.stopAll()
+	pm.plugins = pm.vgm.newDevicePlugins()
+	pm.vgm.setDevicePlugins(pm.plugins)
+	if pm.vgm.budgets != nil && pm.vgm.kubeletAllocations != nil {
+		pm.vgm.recoverBudgets(pm.plugins)
+		pm.vgm.kubeletAllocations = nil
+	}
+	pm.failed = make(map[string]bool)
+	for _, p := range pm.plugins {
+		pm.serve(p)
+	}
+	pm.update()
+}
+
+// restart stops the plugins for which restart reports true and serves new
+// ones for their devices, keeping the health of the devices.
+func (pm *pluginManager) restart(restart func(p *NvidiaDevicePlugin) bool) {
+	restarted := false
+	for i, p := range pm.plugins {
+		if !restart(p) {
+			continue
+		}
+		log.Printf("Restarting the device plugin of %s.", p.resourceName)
+		p.Stop()
+		pm.plugins[i] = pm.vgm.restartedDevicePlugin(p)
+		pm.serve(pm.plugins[i])
+		restarted = true
+	}
+	if restarted {
+		pm.update()
+	}
+}
+
+// restartSocket restarts the plugin serving on the socket at path if the
+// socket is missing, e.g. removed by a cleanup of the device plugin
+// directory.
+func (pm *pluginManager) restartSocket(path string) {
+	if !isPluginSocket(pm.plugins, path) {
+		return
+	}
+	// Stopping the plugins removes their sockets as well, the removal
+	// matters only if the socket is still missing.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return
+	}
+	log.Printf("inotify: %s removed, restarting.", path)
+	pm.restart(func(p *NvidiaDevicePlugin) bool { return p.socket == path })
+}
+
+// restartResources restarts the plugins of the given resources.
+func (pm *pluginManager) restartResources(resources []string) {
+	names := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		names[r] = true
+	}
+	pm.restart(func(p *NvidiaDevicePlugin) bool { return names[p.resourceName] })
+}
+
+// retryFailed restarts the plugins that failed to serve.
+func (pm *pluginManager) retryFailed() {
+	if len(pm.failed) == 0 {
+		return
+	}
+	pm.restart(func(p *NvidiaDevicePlugin) bool { return pm.failed[p.socket] })
+}
+
+// stopAll stops every plugin.
+func (pm *pluginManager) stopAll() {
+	for _, p := range pm.plugins {
+		p.Stop()
+	}
+}
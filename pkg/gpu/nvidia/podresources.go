@@ -0,0 +1,33 @@
+package nvidia
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const (
+	podResourcesSocket  = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	podResourcesTimeout = 10 * time.Second
+)
+
+// listPodResources returns the devices assigned by kubelet to the containers
+// of the pods running on the node.
+func listPodResources() ([]*podresourcesapi.PodResources, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), podResourcesTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, podResourcesSocket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := podresourcesapi.NewPodResourcesListerClient(conn)
+	resp, err := client.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PodResources, nil
+}
@@ -0,0 +1,58 @@
+package nvidia
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// AllocationRequest is the allocation of virtual GPUs to a container, as
+// seen by the allocation policies.
+type AllocationRequest struct {
+	// Resource is the resource of the virtual GPUs, e.g. nvidia.com/gpu.
+	Resource string
+	// DeviceIDs are the IDs of the allocated virtual GPUs.
+	DeviceIDs []string
+	// GPUs are the physical GPUs backing them, sorted by index.
+	GPUs []GPU
+}
+
+// AllocationPolicy admits the allocations of virtual GPUs to containers, for
+// programs embedding the plugin. Policies run in Allocate after the request
+// was validated, within its deadline; an error fails the allocation, and
+// kubelet fails the container with it.
+type AllocationPolicy interface {
+	Admit(ctx context.Context, request AllocationRequest) error
+}
+
+// AddAllocationPolicy adds a policy admitting the allocations of every
+// resource. Policies must be added before Run.
+func (vgm *vGPUManager) AddAllocationPolicy(policy AllocationPolicy) {
+	vgm.policies = append(vgm.policies, policy)
+}
+
+// admit runs the allocation policies on the allocation of the virtual GPUs
+// with the given IDs to a container.
+func (m *NvidiaDevicePlugin) admit(ctx context.Context, ids []string) error {
+	if len(m.policies) == 0 {
+		return nil
+	}
+
+	request := AllocationRequest{Resource: m.resourceName, DeviceIDs: ids}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		gpu := getPhysicalDeviceID(id)
+		if !seen[gpu] {
+			seen[gpu] = true
+			request.GPUs = append(request.GPUs, m.gpus[gpu])
+		}
+	}
+	sort.Slice(request.GPUs, func(i, j int) bool { return request.GPUs[i].Index < request.GPUs[j].Index })
+
+	for _, policy := range m.policies {
+		if err := policy.Admit(ctx, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// checkGPUs verifies, before a container starts, that the physical GPUs
+// backing the devices ids are usable: known healthy, with their device node
+// present and answering a query of the driver. A GPU that fell off the bus or
+// lost its driver then fails the container with a clear error rather than
+// crashing the workload once it initializes CUDA.
+func (m *NvidiaDevicePlugin) checkGPUs(ctx context.Context, ids []string) error {
+	var gpus []GPU
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		gpuID := getPhysicalDeviceID(id)
+		gpu, ok := m.gpus[gpuID]
+		if !ok {
+			return fmt.Errorf("unknown device %s", id)
+		}
+		if !seen[gpuID] {
+			seen[gpuID] = true
+			gpus = append(gpus, gpu)
+		}
+	}
+	sort.Slice(gpus, func(i, j int) bool { return gpus[i].Index < gpus[j].Index })
+
+	for _, gpu := range gpus {
+		if m.healthLog != nil {
+			if s := m.healthLog.status(gpu.UUID); !s.Healthy {
+				return fmt.Errorf("GPU %d (%s) is unhealthy: %s", gpu.Index, gpu.UUID, s.LastError)
+			}
+		}
+		if m.config.FakeGPUs > 0 || gpu.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(gpu.Path); err != nil {
+			return fmt.Errorf("device node of GPU %d (%s) is missing: %v", gpu.Index, gpu.UUID, err)
+		}
+	}
+
+	// The driver may hang on a broken GPU, the query must not outlive the
+	// deadline of kubelet.
+	type result struct {
+		usage map[string]GPUUsage
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := m.backend.GetUtilization()
+		done <- result{usage, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("GPUs did not answer the driver in time: %v", ctx.Err())
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to query the GPUs through the driver: %v", r.err)
+	}
+	for _, gpu := range gpus {
+		if _, ok := r.usage[gpu.UUID]; !ok {
+			return fmt.Errorf("GPU %d (%s) does not answer the driver", gpu.Index, gpu.UUID)
+		}
+	}
+	return nil
+}
+
+// PreStartContainer checks the GPUs of the container when enabled, kubelet
+// then fails the container with the error.
+func (m *NvidiaDevicePlugin) PreStartContainer(ctx context.Context, req *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	if !m.config.PreStartCheck {
+		return &pluginapi.PreStartContainerResponse{}, nil
+	}
+	if err := m.checkGPUs(ctx, req.DevicesIDs); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "GPU check failed: %v", err)
+	}
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
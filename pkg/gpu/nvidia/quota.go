@@ -0,0 +1,310 @@
+package nvidia
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const (
+	memoryQuotaInterval = 10 * time.Second
+
+	// memoryQuotaComponent reports the memory quota events.
+	memoryQuotaComponent = "virtual-gpu-device-plugin"
+	memoryQuotaReason    = "GPUMemoryBudgetExceeded"
+	computeShareReason   = "GPUComputeShareExceeded"
+)
+
+var (
+	podGPUMemoryUsed = metrics.NewGaugeVec("vgpu_pod_gpu_memory_used_mib",
+		"GPU memory used by the processes of the pod on the physical GPU.", "namespace", "pod", "gpu")
+	podGPUMemoryBudget = metrics.NewGaugeVec("vgpu_pod_gpu_memory_budget_mib",
+		"GPU memory share of the virtual GPUs of the pod on the physical GPU.", "namespace", "pod", "gpu")
+	podGPUMemoryExceeded = metrics.NewCounterVec("vgpu_pod_gpu_memory_budget_exceeded_total",
+		"Times the pod was found using more GPU memory than its budget on the physical GPU.", "namespace", "pod", "gpu")
+	podGPUComputeExceeded = metrics.NewCounterVec("vgpu_pod_gpu_compute_share_exceeded_total",
+		"Times the pod was found alone on the physical GPU using more than its compute share.", "namespace", "pod", "gpu")
+)
+
+// formatMiB formats a GPU memory size in MiB for humans, e.g. 9GiB.
+func formatMiB(mib uint64) string {
+	if mib < 1024 {
+		return fmt.Sprintf("%dMiB", mib)
+	}
+	return fmt.Sprintf("%.3gGiB", float64(mib)/1024)
+}
+
+// podUIDPattern matches the pod UID in the cgroup paths of both the cgroupfs
+// and systemd drivers, e.g. kubepods/burstable/pod<uid>/<container> and
+// kubepods-burstable-pod<uid with underscores>.slice.
+var podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+// processPodUID returns the UID of the pod running the process, empty when
+// the process does not run in a pod.
+func processPodUID(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := podUIDPattern.FindStringSubmatch(scanner.Text()); m != nil {
+			return strings.Replace(m[1], "_", "-", -1), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// podGPUUsage is the GPU memory use of a pod on a physical GPU.
+type podGPUUsage struct {
+	pod  *v1.Pod
+	gpu  string
+	used uint64
+	pids []int
+}
+
+// memoryQuotaWatcher attributes the GPU memory used by every process to its
+// pod and enforces the memory share of the virtual GPUs of the pod.
+type memoryQuotaWatcher struct {
+	vgm    *vGPUManager
+	client kubernetes.Interface
+	// exceeded and computeExceeded record the pods and GPUs over budget on
+	// the last check, so that events are only created when a pod goes over
+	// budget.
+	exceeded        map[string]bool
+	computeExceeded map[string]bool
+}
+
+// budgets returns the virtual GPUs of every pod, by namespace/name, on every
+// physical GPU.
+func budgets(config Config, pods []*podresourcesapi.PodResources, gpus map[string]GPU) map[string]map[string]int {
+	vGPUs := make(map[string]map[string]int)
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		for _, c := range pod.Containers {
+			for _, id := range containerVGPUs(config, gpus, c) {
+				gpu := getPhysicalDeviceID(id)
+				if _, ok := gpus[gpu]; !ok {
+					continue
+				}
+				if vGPUs[key] == nil {
+					vGPUs[key] = make(map[string]int)
+				}
+				vGPUs[key][gpu]++
+			}
+		}
+	}
+	return vGPUs
+}
+
+// attributed returns the usages of the pods holding virtual GPUs of their
+// GPU. The others have no budget to compare with, e.g. the MPS control daemon
+// or a pod using a GPU it was not allocated.
+func attributed(usages []*podGPUUsage, vGPUs map[string]map[string]int) []*podGPUUsage {
+	var held []*podGPUUsage
+	for _, u := range usages {
+		if vGPUs[u.pod.Namespace+"/"+u.pod.Name][u.gpu] > 0 {
+			held = append(held, u)
+		}
+	}
+	return held
+}
+
+// usage returns the GPU memory used by the pods running on the node.
+func (w *memoryQuotaWatcher) usage() ([]*podGPUUsage, error) {
+	processes, err := w.vgm.backend.GetProcesses()
+	if err != nil {
+		return nil, err
+	}
+	list, err := w.client.CoreV1().Pods("").List(metav1.ListOptions{FieldSelector: "spec.nodeName=" + w.vgm.config.NodeName})
+	if err != nil {
+		return nil, err
+	}
+	pods := make(map[string]*v1.Pod, len(list.Items))
+	for i := range list.Items {
+		pods[string(list.Items[i].UID)] = &list.Items[i]
+	}
+
+	gpuIDs := w.vgm.gpuIDs()
+	usage := make(map[string]*podGPUUsage)
+	var usages []*podGPUUsage
+	for uuid, procs := range processes {
+		gpu, ok := gpuIDs[uuid]
+		if !ok {
+			continue
+		}
+		for _, p := range procs {
+			uid, err := processPodUID(p.PID)
+			if err != nil || uid == "" || pods[uid] == nil {
+				continue
+			}
+			key := uid + "/" + gpu
+			u, ok := usage[key]
+			if !ok {
+				u = &podGPUUsage{pod: pods[uid], gpu: gpu}
+				usage[key] = u
+				usages = append(usages, u)
+			}
+			u.used += p.MemoryUsed
+			u.pids = append(u.pids, p.PID)
+		}
+	}
+	return usages, nil
+}
+
+// check compares the GPU memory used by every pod with its budget and
+// enforces the budget as configured.
+func (w *memoryQuotaWatcher) check() error {
+	usages, err := w.usage()
+	if err != nil {
+		return err
+	}
+	pods, err := listPodResources()
+	if err != nil {
+		return err
+	}
+
+	gpus := w.vgm.gpusByID()
+	vGPUs := budgets(w.vgm.config, pods, gpus)
+	usages = attributed(usages, vGPUs)
+
+	podGPUMemoryUsed.Reset()
+	podGPUMemoryBudget.Reset()
+	exceeded := make(map[string]bool)
+	for _, u := range usages {
+		ns, name := u.pod.Namespace, u.pod.Name
+		gpu := gpus[u.gpu]
+		budget := gpu.Memory * uint64(vGPUs[ns+"/"+name][u.gpu]) / uint64(w.vgm.config.vGPUCount(gpu))
+		podGPUMemoryUsed.Set(float64(u.used), ns, name, u.gpu)
+		podGPUMemoryBudget.Set(float64(budget), ns, name, u.gpu)
+
+		// Without its memory size the GPU budgets are unknown.
+		if gpu.Memory == 0 || u.used <= budget {
+			continue
+		}
+		podGPUMemoryExceeded.Inc(ns, name, u.gpu)
+
+		key := string(u.pod.UID) + "/" + u.gpu
+		exceeded[key] = true
+		w.enforce(u, budget, !w.exceeded[key])
+	}
+	w.exceeded = exceeded
+	return w.checkCompute(usages, vGPUs, gpus)
+}
+
+// checkCompute compares the utilization of every GPU used by a single pod
+// with the compute share of the pod. NVML does not report the utilization of
+// every process, so the GPUs shared by running pods can not be attributed.
+// Pods over their share are reported but never killed nor evicted.
+func (w *memoryQuotaWatcher) checkCompute(usages []*podGPUUsage, vGPUs map[string]map[string]int, gpus map[string]GPU) error {
+	utilization, err := w.vgm.backend.GetUtilization()
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string][]*podGPUUsage)
+	for _, u := range usages {
+		users[u.gpu] = append(users[u.gpu], u)
+	}
+
+	exceeded := make(map[string]bool)
+	for id, us := range users {
+		gpu := gpus[id]
+		usage, ok := utilization[gpu.UUID]
+		if len(us) != 1 || !ok {
+			continue
+		}
+		u := us[0]
+		ns, name := u.pod.Namespace, u.pod.Name
+		share := 100 * uint(vGPUs[ns+"/"+name][id]) / uint(w.vgm.config.vGPUCount(gpu))
+		if usage.Utilization <= share {
+			continue
+		}
+		podGPUComputeExceeded.Inc(ns, name, id)
+
+		key := string(u.pod.UID) + "/" + id
+		exceeded[key] = true
+		if w.computeExceeded[key] {
+			continue
+		}
+		message := fmt.Sprintf("Pod %s/%s using %d%% of GPU %s, its vGPU compute share is %d%%", ns, name, usage.Utilization, id, share)
+		log.Println(message)
+		if w.vgm.config.MemoryQuotaEnforcement != MemoryQuotaMetric {
+			err := kube.RecordPodEvent(w.client, u.pod, memoryQuotaComponent, w.vgm.config.NodeName, v1.EventTypeWarning, computeShareReason, message)
+			if err != nil {
+				log.Printf("Failed to record %s event of pod %s/%s: %v", computeShareReason, ns, name, err)
+			}
+		}
+	}
+	w.computeExceeded = exceeded
+	return nil
+}
+
+// enforce applies the configured enforcement to a pod over budget, every
+// level including the lower ones. Pods are reported and evicted once, when
+// they go over budget, while processes are killed on every check as they
+// may have been restarted.
+func (w *memoryQuotaWatcher) enforce(u *podGPUUsage, budget uint64, first bool) {
+	ns, name := u.pod.Namespace, u.pod.Name
+	level := w.vgm.config.MemoryQuotaEnforcement
+
+	if first {
+		message := fmt.Sprintf("Pod %s/%s using %s of its %s vGPU budget on GPU %s", ns, name, formatMiB(u.used), formatMiB(budget), u.gpu)
+		log.Println(message)
+		if level != MemoryQuotaMetric {
+			err := kube.RecordPodEvent(w.client, u.pod, memoryQuotaComponent, w.vgm.config.NodeName, v1.EventTypeWarning, memoryQuotaReason, message)
+			if err != nil {
+				log.Printf("Failed to record %s event of pod %s/%s: %v", memoryQuotaReason, ns, name, err)
+			}
+		}
+	}
+
+	switch {
+	case level == MemoryQuotaKill:
+		for _, pid := range u.pids {
+			log.Printf("Killing process %d of pod %s/%s over its GPU memory budget", pid, ns, name)
+			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+				log.Printf("Failed to kill process %d: %v", pid, err)
+			}
+		}
+	case level == MemoryQuotaEvict && first:
+		log.Printf("Evicting pod %s/%s over its GPU memory budget", ns, name)
+		if err := kube.EvictPod(w.client, ns, name); err != nil {
+			log.Printf("Failed to evict pod %s/%s: %v", ns, name, err)
+		}
+	}
+}
+
+// watchMemoryQuota checks the GPU memory used by every pod against its
+// budget until stop is closed.
+func (vgm *vGPUManager) watchMemoryQuota(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(memoryQuotaInterval)
+	defer ticker.Stop()
+
+	w := &memoryQuotaWatcher{vgm: vgm, client: client}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.check(); err != nil {
+			log.Printf("Failed to check the GPU memory quotas: %v", err)
+		}
+	}
+}
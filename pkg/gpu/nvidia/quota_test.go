@@ -0,0 +1,49 @@
+package nvidia
+
+import (
+	"os"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestQuotaSkipsUsagesWithoutVGPUs(t *testing.T) {
+	pod := func(name string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	}
+	usages := []*podGPUUsage{
+		{pod: pod("training"), gpu: "0", used: 4096},
+		// The MPS control daemon holds no virtual GPU.
+		{pod: pod("mps-control"), gpu: "0", used: 512},
+		// The pod holds virtual GPUs of GPU 0 only.
+		{pod: pod("training"), gpu: "1", used: 1024},
+	}
+	vGPUs := map[string]map[string]int{"default/training": {"0": 2}}
+
+	held := attributed(usages, vGPUs)
+	if len(held) != 1 || held[0] != usages[0] {
+		t.Errorf("got %d usages, want the usage of GPU 0 by the pod holding its virtual GPUs", len(held))
+	}
+}
+
+func TestQuotaUsageSkipsProcessesOutsidePods(t *testing.T) {
+	backend := NewMockBackend(1)
+	backend.SetProcesses(backend.GPUs[0].UUID, []GPUProcess{
+		// The test runs in no pod.
+		{PID: os.Getpid(), MemoryUsed: 1024},
+		// The process exited, or runs in another PID namespace.
+		{PID: 1 << 30, MemoryUsed: 1024},
+	})
+	client := fake.NewSimpleClientset(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "training", UID: "8a3b6c1e-1111-2222-3333-444455556666"}})
+	w := &memoryQuotaWatcher{vgm: newTestManager(t, testConfig(), backend), client: client}
+
+	usages, err := w.usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 0 {
+		t.Errorf("got %d usages of processes running in no pod, want none", len(usages))
+	}
+}
@@ -0,0 +1,96 @@
+package nvidia
+
+import (
+	"log"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const ledgerReconcileInterval = 30 * time.Second
+
+var (
+	ledgerDrift = metrics.NewCounterVec("vgpu_ledger_drift_total",
+		"Virtual GPUs the allocation ledger disagreed with kubelet about, \"stale\" when allocated to no running container, \"missing\" when allocated without being recorded.", "kind")
+	ledgerAllocated = metrics.NewGaugeVec("vgpu_ledger_allocated_vgpus",
+		"Virtual GPUs of the physical GPU recorded as allocated.", "gpu")
+)
+
+// heldResources returns the resource of every virtual GPU held by the running
+// containers, by virtual GPU ID.
+func heldResources(config Config, gpus map[string]GPU, pods []*podresourcesapi.PodResources) map[string]string {
+	held := make(map[string]string)
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			for _, d := range c.Devices {
+				for _, id := range d.DeviceIds {
+					for _, vGPU := range config.heldVGPUs(d.ResourceName, id, gpus) {
+						held[vGPU] = d.ResourceName
+					}
+				}
+			}
+		}
+	}
+	return held
+}
+
+// liveDevices returns the devices of the running containers, as
+// <resource>/<device ID>.
+func liveDevices(pods []*podresourcesapi.PodResources) map[string]bool {
+	live := make(map[string]bool)
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			for _, d := range c.Devices {
+				for _, id := range d.DeviceIds {
+					live[d.ResourceName+"/"+id] = true
+				}
+			}
+		}
+	}
+	return live
+}
+
+// reconcileLedger keeps the allocation ledger in sync with the devices kubelet
+// assigned to the running containers until stop is closed: the virtual GPUs of
+// containers gone, e.g. of pods deleted while the plugin was down, are
+// released along with their budget files, and the virtual GPUs of running
+// containers missing from the ledger are recorded.
+func (vgm *vGPUManager) reconcileLedger(stop <-chan struct{}) {
+	ticker := time.NewTicker(ledgerReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, err := listPodResources()
+		if err != nil {
+			log.Printf("Failed to list pod resources: %v", err)
+		} else {
+			// Kubelet only reports the devices of a container once Allocate
+			// returned, recent allocations are kept.
+			held := heldResources(vgm.config, vgm.gpusByID(), pods)
+			stale, missing := vgm.ledger.sync(held, time.Now().Add(-assignmentTimeout))
+			if len(stale) > 0 || len(missing) > 0 {
+				log.Printf("Reconciled the allocation ledger with kubelet: released %v, recorded %v.", stale, missing)
+			}
+			ledgerDrift.Add(float64(len(stale)), "stale")
+			ledgerDrift.Add(float64(len(missing)), "missing")
+
+			if vgm.budgets != nil {
+				if n := vgm.budgets.prune(liveDevices(pods), time.Now().Add(-assignmentTimeout)); n > 0 {
+					log.Printf("Removed %d GPU budget files of containers gone.", n)
+				}
+			}
+
+			ledgerAllocated.Reset()
+			for gpu, n := range vgm.ledger.allocatedPerGPU() {
+				ledgerAllocated.Set(float64(n), gpu)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
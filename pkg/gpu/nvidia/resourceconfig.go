@@ -0,0 +1,118 @@
+package nvidia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// ResourceConfig overrides the allocation settings of the device plugin of a
+// resource in the resource configuration file. The settings left unset are
+// those of the command line.
+type ResourceConfig struct {
+	ResponseTemplates     []string `json:"responseTemplates,omitempty"`
+	ReadOnlyMounts        *bool    `json:"readOnlyMounts,omitempty"`
+	DevicePermissions     *string  `json:"devicePermissions,omitempty"`
+	DeviceProfile         *string  `json:"deviceProfile,omitempty"`
+	AllocationAnnotations *bool    `json:"allocationAnnotations,omitempty"`
+	CDIAnnotations        *bool    `json:"cdiAnnotations,omitempty"`
+	PreStartCheck         *bool    `json:"preStartCheck,omitempty"`
+}
+
+// apply returns config with the settings of rc.
+func (rc ResourceConfig) apply(config Config) Config {
+	if rc.ResponseTemplates != nil {
+		config.ResponseTemplates = rc.ResponseTemplates
+	}
+	if rc.ReadOnlyMounts != nil {
+		config.ReadOnlyMounts = *rc.ReadOnlyMounts
+	}
+	if rc.DevicePermissions != nil {
+		config.DevicePermissions = *rc.DevicePermissions
+	}
+	if rc.DeviceProfile != nil {
+		config.DeviceProfile = *rc.DeviceProfile
+	}
+	if rc.AllocationAnnotations != nil {
+		config.AllocationAnnotations = *rc.AllocationAnnotations
+	}
+	if rc.CDIAnnotations != nil {
+		config.CDIAnnotations = *rc.CDIAnnotations
+	}
+	if rc.PreStartCheck != nil {
+		config.PreStartCheck = *rc.PreStartCheck
+	}
+	return config
+}
+
+// loadResourceConfigs reads the configuration of every resource from the
+// JSON file at path, e.g. {"nvidia.com/gpu": {"readOnlyMounts": true}}, and
+// checks that it is valid on top of config. A missing file, e.g. before its
+// ConfigMap is created, configures nothing.
+func loadResourceConfigs(path string, config Config) (map[string]ResourceConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var configs map[string]ResourceConfig
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("invalid resource configuration %s: %v", path, err)
+	}
+	for resource, rc := range configs {
+		if err := rc.apply(config).Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration of resource %s: %v", resource, err)
+		}
+	}
+	return configs, nil
+}
+
+// changedResources returns the resources, sorted, whose configuration
+// differs between old and new.
+func changedResources(old, new map[string]ResourceConfig) []string {
+	var changed []string
+	for resource, rc := range new {
+		if !reflect.DeepEqual(rc, old[resource]) {
+			changed = append(changed, resource)
+		}
+	}
+	for resource, rc := range old {
+		if _, ok := new[resource]; !ok && !reflect.DeepEqual(rc, ResourceConfig{}) {
+			changed = append(changed, resource)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// reloadResourceConfigs reads the resource configuration file again and
+// returns the resources whose configuration changed. The current
+// configuration is kept when the file is invalid.
+func (vgm *vGPUManager) reloadResourceConfigs() ([]string, error) {
+	configs, err := loadResourceConfigs(vgm.config.ResourceConfigFile, vgm.config)
+	if err != nil {
+		return nil, err
+	}
+
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	changed := changedResources(vgm.resourceConfigs, configs)
+	vgm.resourceConfigs = configs
+	return changed, nil
+}
+
+// resourceConfig returns the configuration of the device plugin of resource.
+func (vgm *vGPUManager) resourceConfig(resource string) Config {
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	return vgm.resourceConfigs[resource].apply(vgm.config)
+}
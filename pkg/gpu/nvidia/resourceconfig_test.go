@@ -0,0 +1,119 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeResourceConfig writes the resource configuration file of config.
+func writeResourceConfig(t *testing.T, config Config, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(config.ResourceConfigFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resourceConfigManager returns a manager with a resource configuration file
+// in a new directory, and a cleanup function.
+func resourceConfigManager(t *testing.T, data string) (*vGPUManager, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vgpu-resource-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.PerGPUResources = true
+	config.ResourceConfigFile = filepath.Join(dir, "config.json")
+	writeResourceConfig(t, config, data)
+
+	vgm := newTestManager(t, config, NewMockBackend(2))
+	if _, err := vgm.reloadResourceConfigs(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to load the resource configuration: %v", err)
+	}
+	return vgm, func() { os.RemoveAll(dir) }
+}
+
+func TestResourceConfigOverridesTheResponse(t *testing.T) {
+	vgm, cleanup := resourceConfigManager(t, `{"hkube.io/gpu-1-vgpu": {"responseTemplates": ["toolkit"]}}`)
+	defer cleanup()
+
+	for _, p := range vgm.newDevicePlugins() {
+		ids := []string{"0-0"}
+		if p.resourceName == "hkube.io/gpu-1-vgpu" {
+			ids = []string{"1-0"}
+		}
+		resp, err := p.composeContainer(ids)
+		if err != nil {
+			t.Fatalf("%s: failed to compose the response: %v", p.resourceName, err)
+		}
+		if toolkit := len(resp.Mounts) == 0 && len(resp.Devices) == 0; toolkit != (p.resourceName == "hkube.io/gpu-1-vgpu") {
+			t.Errorf("%s got %d mounts and %d devices", p.resourceName, len(resp.Mounts), len(resp.Devices))
+		}
+	}
+}
+
+func TestResourceConfigReloadReturnsTheChangedResources(t *testing.T) {
+	vgm, cleanup := resourceConfigManager(t, `{
+		"hkube.io/gpu-0-vgpu": {"readOnlyMounts": true},
+		"hkube.io/gpu-1-vgpu": {"devicePermissions": "rw"}
+	}`)
+	defer cleanup()
+
+	writeResourceConfig(t, vgm.config, `{
+		"hkube.io/gpu-0-vgpu": {"readOnlyMounts": true},
+		"hkube.io/gpu-1-vgpu": {"devicePermissions": "r"},
+		"nvidia.com/gpu": {"preStartCheck": true}
+	}`)
+	changed, err := vgm.reloadResourceConfigs()
+	if err != nil {
+		t.Fatalf("failed to reload the resource configuration: %v", err)
+	}
+	if want := []string{"hkube.io/gpu-1-vgpu", "nvidia.com/gpu"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("got changed resources %v, want %v", changed, want)
+	}
+
+	os.Remove(vgm.config.ResourceConfigFile)
+	if changed, err = vgm.reloadResourceConfigs(); err != nil {
+		t.Fatalf("failed to reload the resource configuration: %v", err)
+	}
+	if want := []string{"hkube.io/gpu-0-vgpu", "hkube.io/gpu-1-vgpu", "nvidia.com/gpu"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("got changed resources %v after removing the file, want %v", changed, want)
+	}
+}
+
+func TestInvalidResourceConfigKeepsTheCurrentOne(t *testing.T) {
+	vgm, cleanup := resourceConfigManager(t, `{"nvidia.com/gpu": {"readOnlyMounts": true}}`)
+	defer cleanup()
+
+	for _, data := range []string{
+		`{"nvidia.com/gpu": {"readOnlyMount": false}}`,
+		`{"nvidia.com/gpu": {"responseTemplates": ["mig"]}}`,
+		`{"nvidia.com/gpu": `,
+	} {
+		writeResourceConfig(t, vgm.config, data)
+		if _, err := vgm.reloadResourceConfigs(); err == nil {
+			t.Errorf("invalid resource configuration %s accepted", data)
+		}
+		if !vgm.resourceConfig(resourceName).ReadOnlyMounts {
+			t.Errorf("resource configuration %s replaced the current one", data)
+		}
+	}
+}
+
+func TestRestartedDevicePluginUsesTheResourceConfig(t *testing.T) {
+	vgm, cleanup := resourceConfigManager(t, `{}`)
+	defer cleanup()
+	p := vgm.newDevicePlugins()[0]
+
+	writeResourceConfig(t, vgm.config, `{"nvidia.com/gpu": {"devicePermissions": "r"}}`)
+	if _, err := vgm.reloadResourceConfigs(); err != nil {
+		t.Fatalf("failed to reload the resource configuration: %v", err)
+	}
+	if got := vgm.restartedDevicePlugin(p).config.DevicePermissions; got != "r" {
+		t.Errorf("restarted plugin got device permissions %q, want %q", got, "r")
+	}
+}
@@ -0,0 +1,115 @@
+package nvidia
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// allocationRetryWindow is how long the response to a container request is
+// returned again for the same devices, e.g. when kubelet retries Allocate
+// after timing out.
+const allocationRetryWindow = 30 * time.Second
+
+var allocationRetries = metrics.NewCounterVec("vgpu_allocation_retries_total",
+	"Container requests answered with the response to an identical recent request.", "resource")
+
+// recentAllocation is the response to a container request holding the
+// virtual GPUs vGPUs.
+type recentAllocation struct {
+	response *pluginapi.ContainerAllocateResponse
+	vGPUs    []string
+	at       time.Time
+	// recorded reports whether the allocation was recorded in the ledger,
+	// which then tells when its virtual GPUs are released.
+	recorded bool
+}
+
+// allocationCache keeps the responses to the recent container requests by
+// fingerprint, so that a retried request gets the identical response, even if
+// the driver changed meanwhile. Only the response is reused: the same devices
+// may be allocated to a new pod within allocationRetryWindow, every request
+// is admitted and recorded. A response is forgotten once the ledger released
+// one of its virtual GPUs, or once they are allocated to another request.
+type allocationCache struct {
+	sync.Mutex
+	ledger *allocationLedger
+	recent map[string]recentAllocation
+}
+
+func newAllocationCache(ledger *allocationLedger) *allocationCache {
+	return &allocationCache{ledger: ledger, recent: make(map[string]recentAllocation)}
+}
+
+// allocationFingerprint identifies a container request by its devices,
+// whatever their order.
+func allocationFingerprint(ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// get returns the response to the request for the devices ids made within
+// allocationRetryWindow, nil if none.
+func (c *allocationCache) get(ids []string) *pluginapi.ContainerAllocateResponse {
+	c.Lock()
+	defer c.Unlock()
+
+	f := allocationFingerprint(ids)
+	r, ok := c.recent[f]
+	if !ok || time.Since(r.at) > allocationRetryWindow {
+		return nil
+	}
+	if r.recorded && !c.ledger.holds(r.vGPUs) {
+		delete(c.recent, f)
+		return nil
+	}
+	return r.response
+}
+
+// put records the response to the request for the devices ids, holding the
+// virtual GPUs vGPUs, forgetting the responses older than
+// allocationRetryWindow and those sharing virtual GPUs with it.
+func (c *allocationCache) put(ids, vGPUs []string, response *pluginapi.ContainerAllocateResponse) {
+	c.Lock()
+	defer c.Unlock()
+
+	held := make(map[string]bool, len(vGPUs))
+	for _, id := range vGPUs {
+		held[id] = true
+	}
+	now := time.Now()
+	for f, r := range c.recent {
+		if now.Sub(r.at) > allocationRetryWindow || sharesVGPUs(r.vGPUs, held) {
+			delete(c.recent, f)
+		}
+	}
+	c.recent[allocationFingerprint(ids)] = recentAllocation{response: response, vGPUs: vGPUs, at: now}
+}
+
+// recorded marks the allocation of the devices ids as recorded, so that its
+// response is forgotten once the ledger released its virtual GPUs.
+func (c *allocationCache) recorded(ids []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	f := allocationFingerprint(ids)
+	if r, ok := c.recent[f]; ok {
+		r.recorded = true
+		c.recent[f] = r
+	}
+}
+
+// sharesVGPUs reports whether one of the virtual GPUs is held.
+func sharesVGPUs(vGPUs []string, held map[string]bool) bool {
+	for _, id := range vGPUs {
+		if held[id] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package nvidia
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// newRecordingPlugin returns the main plugin of two mock GPUs, recording its
+// assignments in a queue nothing reads.
+func newRecordingPlugin(t *testing.T) *NvidiaDevicePlugin {
+	t.Helper()
+	p := newTestManager(t, testConfig(), NewMockBackend(2)).newDevicePlugins()[0]
+	p.assignments = newAssignmentRecorder(nil, nil, nil, "node")
+	return p
+}
+
+func allocate(t *testing.T, p *NvidiaDevicePlugin, ids ...string) *pluginapi.ContainerAllocateResponse {
+	t.Helper()
+	resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: ids}},
+	})
+	if err != nil {
+		t.Fatalf("failed to allocate %v: %v", ids, err)
+	}
+	return resp.ContainerResponses[0]
+}
+
+func TestAllocateRetryIsAnsweredAndRecorded(t *testing.T) {
+	p := newRecordingPlugin(t)
+
+	first := allocate(t, p, "0-0", "1-0")
+	if retry := allocate(t, p, "1-0", "0-0"); retry != first {
+		t.Error("the retry did not get the response to the first request")
+	}
+	// The devices may have been allocated to a new pod, which must be
+	// recorded as well.
+	if n := len(p.assignments.pending); n != 2 {
+		t.Errorf("the allocation was recorded %d times, want twice", n)
+	}
+	if !p.ledger.holds([]string{"0-0", "1-0"}) {
+		t.Error("the allocation is missing from the ledger")
+	}
+}
+
+// denyAll is an allocation policy denying every allocation.
+type denyAll struct{}
+
+func (denyAll) Admit(ctx context.Context, request AllocationRequest) error {
+	return errors.New("denied")
+}
+
+func TestAllocateRetryIsAdmitted(t *testing.T) {
+	p := newRecordingPlugin(t)
+
+	allocate(t, p, "0-0")
+	p.policies = []AllocationPolicy{denyAll{}}
+	_, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"0-0"}}},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v, want the new request for the same devices denied", err)
+	}
+}
+
+func TestAllocateRetryOfAnAbandonedRequestIsRecorded(t *testing.T) {
+	p := newRecordingPlugin(t)
+
+	// Kubelet gave up on the first request once it was composed.
+	ids := []string{"0-1"}
+	response, err := p.composeContainer(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.allocations.put(ids, p.vGPUs(ids), response)
+
+	if retry := allocate(t, p, ids...); retry != response {
+		t.Error("the retry did not get the response to the first request")
+	}
+	if n := len(p.assignments.pending); n != 1 {
+		t.Errorf("the allocation was recorded %d times, want once", n)
+	}
+	if !p.ledger.holds(ids) {
+		t.Error("the allocation is missing from the ledger")
+	}
+}
+
+func TestAllocationCacheForgetsReleasedVGPUs(t *testing.T) {
+	p := newRecordingPlugin(t)
+
+	first := allocate(t, p, "0-2")
+	// The container is gone, the ledger released its virtual GPU.
+	p.ledger.sync(map[string]string{}, time.Now())
+	if cached := p.allocations.get([]string{"0-2"}); cached != nil {
+		t.Error("the response of a released virtual GPU is still cached")
+	}
+
+	if again := allocate(t, p, "0-2"); again == first {
+		t.Error("the new allocation got the response to the released one")
+	}
+	if n := len(p.assignments.pending); n != 2 {
+		t.Errorf("%d allocations recorded, want 2", n)
+	}
+}
+
+func TestAllocationCacheForgetsReallocatedVGPUs(t *testing.T) {
+	p := newRecordingPlugin(t)
+
+	allocate(t, p, "0-0", "0-1")
+	allocate(t, p, "0-1", "0-2")
+	if cached := p.allocations.get([]string{"0-0", "0-1"}); cached != nil {
+		t.Error("the response of reallocated virtual GPUs is still cached")
+	}
+}
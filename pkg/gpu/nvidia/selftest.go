@@ -0,0 +1,112 @@
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// SelfTestCheck is the result of a check of the self test.
+type SelfTestCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Message describes the result, the error of a failed check.
+	Message string `json:"message,omitempty"`
+}
+
+// SelfTestReport is the result of the self test, OK when every check passed.
+type SelfTestReport struct {
+	OK     bool            `json:"ok"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+func (r *SelfTestReport) add(name string, err error, message string) {
+	check := SelfTestCheck{Name: name, OK: err == nil, Message: message}
+	if err != nil {
+		check.Message = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// SelfTest checks that the plugin configured with config can run on the
+// node, e.g. while bootstrapping it: that the configuration is valid, that
+// backend finds the GPUs, that their device nodes and the host paths the
+// plugin needs are accessible, and that kubelet accepts connections on its
+// socket.
+func SelfTest(config Config, backend DeviceBackend) SelfTestReport {
+	var r SelfTestReport
+	r.add("config", config.Validate(), "valid")
+
+	var gpus []GPU
+	err := backend.Init()
+	if err == nil {
+		defer backend.Shutdown()
+		gpus, err = backend.Discover()
+	}
+	r.add("gpus", err, fmt.Sprintf("%d GPUs found", len(gpus)))
+
+	if config.FakeGPUs > 0 {
+		r.add("device-nodes", nil, "emulated GPUs have no device node")
+	} else if len(gpus) > 0 {
+		r.add("device-nodes", checkDeviceNodes(backend, gpus), "accessible")
+	}
+
+	var errs []string
+	for _, err := range checkPermissions(config) {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		r.add("permissions", fmt.Errorf("%s", strings.Join(errs, "; ")), "")
+	} else {
+		r.add("permissions", nil, "granted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+	defer cancel()
+	conn, err := dial(ctx, config.kubeletSocket())
+	if err == nil {
+		conn.Close()
+	}
+	r.add("kubelet-socket", err, config.kubeletSocket()+" reachable")
+
+	r.OK = true
+	for _, c := range r.Checks {
+		r.OK = r.OK && c.OK
+	}
+	return r
+}
+
+// checkDeviceNodes checks that the device nodes of the GPUs, and those
+// injected into containers along with them, exist and can be read and
+// written.
+func checkDeviceNodes(backend DeviceBackend, gpus []GPU) error {
+	paths := make(map[string]bool)
+	var ids []string
+	for _, gpu := range gpus {
+		if gpu.Path != "" {
+			paths[gpu.Path] = true
+		}
+		ids = append(ids, gpu.UUID)
+	}
+	for _, path := range backend.ContainerEdits(ids, gpus).DeviceNodes {
+		paths[path] = true
+	}
+
+	var errs []string
+	for path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, err.Error())
+		} else if err := syscall.Access(path, accessRead|accessWrite); err != nil {
+			errs = append(errs, fmt.Sprintf("no rw access to %s: %v", path, err))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package nvidia
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+const (
+	selinuxEnforceFile = "/sys/fs/selinux/enforce"
+	selinuxXattr       = "security.selinux"
+)
+
+// selinuxEnabled reports whether the host has SELinux enabled.
+func selinuxEnabled() bool {
+	_, err := os.Stat(selinuxEnforceFile)
+	return err == nil
+}
+
+// setFileLabel sets the SELinux label of path and, for directories, of
+// everything below it. Symbolic links are left untouched.
+func setFileLabel(path, label string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return syscall.Setxattr(p, selinuxXattr, []byte(label), 0)
+	})
+}
+
+// relabel applies the configured SELinux label to everything injected into
+// containers. Paths are resolved in the plugin's mount namespace, so host
+// directories must be mounted into the plugin at the same location.
+func (m *NvidiaDevicePlugin) relabel() {
+	if !selinuxEnabled() {
+		log.Println("SELinux is not enabled, skipping relabeling")
+		return
+	}
+
+	var ids []string
+	for id := range m.gpus {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	a := &containerAllocation{gpus: ids, edits: m.containerEdits(ids)}
+	a.edits.DriverDir = m.driverBase(a.edits)
+	if err := m.compose(a, true); err != nil {
+		log.Printf("Warning: failed to compose the devices and mounts to relabel: %v", err)
+		return
+	}
+
+	var paths []string
+	for _, d := range a.response.Devices {
+		paths = append(paths, d.HostPath)
+	}
+	for _, mnt := range a.response.Mounts {
+		paths = append(paths, mnt.HostPath)
+	}
+	paths = append(paths, m.driverRoots()...)
+
+	for _, p := range paths {
+		if err := setFileLabel(p, m.config.SELinuxLabel); err != nil {
+			log.Printf("Warning: failed to set SELinux label %q on %s: %v", m.config.SELinuxLabel, p, err)
+			continue
+		}
+		log.Printf("Set SELinux label %q on %s", m.config.SELinuxLabel, p)
+	}
+}
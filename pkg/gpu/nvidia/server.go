@@ -6,69 +6,124 @@ import (
 	"net"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 const (
 	resourceName           = "nvidia.com/gpu"
-	serverSock             = pluginapi.DevicePluginPath + "hkube-vgpu.sock"
+	serverSock             = "hkube-vgpu.sock"
+	perGPUResourceName     = "hkube.io/gpu-%d-vgpu"
+	perGPUServerSock       = "hkube-vgpu-gpu%d.sock"
+	exclusiveServerSock    = "hkube-vgpu-exclusive.sock"
+	kubeletSock            = "kubelet.sock"
 	envDisableHealthChecks = "DP_DISABLE_HEALTHCHECKS"
 	allHealthChecks        = "xids"
+
+	registerTimeout = 5 * time.Second
+
+	// healthUpdateInterval is the minimum interval between two device list
+	// updates sent to kubelet.
+	healthUpdateInterval = 2 * time.Second
 )
 
 // NvidiaDevicePlugin implements the Kubernetes device plugin API
 type NvidiaDevicePlugin struct {
-	devs         []*pluginapi.Device
-	physicalDevs []string
-
-	socket string
+	devices *deviceStore
+
+	resourceName string
+	socket       string
+	config       Config
+	ledger       *allocationLedger
+	assignments  *assignmentRecorder
+	computeModes *computeModeManager
+	budgets      *budgetWriter
+	healthLog    *gpuHealthLog
+	backend      DeviceBackend
+	// gpus are the physical GPUs by their ID in the virtual GPU IDs.
+	gpus map[string]GPU
+	// policies admit the allocations.
+	policies []AllocationPolicy
+	// allocations are the responses to the recent container requests.
+	allocations *allocationCache
+	// templates compose the responses to container requests, and
+	// devicePermissions are those granted on their device nodes.
+	templates         []preparedTemplate
+	devicePermissions string
 
 	stop   chan interface{}
-	health chan *pluginapi.Device
+	health *healthQueue
+	// refresh is notified, without blocking, when the advertised devices
+	// change for other reasons than their health.
+	refresh chan struct{}
 
 	server *grpc.Server
 }
 
-// NewNvidiaDevicePlugin returns an initialized NvidiaDevicePlugin
-func NewNvidiaDevicePlugin(vGPUCount int) *NvidiaDevicePlugin {
-	physicalDevs := getPhysicalGPUDevices()
-	vGPUDevs := getVGPUDevices(vGPUCount)
-
-	return &NvidiaDevicePlugin{
-		devs:         vGPUDevs,
-		physicalDevs: physicalDevs,
-		socket:       serverSock,
-
-		stop:   make(chan interface{}),
-		health: make(chan *pluginapi.Device),
+// NewNvidiaDevicePlugin returns an initialized NvidiaDevicePlugin advertising
+// the virtual GPUs devs as resourceName on socket
+func NewNvidiaDevicePlugin(resourceName, socket string, devs []*pluginapi.Device, config Config, ledger *allocationLedger) *NvidiaDevicePlugin {
+	m := &NvidiaDevicePlugin{
+		devices:      newDeviceStore(devs),
+		resourceName: resourceName,
+		socket:       socket,
+		config:       config,
+		ledger:       ledger,
+		allocations:  newAllocationCache(ledger),
+
+		stop:    make(chan interface{}),
+		health:  newHealthQueue(),
+		refresh: make(chan struct{}, 1),
 	}
+	return m
 }
 
 func (m *NvidiaDevicePlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
-	return &pluginapi.DevicePluginOptions{}, nil
+	return &pluginapi.DevicePluginOptions{PreStartRequired: m.config.PreStartCheck}, nil
 }
 
-// dial establishes the gRPC communication with the registered device plugin.
-func dial(unixSocketPath string, timeout time.Duration) (*grpc.ClientConn, error) {
-	c, err := grpc.Dial(unixSocketPath, grpc.WithInsecure(), grpc.WithBlock(),
-		grpc.WithTimeout(timeout),
-		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
-			return net.DialTimeout("unix", addr, timeout)
+// dial establishes the gRPC communication with the server listening on the
+// unix socket, waiting for the connection to be ready until ctx is done.
+func dial(ctx context.Context, unixSocketPath string) (*grpc.ClientConn, error) {
+	c, err := grpc.DialContext(ctx, unixSocketPath, grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
 		}),
 	)
-
 	if err != nil {
 		return nil, err
 	}
 
+	if err := waitForReady(ctx, c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// waitForReady waits for the connection to be ready until ctx is done.
+func waitForReady(ctx context.Context, c *grpc.ClientConn) error {
+	for {
+		state := c.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !c.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection not ready, last state %s: %v", state, ctx.Err())
+		}
+	}
+}
+
 // Start starts the gRPC server of the device plugin
 func (m *NvidiaDevicePlugin) Start() error {
 	err := m.cleanup()
@@ -76,23 +131,42 @@ func (m *NvidiaDevicePlugin) Start() error {
 		return err
 	}
 
+	if m.config.SELinuxLabel != "" {
+		m.relabel()
+	}
+
 	sock, err := net.Listen("unix", m.socket)
 	if err != nil {
 		return err
 	}
 
-	m.server = grpc.NewServer([]grpc.ServerOption{}...)
-	pluginapi.RegisterDevicePluginServer(m.server, m)
+	// Only the owner, and kubelet running as root, may use the socket.
+	if err := os.Chmod(m.socket, 0600); err != nil {
+		return err
+	}
+
+	var opts []grpc.ServerOption
+	if m.config.VerifySocketPeer {
+		// The plugin user may query its own socket.
+		uids := append([]uint32{uint32(os.Getuid())}, m.config.AllowedPeerUIDs...)
+		opts = append(opts, grpc.Creds(newPeerCredentials(uids)))
+	}
+
+	server := grpc.NewServer(opts...)
+	pluginapi.RegisterDevicePluginServer(server, m)
+	m.server = server
 
 	go func() {
 		lastCrashTime := time.Now()
 		restartCount := 0
 		for {
 			log.Println("Starting GRPC server")
-			err := m.server.Serve(sock)
-			if err != nil {
-				log.Printf("GRPC server crashed with error: %v", err)
+			err := server.Serve(sock)
+			if err == nil {
+				// The server was stopped.
+				return
 			}
+			log.Printf("GRPC server crashed with error: %v", err)
 			// restart if it has not been too often
 			// i.e. if server has crashed more than 5 times and it didn't last more than one hour each time
 			if restartCount > 5 {
@@ -111,34 +185,49 @@ func (m *NvidiaDevicePlugin) Start() error {
 		}
 	}()
 
-	// Wait for server to start by launching a blocking connexion
-	conn, err := dial(m.socket, 5*time.Second)
-	if err != nil {
-		return err
-	}
-	conn.Close()
+	// Connections are accepted from the socket as soon as it listens, there
+	// is no need to wait for the server to start.
 
-	// go m.healthcheck()
+	// The health checks run until Stop closes m.stop.
+	go m.healthcheck()
 
 	return nil
 }
 
-// Stop stops the gRPC server
+// Stop stops the gRPC server, letting in-flight calls complete for up to the
+// drain timeout before closing their connections.
 func (m *NvidiaDevicePlugin) Stop() error {
 	if m.server == nil {
 		return nil
 	}
 
-	m.server.Stop()
-	m.server = nil
+	// Ends the ListAndWatch streams, which would otherwise never drain.
 	close(m.stop)
 
+	drained := make(chan struct{})
+	go func() {
+		m.server.GracefulStop()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(m.config.DrainTimeout):
+		log.Printf("In-flight calls to %s did not complete within %s, stopping", m.socket, m.config.DrainTimeout)
+		m.server.Stop()
+		<-drained
+	}
+	m.server = nil
+
 	return m.cleanup()
 }
 
 // Register registers the device plugin for the given resourceName with Kubelet.
 func (m *NvidiaDevicePlugin) Register(kubeletEndpoint, resourceName string) error {
-	conn, err := dial(kubeletEndpoint, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, kubeletEndpoint)
 	if err != nil {
 		log.Printf("endpoint %s, Dial conn error: %s", kubeletEndpoint, err)
 		return err
@@ -150,9 +239,10 @@ func (m *NvidiaDevicePlugin) Register(kubeletEndpoint, resourceName string) erro
 		Version:      pluginapi.Version,
 		Endpoint:     path.Base(m.socket),
 		ResourceName: resourceName,
+		Options:      &pluginapi.DevicePluginOptions{PreStartRequired: m.config.PreStartCheck},
 	}
 
-	_, err = client.Register(context.Background(), reqt)
+	_, err = client.Register(ctx, reqt)
 	if err != nil {
 		log.Printf("client register: %s", err)
 		return err
@@ -160,102 +250,191 @@ func (m *NvidiaDevicePlugin) Register(kubeletEndpoint, resourceName string) erro
 	return nil
 }
 
-// ListAndWatch lists devices and update that list according to the health status
+// ListAndWatch lists devices and update that list according to the health status.
+// Health changes, and the devices withheld by the oversubscription
+// guardrails, are sent at most once per healthUpdateInterval so that a
+// flapping GPU does not flood kubelet with updates.
 func (m *NvidiaDevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
-	s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devs})
+	lastSend := time.Now()
+	s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devices.snapshot()})
 
+	// delayed fires when the changes held back since the last send are due.
+	var delayed <-chan time.Time
 	for {
 		select {
 		case <-m.stop:
 			return nil
-		case d := <-m.health:
+		case <-m.health.changes:
 			// FIXME: there is no way to recover from the Unhealthy state.
-			d.Health = pluginapi.Unhealthy
-			log.Printf("device marked unhealthy: %s", d.ID)
-			s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devs})
+			changed := false
+			for _, d := range m.health.pop() {
+				if m.devices.setHealth(d.ID, pluginapi.Unhealthy) {
+					log.Printf("device marked unhealthy: %s", d.ID)
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+		case <-m.refresh:
+		case <-delayed:
+			delayed = nil
 		}
+
+		if delayed != nil {
+			continue
+		}
+		if wait := healthUpdateInterval - time.Since(lastSend); wait > 0 {
+			delayed = time.After(wait)
+			continue
+		}
+		lastSend = time.Now()
+		s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devices.snapshot()})
 	}
 }
 
 func (m *NvidiaDevicePlugin) unhealthy(dev *pluginapi.Device) {
-	m.health <- dev
+	m.health.push(dev)
+}
+
+// withhold stops advertising the devices of ids, and advertises again the
+// devices withheld before that are no longer in ids.
+func (m *NvidiaDevicePlugin) withhold(ids map[string]bool) {
+	if !m.devices.withhold(ids) {
+		return
+	}
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// setGPUHealth sets the health of the devices backed by the physical GPU with
+// the given ID.
+func (m *NvidiaDevicePlugin) setGPUHealth(gpu, health string) {
+	changed := false
+	for _, d := range m.devices.devicesOf(gpu) {
+		if m.devices.setHealth(d.ID, health) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
 }
 
 // Allocate which return list of devices.
 func (m *NvidiaDevicePlugin) Allocate(ctx context.Context, reqs *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	devs := m.devs
-	responses := pluginapi.AllocateResponse{}
-	physicalDevsMap := make(map[string]bool)
+	if m.config.AllocateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.config.AllocateTimeout)
+		defer cancel()
+	}
+
+	count := 0
 	for _, req := range reqs.ContainerRequests {
+		count += len(req.DevicesIDs)
+	}
+
+	responses := pluginapi.AllocateResponse{
+		ContainerResponses: make([]*pluginapi.ContainerAllocateResponse, 0, len(reqs.ContainerRequests)),
+	}
+	// A device can only be allocated once, whatever the container.
+	requested := make(map[string]bool, count)
+	for _, req := range reqs.ContainerRequests {
+		if err := contextError(ctx); err != nil {
+			return nil, err
+		}
+
+		if len(req.DevicesIDs) > m.devices.count() {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid allocation request: %d devices requested, %d available", len(req.DevicesIDs), m.devices.count())
+		}
 		for _, id := range req.DevicesIDs {
-			if !deviceExists(devs, id) {
-				return nil, fmt.Errorf("invalid allocation request: unknown device: %s", id)
+			if err := validateDeviceID(id); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid allocation request: %v", err)
 			}
-
-			// Convert virtual GPUDeviceId to physical GPUDeviceID
-			physicalDevId := getPhysicalDeviceID(id)
-			if !physicalDevsMap[physicalDevId] {
-				physicalDevsMap[physicalDevId] = true
+			if requested[id] {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid allocation request: duplicate device: %s", id)
 			}
+			requested[id] = true
 
-			dev := getDeviceById(devs, id)
-			if dev == nil {
-				return nil, fmt.Errorf("invalid allocation request: unknown device: %s", id)
+			dev, ok := m.devices.get(id)
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid allocation request: unknown device: %s", id)
 			}
 
 			if dev.Health != pluginapi.Healthy {
-				return nil, fmt.Errorf("invalid allocation request with unhealthy device %s", id)
+				return nil, status.Errorf(codes.FailedPrecondition, "invalid allocation request with unhealthy device %s", id)
 			}
 		}
 
-		// Set physical GPU devices as container visible devices
-		visibleDevs := make([]string, 0, len(physicalDevsMap))
-		for visibleDev := range physicalDevsMap {
-			visibleDevs = append(visibleDevs, visibleDev)
+		if err := m.admit(ctx, req.DevicesIDs); err != nil {
+			if cerr := contextError(ctx); cerr != nil {
+				return nil, cerr
+			}
+			return nil, status.Errorf(codes.PermissionDenied, "allocation denied by policy: %v", err)
 		}
-		response := pluginapi.ContainerAllocateResponse{
-			Envs: map[string]string{
-				"NVIDIA_VISIBLE_DEVICES": strings.Join(visibleDevs, ","),
-			},
+
+		// A retried request gets the response to the first attempt.
+		if cached := m.allocations.get(req.DevicesIDs); cached != nil {
+			allocationRetries.Inc(m.resourceName)
+			responses.ContainerResponses = append(responses.ContainerResponses, cached)
+			continue
 		}
 
-		// Set MPS environment variables - figure it out why it doesn't work?
-		//response.Envs["CUDA_MPS_ACTIVE_THREAD_PERCENTAGE"] = fmt.Sprintf("%d", 100 * uint(len(req.DevicesIDs) / len(m.devs)))
-		//response.Envs["CUDA_MPS_PIPE_DIRECTORY"] = "/tmp"
-		//
-		response.Mounts = append(response.Mounts, &pluginapi.Mount{
-			HostPath: "/home/kubernetes/bin/nvidia",
-			ContainerPath: "/usr/local/nvidia",
-		})
-		response.Mounts = append(response.Mounts, &pluginapi.Mount{
-			ContainerPath: "/etc/vulkan/icd.d",
-			HostPath: "/home/kubernetes/bin/vulkan/icd.d",
-		})
-		response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
-			HostPath:      "/dev/nvidia0",
-			ContainerPath: "/dev/nvidia0",
-			Permissions:   "mrw",
-		})
-		response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
-			HostPath:      "/dev/nvidiactl",
-			ContainerPath: "/dev/nvidiactl",
-			Permissions:   "mrw",
-		})
-		response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
-			HostPath:      "/dev/nvidia-uvm",
-			ContainerPath: "/dev/nvidia-uvm",
-			Permissions:   "mrw",
-		})
-
-
-		responses.ContainerResponses = append(responses.ContainerResponses, &response)
+		response, err := m.composeContainer(req.DevicesIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		m.allocations.put(req.DevicesIDs, m.vGPUs(req.DevicesIDs), response)
+		responses.ContainerResponses = append(responses.ContainerResponses, response)
+	}
+
+	// Kubelet gave up on the request, do not record allocations it ignores.
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// The devices of a retried request may have been allocated to a new pod,
+	// every allocation is recorded.
+	for _, req := range reqs.ContainerRequests {
+		vGPUs := m.vGPUs(req.DevicesIDs)
+		if m.computeModes != nil {
+			m.computeModes.allocated(vGPUs)
+		}
+		m.ledger.allocate(m.resourceName, vGPUs)
+		if m.assignments != nil {
+			m.assignments.record(m.resourceName, req.DevicesIDs, m.gpuShares(m.physicalGPUs(req.DevicesIDs), req.DevicesIDs))
+		}
+		m.allocations.recorded(req.DevicesIDs)
 	}
 
 	return &responses, nil
 }
 
-func (m *NvidiaDevicePlugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
-	return &pluginapi.PreStartContainerResponse{}, nil
+// physicalGPUs returns the physical GPUs backing the virtual GPUs of a
+// container, once each and sorted by index, so that the environment of the
+// container only depends on its devices, not on their order in the request.
+func (m *NvidiaDevicePlugin) physicalGPUs(ids []string) []string {
+	gpus := physicalGPUs(ids)
+	sort.SliceStable(gpus, func(i, j int) bool { return m.gpus[gpus[i]].Index < m.gpus[gpus[j]].Index })
+	return gpus
+}
+
+// contextError returns the gRPC status of a done context, nil otherwise.
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, "allocation timed out")
+	case context.Canceled:
+		return status.Error(codes.Canceled, "allocation canceled")
+	}
+	return nil
 }
 
 func (m *NvidiaDevicePlugin) cleanup() error {
@@ -279,7 +458,11 @@ func (m *NvidiaDevicePlugin) healthcheck() {
 	var xids chan *pluginapi.Device
 	if !strings.Contains(disableHealthChecks, "xids") {
 		xids = make(chan *pluginapi.Device)
-		go watchXIDs(ctx, m.devs, xids)
+		gpuIDs := make(map[string]string, len(m.gpus))
+		for id, gpu := range m.gpus {
+			gpuIDs[gpu.UUID] = id
+		}
+		go watchHealth(ctx, m.backend, gpuIDs, m.devices.snapshot(), xids, m.healthLog)
 	}
 
 	for {
@@ -302,23 +485,13 @@ func (m *NvidiaDevicePlugin) Serve() error {
 	}
 	log.Println("Starting to serve on", m.socket)
 
-	err = m.Register(pluginapi.KubeletSocket, resourceName)
+	err = m.Register(m.config.kubeletSocket(), m.resourceName)
 	if err != nil {
 		log.Printf("Could not register device plugin: %s", err)
 		m.Stop()
 		return err
 	}
-	log.Println("Registered device plugin with Kubelet")
-
-	return nil
-}
-
-func getDeviceById(devices []*pluginapi.Device, deviceId string) *pluginapi.Device {
-	for _, d := range devices {
-		if d.ID == deviceId {
-			return d
-		}
-	}
+	log.Printf("Registered device plugin for %s with Kubelet", m.resourceName)
 
 	return nil
 }
@@ -0,0 +1,241 @@
+package nvidia
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvml"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	"golang.org/x/net/context"
+)
+
+// smiHealthInterval is how often nvidia-smi is run to check that the GPUs are
+// still reachable.
+const smiHealthInterval = 30 * time.Second
+
+// smiXidLost is the Xid error reported for a GPU nvidia-smi lost, the one of
+// a GPU fallen off the bus.
+const smiXidLost = 79
+
+var nvmlFallback = metrics.NewGaugeVec("vgpu_nvml_fallback",
+	"Whether NVML could not be loaded and the GPUs are accessed through nvidia-smi, with degraded health checks.")
+
+// smiBackend accesses the GPUs by parsing the output of nvidia-smi, for
+// driver installs where libnvidia-ml can not be loaded by the plugin. Health
+// checks only tell whether the GPUs are still reachable.
+type smiBackend struct{}
+
+// NewSMIBackend returns the DeviceBackend using nvidia-smi.
+func NewSMIBackend() DeviceBackend {
+	return smiBackend{}
+}
+
+// querySMI runs nvidia-smi with the given query option, e.g. --query-gpu, for
+// the given fields and returns its CSV records.
+func querySMI(query string, fields ...string) ([][]string, error) {
+	out, err := exec.Command("nvidia-smi", query+"="+strings.Join(fields, ","), "--format=csv,noheader,nounits").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseSMIRecords(string(out), len(fields))
+}
+
+// parseSMIRecords parses the CSV output of nvidia-smi, whose records have the
+// given number of fields.
+func parseSMIRecords(out string, fields int) ([][]string, error) {
+	r := csv.NewReader(strings.NewReader(out))
+	r.FieldsPerRecord = fields
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid nvidia-smi output: %v", err)
+	}
+	return records, nil
+}
+
+// parseSMIUint parses a number of nvidia-smi, "[N/A]" or "[Not Supported]"
+// being zero.
+func parseSMIUint(s string) (uint64, error) {
+	if strings.HasPrefix(s, "[") {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func (smiBackend) Init() error {
+	_, err := exec.LookPath("nvidia-smi")
+	return err
+}
+
+func (smiBackend) Shutdown() error {
+	return nil
+}
+
+// Discover returns the GPUs listed by nvidia-smi. Their device node is derived
+// from their index, nvidia-smi does not report it.
+func (smiBackend) Discover() ([]GPU, error) {
+	records, err := querySMI("--query-gpu", "index", "uuid", "name", "memory.total")
+	if err != nil {
+		return nil, err
+	}
+	var gpus []GPU
+	for _, r := range records {
+		index, err := strconv.Atoi(r[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPU index %q: %v", r[0], err)
+		}
+		memory, err := parseSMIUint(r[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory of GPU %d: %v", index, err)
+		}
+		gpus = append(gpus, GPU{
+			Index:             index,
+			UUID:              r[1],
+			Path:              fmt.Sprintf("/dev/nvidia%d", index),
+			Model:             r[2],
+			Memory:            memory,
+			ComputeCapability: computeCapability(r[2]),
+		})
+	}
+	return gpus, nil
+}
+
+// GetHealthEvents runs nvidia-smi periodically and reports the GPUs it no
+// longer lists, or lists as lost, with the Xid error of a GPU fallen off the
+// bus. Xid and ECC errors are not reported.
+func (smiBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	ticker := time.NewTicker(smiHealthInterval)
+	defer ticker.Stop()
+
+	lost := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		records, err := querySMI("--query-gpu", "uuid", "pstate")
+		if err != nil {
+			log.Printf("Failed to check the GPUs with nvidia-smi: %v", err)
+			continue
+		}
+		reachable := make(map[string]bool)
+		for _, r := range records {
+			reachable[r[0]] = !strings.Contains(r[1], "GPU is lost")
+		}
+		for _, uuid := range uuids {
+			if reachable[uuid] || lost[uuid] {
+				continue
+			}
+			lost[uuid] = true
+			select {
+			case events <- HealthEvent{UUID: uuid, Xid: smiXidLost}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (smiBackend) GetUtilization() (map[string]GPUUsage, error) {
+	records, err := querySMI("--query-gpu", "uuid", "utilization.gpu", "memory.free")
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]GPUUsage)
+	for _, r := range records {
+		utilization, err := parseSMIUint(r[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid utilization of GPU %s: %v", r[0], err)
+		}
+		free, err := parseSMIUint(r[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid free memory of GPU %s: %v", r[0], err)
+		}
+		usage[r[0]] = GPUUsage{Utilization: uint(utilization), MemoryFree: free}
+	}
+	return usage, nil
+}
+
+func (smiBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	records, err := querySMI("--query-compute-apps", "gpu_uuid", "pid", "used_memory")
+	if err != nil {
+		return nil, err
+	}
+	processes := make(map[string][]GPUProcess)
+	for _, r := range records {
+		pid, err := strconv.Atoi(r[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid PID %q: %v", r[1], err)
+		}
+		used, err := parseSMIUint(r[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory of process %d: %v", pid, err)
+		}
+		processes[r[0]] = append(processes[r[0]], GPUProcess{PID: pid, MemoryUsed: used})
+	}
+	return processes, nil
+}
+
+// GetDriverInfo returns the version of the driver, nvidia-smi does not report
+// the CUDA version in its queries.
+func (smiBackend) GetDriverInfo() (DriverInfo, error) {
+	records, err := querySMI("--query-gpu", "driver_version")
+	if err != nil {
+		return DriverInfo{}, err
+	}
+	if len(records) == 0 {
+		return DriverInfo{}, fmt.Errorf("no GPU listed by nvidia-smi")
+	}
+	return DriverInfo{
+		Version:               records[0][0],
+		OpenKernelModules:     openKernelModules(),
+		ConfidentialComputing: confidentialComputing(),
+	}, nil
+}
+
+func (smiBackend) SetComputeMode(uuid, mode string) error {
+	return nvmlBackend{}.SetComputeMode(uuid, mode)
+}
+
+func (smiBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	return fabricContainerEdits(ids, gpus)
+}
+
+// nvmlFallbackBackend uses NVML, or nvidia-smi when NVML can not be loaded
+// but nvidia-smi is installed, so that the node still advertises its GPUs.
+type nvmlFallbackBackend struct {
+	DeviceBackend
+}
+
+// NewNVMLBackendWithFallback returns the DeviceBackend using NVML, falling
+// back to nvidia-smi when libnvidia-ml can not be loaded.
+func NewNVMLBackendWithFallback() DeviceBackend {
+	return &nvmlFallbackBackend{DeviceBackend: NewNVMLBackend()}
+}
+
+// Init falls back to nvidia-smi when NVML can not be loaded. It is called
+// before any other method, which then use the selected backend.
+func (b *nvmlFallbackBackend) Init() error {
+	err := b.DeviceBackend.Init()
+	if err == nil {
+		nvmlFallback.Set(0)
+		return nil
+	}
+	if err != nvml.ErrLibraryNotFound && err != nvml.ErrUnavailable {
+		return err
+	}
+	if _, lerr := exec.LookPath("nvidia-smi"); lerr != nil {
+		return err
+	}
+	log.Printf("Warning: %v, falling back to nvidia-smi with degraded health checks.", err)
+	nvmlFallback.Set(1)
+	b.DeviceBackend = NewSMIBackend()
+	return b.DeviceBackend.Init()
+}
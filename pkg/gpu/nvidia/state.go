@@ -0,0 +1,119 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// stateConfigMap is the name of the ConfigMap holding the state of the
+	// plugin of a node.
+	stateConfigMap = "hkube-vgpu-state-%s"
+	// stateKey is the key of the state in the ConfigMap.
+	stateKey = "state.json"
+	// stateNodeLabel selects the state ConfigMaps, its value is the node.
+	stateNodeLabel = "hkube.io/vgpu-state-node"
+
+	stateInterval = 30 * time.Second
+)
+
+// pluginState is the compact summary of the state of the plugin published
+// for debugging.
+type pluginState struct {
+	Driver    string          `json:"driver,omitempty"`
+	GPUs      []gpuState      `json:"gpus"`
+	Resources []resourceState `json:"resources"`
+}
+
+// gpuState is the state of a physical GPU.
+type gpuState struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	Model string `json:"model,omitempty"`
+	VGPUs int    `json:"vgpus"`
+	// Allocated maps the allocated virtual GPUs to their resource.
+	Allocated map[string]string `json:"allocated,omitempty"`
+}
+
+// resourceState is the state of the devices of a resource.
+type resourceState struct {
+	Name      string   `json:"name"`
+	Devices   int      `json:"devices"`
+	Unhealthy []string `json:"unhealthy,omitempty"`
+	// Withheld are the healthy devices no longer advertised because their
+	// virtual GPUs are allocated through another resource or their GPU is
+	// full.
+	Withheld []string `json:"withheld,omitempty"`
+}
+
+// state returns the current state of the plugin.
+func (vgm *vGPUManager) state() *pluginState {
+	s := &pluginState{}
+	if info, err := vgm.backend.GetDriverInfo(); err == nil {
+		s.Driver = info.Version
+	}
+
+	allocations := vgm.ledger.allocations()
+	for _, gpu := range vgm.gpus {
+		id := vgm.config.gpuID(gpu)
+		g := gpuState{Index: gpu.Index, ID: id, Model: gpu.Model, VGPUs: vgm.config.vGPUCount(gpu)}
+		for vGPU, a := range allocations {
+			if getPhysicalDeviceID(vGPU) != id {
+				continue
+			}
+			if g.Allocated == nil {
+				g.Allocated = make(map[string]string)
+			}
+			g.Allocated[vGPU] = a.Resource
+		}
+		s.GPUs = append(s.GPUs, g)
+	}
+
+	for _, p := range vgm.devicePlugins() {
+		unhealthy, withheld := p.devices.unavailable()
+		sort.Strings(unhealthy)
+		sort.Strings(withheld)
+		s.Resources = append(s.Resources, resourceState{
+			Name:      p.resourceName,
+			Devices:   p.devices.count(),
+			Unhealthy: unhealthy,
+			Withheld:  withheld,
+		})
+	}
+	return s
+}
+
+// publishState keeps the state ConfigMap of the node up to date until stop
+// is closed.
+func (vgm *vGPUManager) publishState(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+
+	name := fmt.Sprintf(stateConfigMap, vgm.config.NodeName)
+	labels := map[string]string{stateNodeLabel: vgm.config.NodeName}
+	var published string
+	for {
+		if b, err := json.Marshal(vgm.state()); err != nil {
+			log.Printf("Failed to encode plugin state: %v", err)
+		} else if value := string(b); value != published {
+			err := kube.ApplyConfigMap(client, vgm.config.StateNamespace, name, labels, map[string]string{stateKey: value})
+			if err != nil {
+				log.Printf("Failed to publish plugin state to ConfigMap %s/%s: %v", vgm.config.StateNamespace, name, err)
+			} else {
+				published = value
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// taintRetryInterval is how long to wait before retrying to update the taint
+// of the node.
+const taintRetryInterval = 30 * time.Second
+
+// parseTaint parses a taint in the kubectl format, key[=value]:effect.
+func parseTaint(s string) (v1.Taint, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return v1.Taint{}, fmt.Errorf("invalid taint %q, expected key[=value]:effect", s)
+	}
+	taint := v1.Taint{Key: s[:i], Effect: v1.TaintEffect(s[i+1:])}
+	if j := strings.Index(taint.Key, "="); j >= 0 {
+		taint.Key, taint.Value = taint.Key[:j], taint.Key[j+1:]
+	}
+	if taint.Key == "" {
+		return v1.Taint{}, fmt.Errorf("invalid taint %q, the key is empty", s)
+	}
+	switch taint.Effect {
+	case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return v1.Taint{}, fmt.Errorf("invalid taint effect %q, expected NoSchedule, PreferNoSchedule or NoExecute", taint.Effect)
+	}
+	return taint, nil
+}
+
+// setNoGPUTaint taints the node with the NoGPUTaint when present is true, so
+// that GPU workloads are not scheduled onto a node without usable GPUs, and
+// removes the taint otherwise. The API server is retried until it succeeds.
+func (vgm *vGPUManager) setNoGPUTaint(client kubernetes.Interface, present bool) {
+	taint, err := parseTaint(vgm.config.NoGPUTaint)
+	if err != nil {
+		log.Printf("Ignoring taint: %v", err)
+		return
+	}
+
+	for {
+		err := kube.SetNodeTaint(client, vgm.config.NodeName, taint, present)
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to update taint %s of node %s, retrying: %v", vgm.config.NoGPUTaint, vgm.config.NodeName, err)
+		time.Sleep(taintRetryInterval)
+	}
+	if present {
+		log.Printf("Tainted node %s with %s.", vgm.config.NodeName, vgm.config.NoGPUTaint)
+	}
+}
+
+// waitWithoutGPUs taints the node when a NoGPUTaint is configured and waits
+// until one of sigs asks the plugin to shut down. The taint is removed once
+// the plugin restarts and finds GPUs.
+func (vgm *vGPUManager) waitWithoutGPUs(sigs <-chan os.Signal) {
+	if vgm.config.NoGPUTaint != "" {
+		if client, err := vgm.kubeClient(); err != nil {
+			log.Printf("Failed to create Kubernetes client, not tainting node: %v", err)
+		} else {
+			go vgm.setNoGPUTaint(client, true)
+		}
+	}
+	for s := range sigs {
+		switch s {
+		case syscall.SIGHUP, syscall.SIGUSR1:
+			// There is no device plugin to restart nor state to dump.
+		default:
+			log.Printf("Received signal \"%v\", shutting down.", s)
+			return
+		}
+	}
+}
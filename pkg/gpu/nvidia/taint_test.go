@@ -0,0 +1,32 @@
+package nvidia
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitWithoutGPUsShutsDownOnSignal(t *testing.T) {
+	vgm := NewVirtualGPUManagerWithBackend(testConfig(), NewMockBackend(0))
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	go func() {
+		vgm.waitWithoutGPUs(sigs)
+		close(done)
+	}()
+
+	sigs <- syscall.SIGHUP
+	select {
+	case <-done:
+		t.Fatal("stopped waiting on SIGHUP")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sigs <- syscall.SIGTERM
+	select {
+	case <-done:
+	case <-time.After(registrationTimeout):
+		t.Fatal("still waiting after SIGTERM")
+	}
+}
@@ -0,0 +1,196 @@
+package nvidia
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// tegraReleaseFile records the version of the L4T (Linux for Tegra)
+	// release, which ships the GPU driver.
+	tegraReleaseFile = "/etc/nv_tegra_release"
+	// tegraGPUDevice is the control device of the integrated GPU.
+	tegraGPUDevice = "/dev/nvhost-ctrl-gpu"
+
+	deviceTreeModel  = "/proc/device-tree/model"
+	deviceTreeSerial = "/proc/device-tree/serial-number"
+	procMeminfo      = "/proc/meminfo"
+)
+
+// tegraDeviceNodes are the device nodes CUDA needs on the integrated GPU,
+// those missing from the node are not injected.
+var tegraDeviceNodes = []string{
+	"/dev/nvhost-ctrl", "/dev/nvhost-ctrl-gpu", "/dev/nvhost-prof-gpu",
+	"/dev/nvhost-gpu", "/dev/nvhost-as-gpu", "/dev/nvhost-dbg-gpu",
+	"/dev/nvhost-tsg-gpu", "/dev/nvmap",
+}
+
+// tegraLoadFiles report the load of the integrated GPU in per mille, at the
+// path of Xavier and of Orin.
+var tegraLoadFiles = []string{"/sys/devices/gpu.0/load", "/sys/devices/platform/gpu.0/load"}
+
+// tegraReleasePattern matches the first line of tegraReleaseFile, e.g.
+// "# R35 (release), REVISION: 3.1, GCID: ...".
+var tegraReleasePattern = regexp.MustCompile(`^# R([0-9]+) \(release\), REVISION: ([0-9.]+)`)
+
+// tegraLibDir holds the driver libraries of the integrated GPU.
+var tegraLibDir = filepath.Join(libDir(), "tegra")
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// tegraBackend accesses the integrated GPU of Jetson (Tegra) devices, where
+// NVML is not available. The GPU shares the memory of the system, it can not
+// be health checked and its processes are not reported.
+type tegraBackend struct{}
+
+// NewTegraBackend returns the DeviceBackend of the integrated GPU of Jetson
+// devices.
+func NewTegraBackend() DeviceBackend {
+	return tegraBackend{}
+}
+
+// IsTegra reports whether the node is a Jetson (Tegra) device.
+func IsTegra() bool {
+	_, err := os.Stat(tegraReleaseFile)
+	return err == nil
+}
+
+// readDeviceTree returns the string property of the device tree at path,
+// without its trailing NUL.
+func readDeviceTree(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// readMeminfo returns the value of the field of /proc/meminfo in MiB.
+func readMeminfo(field string) (uint64, error) {
+	f, err := os.Open(procMeminfo)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == field+":" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb / 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s not found in %s", field, procMeminfo)
+}
+
+func (tegraBackend) Init() error {
+	if _, err := os.Stat(tegraGPUDevice); err != nil {
+		return fmt.Errorf("no Tegra integrated GPU found: %v", err)
+	}
+	return nil
+}
+
+func (tegraBackend) Shutdown() error {
+	return nil
+}
+
+// Discover returns the integrated GPU, identified by the serial number of the
+// device.
+func (tegraBackend) Discover() ([]GPU, error) {
+	serial := nonAlphanumeric.ReplaceAllString(readDeviceTree(deviceTreeSerial), "")
+	if serial == "" {
+		serial = "0"
+	}
+	gpu := GPU{Index: 0, UUID: "TEGRA-" + serial, Path: tegraGPUDevice, Model: readDeviceTree(deviceTreeModel)}
+	memory, err := readMeminfo("MemTotal")
+	if err != nil {
+		return nil, err
+	}
+	gpu.Memory = memory
+	return []GPU{gpu}, nil
+}
+
+// GetHealthEvents sends no event, the integrated GPU can not be health
+// checked.
+func (tegraBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (b tegraBackend) GetUtilization() (map[string]GPUUsage, error) {
+	gpus, err := b.Discover()
+	if err != nil {
+		return nil, err
+	}
+	var u GPUUsage
+	if u.MemoryFree, err = readMeminfo("MemAvailable"); err != nil {
+		return nil, err
+	}
+	for _, path := range tegraLoadFiles {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			load, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GPU load in %s: %v", path, err)
+			}
+			u.Utilization = uint(load / 10)
+			break
+		}
+	}
+	return map[string]GPUUsage{gpus[0].UUID: u}, nil
+}
+
+// GetProcesses reports no process, the driver does not tell the processes of
+// the integrated GPU.
+func (tegraBackend) GetProcesses() (map[string][]GPUProcess, error) {
+	return map[string][]GPUProcess{}, nil
+}
+
+// GetDriverInfo returns the L4T release, e.g. 35.3.1, as the driver version.
+func (tegraBackend) GetDriverInfo() (DriverInfo, error) {
+	b, err := ioutil.ReadFile(tegraReleaseFile)
+	if err != nil {
+		return DriverInfo{}, err
+	}
+	m := tegraReleasePattern.FindStringSubmatch(string(b))
+	if m == nil {
+		return DriverInfo{}, fmt.Errorf("unknown L4T release in %s", tegraReleaseFile)
+	}
+	return DriverInfo{Version: m[1] + "." + m[2]}, nil
+}
+
+func (tegraBackend) SetComputeMode(uuid, mode string) error {
+	return fmt.Errorf("compute modes are not supported by Tegra GPUs")
+}
+
+// ContainerEdits injects the device nodes of the integrated GPU and the
+// libraries of the driver at their host path, the container runtime of
+// Jetson devices does the rest when NVIDIA_VISIBLE_DEVICES is set.
+func (tegraBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	edits := ContainerEdits{
+		Envs: map[string]string{"NVIDIA_VISIBLE_DEVICES": "all"},
+	}
+	for _, path := range tegraDeviceNodes {
+		if _, err := os.Stat(path); err == nil {
+			edits.DeviceNodes = append(edits.DeviceNodes, path)
+		}
+	}
+	if _, err := os.Stat(tegraLibDir); err == nil {
+		edits.DriverDir, edits.DriverContainerDir = tegraLibDir, tegraLibDir
+	}
+	return edits
+}
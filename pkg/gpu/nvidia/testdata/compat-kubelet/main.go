@@ -0,0 +1,149 @@
+// Command compat-kubelet plays the kubelet side of the device plugin API with
+// the v1beta1 client generated by a given Kubernetes version: it serves the
+// Registration service, then calls the registered plugin as kubelet does and
+// checks the responses. TestPluginAPICompatibility builds it against
+// k8s.io/kubelet at several versions.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var (
+	dir      = flag.String("dir", "", "Directory holding the kubelet and device plugin sockets")
+	resource = flag.String("resource", "nvidia.com/gpu", "Resource the plugin is expected to register")
+	timeout  = flag.Duration("timeout", 30*time.Second, "Timeout of every step")
+)
+
+// reregistrationTimeout is how long a registration replacing a plugin that
+// failed is waited for.
+const reregistrationTimeout = 10 * time.Second
+
+type registration struct {
+	requests chan *pluginapi.RegisterRequest
+}
+
+func (r *registration) Register(ctx context.Context, req *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	r.requests <- req
+	return &pluginapi.Empty{}, nil
+}
+
+func check() error {
+	socket := filepath.Join(*dir, "kubelet.sock")
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	r := &registration{requests: make(chan *pluginapi.RegisterRequest, 16)}
+	pluginapi.RegisterRegistrationServer(server, r)
+	go server.Serve(l)
+	defer server.Stop()
+
+	var req *pluginapi.RegisterRequest
+	select {
+	case req = <-r.requests:
+	case <-time.After(*timeout):
+		return fmt.Errorf("no registration within %s", *timeout)
+	}
+	// As kubelet, switch to the plugin registered last, e.g. restarted when
+	// the kubelet socket was created.
+	for {
+		err := checkPlugin(req)
+		if err == nil {
+			return nil
+		}
+		select {
+		case req = <-r.requests:
+		case <-time.After(reregistrationTimeout):
+			return err
+		}
+	}
+}
+
+// checkPlugin calls the plugin registered with req as kubelet does and checks
+// the responses.
+func checkPlugin(req *pluginapi.RegisterRequest) error {
+	if req.Version != pluginapi.Version {
+		return fmt.Errorf("registered with API version %s, expected %s", req.Version, pluginapi.Version)
+	}
+	if req.ResourceName != *resource {
+		return fmt.Errorf("registered resource %s, expected %s", req.ResourceName, *resource)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, filepath.Join(*dir, req.Endpoint), grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := pluginapi.NewDevicePluginClient(conn)
+
+	options, err := client.GetDevicePluginOptions(ctx, &pluginapi.Empty{})
+	if err != nil {
+		return fmt.Errorf("GetDevicePluginOptions: %v", err)
+	}
+
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		return fmt.Errorf("ListAndWatch: %v", err)
+	}
+	devices, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("ListAndWatch: %v", err)
+	}
+	var healthy []string
+	for _, d := range devices.Devices {
+		if d.Health == pluginapi.Healthy {
+			healthy = append(healthy, d.ID)
+		}
+	}
+	if len(healthy) < 2 {
+		return fmt.Errorf("%d healthy devices advertised, at least 2 expected", len(healthy))
+	}
+
+	resp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: healthy[:2]}},
+	})
+	if err != nil {
+		return fmt.Errorf("Allocate: %v", err)
+	}
+	if len(resp.ContainerResponses) != 1 {
+		return fmt.Errorf("Allocate returned %d container responses, expected 1", len(resp.ContainerResponses))
+	}
+	if resp.ContainerResponses[0].Envs["NVIDIA_VISIBLE_DEVICES"] == "" {
+		return fmt.Errorf("Allocate did not set NVIDIA_VISIBLE_DEVICES")
+	}
+
+	if options.PreStartRequired {
+		if _, err := client.PreStartContainer(ctx, &pluginapi.PreStartContainerRequest{DevicesIDs: healthy[:2]}); err != nil {
+			return fmt.Errorf("PreStartContainer: %v", err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := check(); err != nil {
+		log.Fatalf("FAIL: %v", err)
+	}
+	log.Println("PASS")
+}
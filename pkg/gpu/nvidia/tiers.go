@@ -0,0 +1,132 @@
+package nvidia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+// tierServerSock is the socket of the device plugin of a tier, named after
+// the name part of its resource.
+const tierServerSock = "hkube-vgpu-tier-%s.sock"
+
+// Tier is a size of virtual GPU advertised as its own resource. A device of
+// the tier holds VGPUs consecutive virtual GPUs of a physical GPU, accounted
+// against the same capacity as the virtual GPUs of the other resources.
+type Tier struct {
+	// ResourceName is the resource of the tier, e.g. hkube.io/vgpu-small.
+	ResourceName string
+	// VGPUs is the number of virtual GPUs of a device of the tier.
+	VGPUs int
+}
+
+// ParseTiers parses tiers of the form "hkube.io/vgpu-small=1,hkube.io/vgpu-large=4".
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid tier %q, expected <resource>=<virtual GPUs>", f)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid virtual GPUs of tier %q: %v", f, err)
+		}
+		tiers = append(tiers, Tier{ResourceName: parts[0], VGPUs: n})
+	}
+	return tiers, nil
+}
+
+// tierSocket returns the socket name of the device plugin of the tier.
+func tierSocket(tier Tier) string {
+	name := tier.ResourceName[strings.LastIndex(tier.ResourceName, "/")+1:]
+	return fmt.Sprintf(tierServerSock, name)
+}
+
+// heldVGPUs returns the virtual GPUs held by the device id of the resource,
+// none when the resource is not advertised by the plugin. gpus are the
+// physical GPUs by ID.
+func (c Config) heldVGPUs(resource, id string, gpus map[string]GPU) []string {
+	for _, t := range c.Tiers {
+		if t.ResourceName == resource {
+			return deviceVGPUs(id, t.VGPUs)
+		}
+	}
+	if resource == c.MemoryResourceName && c.MemoryChunk > 0 {
+		return c.chunkVGPUs(id, gpus)
+	}
+	// The per-GPU and per-model resources are named hkube.io/<name>-vgpu.
+	if resource == resourceName || resource == c.ExclusiveResourceName && len(c.ExclusiveGPUs) > 0 ||
+		(resource == GuaranteedResourceName || resource == BestEffortResourceName) && c.GuaranteedPercent > 0 ||
+		strings.HasPrefix(resource, "hkube.io/") && strings.HasSuffix(resource, "-vgpu") {
+		return []string{id}
+	}
+	return nil
+}
+
+// getTierDevices returns the devices of the tier: every physical GPU holds as
+// many of them as its virtual GPUs can be split in. A device is named after
+// its first virtual GPU.
+func getTierDevices(gpus []GPU, config Config, tier Tier) []*pluginapi.Device {
+	var devs []*pluginapi.Device
+	for _, d := range gpus {
+		for j := 0; j+tier.VGPUs <= config.vGPUCount(d); j += tier.VGPUs {
+			devs = append(devs, &pluginapi.Device{
+				ID:     getVGPUID(config.gpuID(d), uint(j)),
+				Health: pluginapi.Healthy,
+			})
+		}
+	}
+	return devs
+}
+
+// deviceIndex splits the device id into its physical GPU and index.
+func deviceIndex(id string) (string, int, error) {
+	gpu := getPhysicalDeviceID(id)
+	if len(gpu) == len(id) {
+		return "", 0, fmt.Errorf("malformed device ID %q", id)
+	}
+	i, err := strconv.Atoi(id[len(gpu)+1:])
+	return gpu, i, err
+}
+
+// deviceVGPUs returns the n consecutive virtual GPUs held by the device id,
+// named after the first one.
+func deviceVGPUs(id string, n int) []string {
+	gpu, first, err := deviceIndex(id)
+	if n <= 1 || err != nil {
+		return []string{id}
+	}
+	vGPUs := make([]string, 0, n)
+	for j := first; j < first+n; j++ {
+		vGPUs = append(vGPUs, getVGPUID(gpu, uint(j)))
+	}
+	return vGPUs
+}
+
+// vGPUs returns the virtual GPUs held by the devices of the plugin.
+func (m *NvidiaDevicePlugin) vGPUs(ids []string) []string {
+	var vGPUs []string
+	for _, id := range ids {
+		vGPUs = append(vGPUs, m.config.heldVGPUs(m.resourceName, id, m.gpus)...)
+	}
+	return vGPUs
+}
+
+// containerVGPUs returns the virtual GPUs held by the devices of the
+// container, whatever the resource they were allocated through.
+func containerVGPUs(config Config, gpus map[string]GPU, c *podresourcesapi.ContainerResources) []string {
+	var vGPUs []string
+	for _, d := range c.Devices {
+		for _, id := range d.DeviceIds {
+			vGPUs = append(vGPUs, config.heldVGPUs(d.ResourceName, id, gpus)...)
+		}
+	}
+	return vGPUs
+}
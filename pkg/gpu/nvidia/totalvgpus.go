@@ -0,0 +1,72 @@
+package nvidia
+
+import (
+	"log"
+	"sort"
+)
+
+// distributeVGPUs returns the number of virtual GPUs of every shared physical
+// GPU, by UUID, so that the node exposes config.TotalVGPUs of them. They are
+// distributed in proportion to the memory of the GPUs, or evenly when the
+// memory of a GPU is unknown, the remainder going to the GPUs with the
+// largest fractions, then to the lowest indexes. Every shared GPU gets at
+// least one virtual GPU.
+func distributeVGPUs(gpus []GPU, config Config) map[string]int {
+	var shared []GPU
+	var memory uint64
+	known := true
+	for _, gpu := range gpus {
+		if config.exclusive(gpu) || config.GRIDPartitioning && gpu.VGPUProfile != "" {
+			continue
+		}
+		shared = append(shared, gpu)
+		memory += gpu.Memory
+		known = known && gpu.Memory > 0
+	}
+	if len(shared) == 0 {
+		return nil
+	}
+
+	weight := func(gpu GPU) uint64 {
+		if known {
+			return gpu.Memory
+		}
+		return 1
+	}
+	if !known {
+		memory = uint64(len(shared))
+	}
+
+	total := uint64(config.TotalVGPUs)
+	counts := make(map[string]int, len(shared))
+	remainders := make(map[string]uint64, len(shared))
+	left := config.TotalVGPUs
+	for _, gpu := range shared {
+		n := total * weight(gpu) / memory
+		counts[gpu.UUID] = int(n)
+		remainders[gpu.UUID] = total * weight(gpu) % memory
+		left -= int(n)
+	}
+
+	order := append([]GPU(nil), shared...)
+	sort.SliceStable(order, func(i, j int) bool {
+		if remainders[order[i].UUID] != remainders[order[j].UUID] {
+			return remainders[order[i].UUID] > remainders[order[j].UUID]
+		}
+		return order[i].Index < order[j].Index
+	})
+	for i := 0; left > 0; i = (i + 1) % len(order) {
+		counts[order[i].UUID]++
+		left--
+	}
+
+	for _, gpu := range shared {
+		if counts[gpu.UUID] == 0 {
+			counts[gpu.UUID] = 1
+		}
+	}
+	if len(shared) > config.TotalVGPUs {
+		log.Printf("Warning: %d virtual GPUs can not be distributed across %d shared GPUs, exposing one on every GPU.", config.TotalVGPUs, len(shared))
+	}
+	return counts
+}
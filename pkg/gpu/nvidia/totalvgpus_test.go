@@ -0,0 +1,37 @@
+package nvidia
+
+import "testing"
+
+func TestVGPUCountIsCappedByTheMPSClients(t *testing.T) {
+	config := testConfig()
+	config.VGPUCount = maxMPSClients + 1
+	if err := config.Validate(); err == nil {
+		t.Errorf("%d virtual GPUs per GPU accepted", config.VGPUCount)
+	}
+
+	// The count only applies without a total.
+	config.TotalVGPUs = 2 * maxMPSClients
+	if err := config.Validate(); err != nil {
+		t.Errorf("total virtual GPUs rejected: %v", err)
+	}
+}
+
+func TestDistributedVGPUsAreCappedByTheMPSClients(t *testing.T) {
+	config := testConfig()
+	config.TotalVGPUs = 2 * maxMPSClients
+	vgm := newTestManager(t, config, NewMockBackend(2))
+	for _, gpu := range vgm.gpus {
+		if n := vgm.config.vGPUCount(gpu); n != maxMPSClients {
+			t.Errorf("GPU %d exposes %d virtual GPUs, want %d", gpu.Index, n, maxMPSClients)
+		}
+	}
+
+	config.TotalVGPUs++
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := NewVirtualGPUManagerWithBackend(config, NewMockBackend(2)).discover()
+	if _, ok := err.(*invalidConfigError); !ok {
+		t.Errorf("%d virtual GPUs distributed across 2 GPUs, got %v, want an invalid configuration", config.TotalVGPUs, err)
+	}
+}
@@ -0,0 +1,141 @@
+package nvidia
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	utilizationInterval = 10 * time.Second
+
+	// overloadCondition is the node condition a descheduler policy can use to
+	// evict and re-place best-effort GPU pods.
+	overloadCondition v1.NodeConditionType = "GPUOverloaded"
+)
+
+var (
+	gpuUtilization = metrics.NewGaugeVec("vgpu_gpu_utilization_percent",
+		"GPU utilization of the physical GPU.", "uuid")
+	gpuOverloaded = metrics.NewGaugeVec("vgpu_gpu_overloaded",
+		"Whether the physical GPU stayed overloaded while another GPU of the node is idle.", "uuid")
+)
+
+// overloadDetector tracks for how long every physical GPU has been busy.
+type overloadDetector struct {
+	config Config
+	// busySince records when a GPU went above the overload threshold.
+	busySince map[string]time.Time
+}
+
+// update records the utilization of the GPUs and returns the GPUs that have
+// been overloaded for the configured period, and the idle GPUs.
+func (o *overloadDetector) update(utilization map[string]uint, now time.Time) (overloaded, idle []string) {
+	for uuid, u := range utilization {
+		if u < o.config.OverloadThreshold {
+			delete(o.busySince, uuid)
+		} else if _, ok := o.busySince[uuid]; !ok {
+			o.busySince[uuid] = now
+		}
+		if u <= o.config.IdleThreshold {
+			idle = append(idle, uuid)
+		}
+	}
+
+	if len(idle) == 0 {
+		return nil, nil
+	}
+	for uuid, since := range o.busySince {
+		if now.Sub(since) >= o.config.OverloadPeriod {
+			overloaded = append(overloaded, uuid)
+		}
+	}
+	return overloaded, idle
+}
+
+// getGPUUtilization returns the utilization percentage of every physical GPU.
+func (vgm *vGPUManager) getGPUUtilization() (map[string]uint, error) {
+	usage, err := vgm.backend.GetUtilization()
+	if err != nil {
+		return nil, err
+	}
+
+	utilization := make(map[string]uint, len(usage))
+	for uuid, u := range usage {
+		utilization[uuid] = u.Utilization
+	}
+	return utilization, nil
+}
+
+// watchOverload signals, through metrics and a node condition, physical GPUs
+// staying above the overload threshold while others are idle, until stop is
+// closed.
+func (vgm *vGPUManager) watchOverload(client kubernetes.Interface, stop <-chan struct{}) {
+	ticker := time.NewTicker(utilizationInterval)
+	defer ticker.Stop()
+
+	detector := &overloadDetector{config: vgm.config, busySince: make(map[string]time.Time)}
+	var lastStatus v1.ConditionStatus
+	var lastTransition metav1.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		utilization, err := vgm.getGPUUtilization()
+		if err != nil {
+			log.Printf("Failed to get GPU utilization: %v", err)
+			continue
+		}
+
+		overloaded, idle := detector.update(utilization, time.Now())
+		isOverloaded := make(map[string]bool)
+		for _, uuid := range overloaded {
+			isOverloaded[uuid] = true
+		}
+		for uuid, u := range utilization {
+			gpuUtilization.Set(float64(u), uuid)
+			if isOverloaded[uuid] {
+				gpuOverloaded.Set(1, uuid)
+			} else {
+				gpuOverloaded.Set(0, uuid)
+			}
+		}
+
+		condition := v1.NodeCondition{
+			Type:              overloadCondition,
+			Status:            v1.ConditionFalse,
+			Reason:            "GPUUtilizationBalanced",
+			Message:           "No GPU is overloaded while another one is idle",
+			LastHeartbeatTime: metav1.Now(),
+		}
+		if len(overloaded) > 0 {
+			condition.Status = v1.ConditionTrue
+			condition.Reason = "GPUUtilizationImbalanced"
+			condition.Message = fmt.Sprintf("GPUs %s are above %d%% utilization while GPUs %s are idle",
+				strings.Join(overloaded, ","), vgm.config.OverloadThreshold, strings.Join(idle, ","))
+		}
+		if condition.Status != lastStatus {
+			lastStatus = condition.Status
+			lastTransition = condition.LastHeartbeatTime
+			log.Printf("%s: %s", overloadCondition, condition.Message)
+		}
+		condition.LastTransitionTime = lastTransition
+
+		if client == nil {
+			continue
+		}
+		if err := kube.SetNodeCondition(client, vgm.config.NodeName, condition); err != nil {
+			log.Printf("Failed to set %s condition on node %s: %v", overloadCondition, vgm.config.NodeName, err)
+		}
+	}
+}
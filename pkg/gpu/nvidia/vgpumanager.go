@@ -1,47 +1,404 @@
 package nvidia
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"log"
 
-	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/audit"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvml"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/httpserver"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kube"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
 	"github.com/fsnotify/fsnotify"
+	"k8s.io/client-go/kubernetes"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 type vGPUManager struct {
-	vGPUCount int
+	config      Config
+	ledger      *allocationLedger
+	client      kubernetes.Interface
+	assignments *assignmentRecorder
+	backend     DeviceBackend
+
+	// computeModes is nil unless the compute modes are managed.
+	computeModes *computeModeManager
+	// budgets is nil unless budget files are mounted.
+	budgets *budgetWriter
+	// healthLog records the health of the physical GPUs and their last
+	// error.
+	healthLog *gpuHealthLog
+
+	// confidential reports whether the GPUs run in confidential computing
+	// mode, found on startup.
+	confidential bool
+
+	// gpus and devs are the physical and virtual GPUs found on startup.
+	gpus []GPU
+	devs []*pluginapi.Device
+	// kubeletAllocations are the allocations recorded by kubelet on
+	// startup, until the budget files are recovered.
+	kubeletAllocations []kubeletAllocation
+
+	// claimed is closed once the instance claimed the node, and handedOver
+	// once a newer instance took it over.
+	claimed    chan struct{}
+	handedOver chan struct{}
+
+	// policies admit the allocations, added by programs embedding the
+	// plugin.
+	policies []AllocationPolicy
+
+	// faults injects failures for testing, nil unless enabled.
+	faults *faultInjector
+
+	mu      sync.Mutex
+	plugins []*NvidiaDevicePlugin
+	// resourceConfigs override the configuration of the device plugins of
+	// their resource.
+	resourceConfigs map[string]ResourceConfig
+	// registered reports whether all the plugins are registered with
+	// kubelet.
+	registered bool
 }
 
 // NewVirtualGPUManager create a instance of vGPUManager
-func NewVirtualGPUManager(vGPUCount int) *vGPUManager {
-	return &vGPUManager{
-		vGPUCount: vGPUCount,
+func NewVirtualGPUManager(config Config) *vGPUManager {
+	return NewVirtualGPUManagerWithBackend(config, NewDeviceBackend(config))
+}
+
+// NewDeviceBackend returns the DeviceBackend selected by config: NVML, with
+// its nvidia-smi fallback, on emulated GPUs or not, the integrated GPU of
+// Tegra devices, and the WSL2 and GRID variants.
+func NewDeviceBackend(config Config) DeviceBackend {
+	if config.FakeGPUs > 0 {
+		log.Printf("Emulating %d GPUs.", config.FakeGPUs)
+		nvml.UseFake(config.FakeGPUs)
+	}
+	backend := NewNVMLBackendWithFallback()
+	if config.Tegra {
+		log.Println("Using the integrated GPU of the Tegra device.")
+		backend = NewTegraBackend()
+	}
+	if config.WSL {
+		log.Println("Using the GPUs of the WSL2 host through " + wslGPUDevice + ".")
+		backend = NewWSLBackend(backend)
 	}
+	if config.GRIDPartitioning {
+		backend = NewGRIDBackend(backend)
+	}
+	if config.MIGDevices {
+		backend = NewMIGBackend(backend)
+	}
+	return backend
 }
 
-func (vgm *vGPUManager) Run() error {
-	log.Println("Loading NVML")
-	if err := nvml.Init(); err != nil {
-		log.Printf("Failed to initialize NVML: %s.", err)
-		log.Printf("If this is a GPU node, did you set the docker default runtime to `nvidia`?")
+// NewVirtualGPUManagerWithBackend create a instance of vGPUManager accessing
+// the GPUs through backend, e.g. a MockBackend.
+func NewVirtualGPUManagerWithBackend(config Config, backend DeviceBackend) *vGPUManager {
+	if root := config.DriverRoot; root != "" {
+		config.DriverRoot = resolveDriverRoot(root)
+		if config.DriverRoot != root {
+			log.Printf("Driver libraries found in %s.", config.DriverRoot)
+		}
+	}
+	if len(config.DriverRoots) > 0 {
+		roots := make(map[int]string, len(config.DriverRoots))
+		for i, root := range config.DriverRoots {
+			roots[i] = resolveDriverRoot(root)
+			if roots[i] != root {
+				log.Printf("Driver libraries of GPU %d found in %s.", i, roots[i])
+			}
+		}
+		config.DriverRoots = roots
+	}
+	vgm := &vGPUManager{
+		config:     config,
+		ledger:     newAllocationLedger(),
+		backend:    backend,
+		healthLog:  newGPUHealthLog(),
+		claimed:    make(chan struct{}),
+		handedOver: make(chan struct{}),
+	}
+	if config.Handover {
+		vgm.config.instance = newInstanceID()
+	}
+	if config.FaultInjectionAddress != "" {
+		vgm.faults = &faultInjector{vgm: vgm}
+		vgm.backend = faultyBackend{DeviceBackend: backend, faults: vgm.faults}
+	}
+	return vgm
+}
 
-		log.Printf("You can check the prerequisites at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#prerequisites")
-		log.Printf("You can learn how to set the runtime at: https://github.com/awslabs/k8s-virtual-gpu#quick-start")
+// devicePlugins returns the device plugins currently served.
+func (vgm *vGPUManager) devicePlugins() []*NvidiaDevicePlugin {
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	return vgm.plugins
+}
 
-		select {}
+func (vgm *vGPUManager) setDevicePlugins(plugins []*NvidiaDevicePlugin) {
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	vgm.plugins = plugins
+	vgm.registered = false
+}
+
+// isRegistered reports whether all the plugins are registered with kubelet.
+func (vgm *vGPUManager) isRegistered() bool {
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	return vgm.registered
+}
+
+func (vgm *vGPUManager) setRegistered(registered bool) {
+	vgm.mu.Lock()
+	defer vgm.mu.Unlock()
+	vgm.registered = registered
+}
+
+// discover initializes the backend and enumerates the physical and virtual
+// GPUs.
+func (vgm *vGPUManager) discover() error {
+	if err := vgm.backend.Init(); err != nil {
+		return err
+	}
+	gpus, err := vgm.backend.Discover()
+	if err != nil {
+		return err
+	}
+	vgm.gpus = gpus
+	if info, err := vgm.backend.GetDriverInfo(); err == nil {
+		if info.OpenKernelModules {
+			log.Printf("Open kernel modules of driver %s loaded, %d IMEX channels found.", info.Version, len(imexChannels()))
+		}
+		if info.ConfidentialComputing {
+			log.Println("The GPUs run in confidential computing mode, their processes are not monitored.")
+			vgm.confidential = true
+		}
+	}
+	if vgm.config.CheckpointFile != "" {
+		vgm.loadCheckpoint(gpus)
+	} else if vgm.config.SequentialDeviceIDs {
+		log.Println("Warning: GPU indexes may change across reboots, the virtual GPU IDs of running pods are not kept without a checkpoint")
 	}
-	defer func() { log.Println("Shutdown of NVML returned:", nvml.Shutdown()) }()
+	if vgm.config.TotalVGPUs > 0 {
+		vgm.config.vgpuCounts = distributeVGPUs(gpus, vgm.config)
+		if err := vgm.config.validateVGPUCounts(); err != nil {
+			return &invalidConfigError{err}
+		}
+	}
+	vgm.seedFromKubelet()
+	vgm.devs = getVGPUDevices(gpus, vgm.config)
+	for _, i := range vgm.config.ExclusiveGPUs {
+		if i >= len(gpus) {
+			log.Printf("Warning: exclusive GPU %d not found, the node has %d GPUs.", i, len(gpus))
+		}
+	}
+	return nil
+}
 
-	log.Println("Fetching devices.")
-	if getDeviceCount() == 0 {
-		log.Println("No devices found. Waiting indefinitely.")
-		select {}
+// newDevicePlugins returns the device plugins to serve, one for every
+// advertised resource.
+func (vgm *vGPUManager) newDevicePlugins() []*NvidiaDevicePlugin {
+	devs := vgm.devs
+	plugins := []*NvidiaDevicePlugin{
+		NewNvidiaDevicePlugin(resourceName, vgm.config.pluginSocket(serverSock), devs, vgm.config, vgm.ledger),
+	}
+
+	if vgm.config.PerGPUResources {
+		store := newDeviceStore(devs)
+		for i, gpu := range vgm.gpus {
+			if vgm.config.exclusive(gpu) {
+				continue
+			}
+			plugins = append(plugins, NewNvidiaDevicePlugin(
+				fmt.Sprintf(perGPUResourceName, i),
+				vgm.config.pluginSocket(fmt.Sprintf(perGPUServerSock, i)),
+				store.devicesOf(vgm.config.gpuID(gpu)),
+				vgm.config, vgm.ledger))
+		}
+	}
+
+	if len(vgm.config.ExclusiveGPUs) > 0 {
+		plugins = append(plugins, NewNvidiaDevicePlugin(vgm.config.ExclusiveResourceName,
+			vgm.config.pluginSocket(exclusiveServerSock), getExclusiveDevices(vgm.gpus, vgm.config), vgm.config, vgm.ledger))
+	}
+
+	if vgm.config.MemoryChunk > 0 {
+		plugins = append(plugins, NewNvidiaDevicePlugin(vgm.config.MemoryResourceName,
+			vgm.config.pluginSocket(memoryServerSock), getMemoryDevices(vgm.gpus, vgm.config), vgm.config, vgm.ledger))
+	}
+
+	if vgm.config.ModelResources {
+		store := newDeviceStore(devs)
+		models, byModel := gpusByModel(vgm.gpus)
+		for _, model := range models {
+			var modelDevs []*pluginapi.Device
+			for _, gpu := range byModel[model] {
+				modelDevs = append(modelDevs, store.devicesOf(vgm.config.gpuID(gpu))...)
+			}
+			plugins = append(plugins, NewNvidiaDevicePlugin(
+				fmt.Sprintf(modelResourceName, model),
+				vgm.config.pluginSocket(fmt.Sprintf(modelServerSock, model)),
+				modelDevs, vgm.config, vgm.ledger))
+		}
+	}
+
+	if vgm.config.GuaranteedPercent > 0 {
+		plugins = append(plugins,
+			NewNvidiaDevicePlugin(GuaranteedResourceName, vgm.config.pluginSocket(guaranteedServerSock),
+				getClassDevices(vgm.gpus, vgm.config, true), vgm.config, vgm.ledger),
+			NewNvidiaDevicePlugin(BestEffortResourceName, vgm.config.pluginSocket(bestEffortServerSock),
+				getClassDevices(vgm.gpus, vgm.config, false), vgm.config, vgm.ledger))
+	}
+
+	for _, tier := range vgm.config.Tiers {
+		plugins = append(plugins, NewNvidiaDevicePlugin(tier.ResourceName, vgm.config.pluginSocket(tierSocket(tier)),
+			getTierDevices(vgm.gpus, vgm.config, tier), vgm.config, vgm.ledger))
+	}
+
+	gpus := vgm.gpusByID()
+	for _, p := range plugins {
+		vgm.attachDevicePlugin(p, gpus)
+	}
+
+	return plugins
+}
+
+// attachDevicePlugin shares the state of the manager with p, gpus being the
+// physical GPUs by ID.
+func (vgm *vGPUManager) attachDevicePlugin(p *NvidiaDevicePlugin, gpus map[string]GPU) {
+	p.config = vgm.resourceConfig(p.resourceName)
+	p.assignments = vgm.assignments
+	p.computeModes = vgm.computeModes
+	p.budgets = vgm.budgets
+	p.healthLog = vgm.healthLog
+	p.backend = vgm.backend
+	p.gpus = gpus
+	p.policies = vgm.policies
+	// Drained GPUs stay drained across restarts of the plugins.
+	for id, gpu := range gpus {
+		if s := vgm.healthLog.status(gpu.UUID); !s.Healthy && s.LastError == gpuDrained {
+			p.setGPUHealth(id, pluginapi.Unhealthy)
+		}
+	}
+	p.prepareTemplates()
+}
+
+// restartedDevicePlugin returns a new device plugin replacing the stopped p,
+// advertising the same devices, with their current health, and answering
+// the retries of the requests p answered.
+func (vgm *vGPUManager) restartedDevicePlugin(p *NvidiaDevicePlugin) *NvidiaDevicePlugin {
+	np := NewNvidiaDevicePlugin(p.resourceName, p.socket, nil, vgm.config, vgm.ledger)
+	np.devices = p.devices
+	np.allocations = p.allocations
+	vgm.attachDevicePlugin(np, p.gpus)
+	return np
+}
+
+// gpusByID returns the physical GPUs by their ID in the virtual GPU IDs.
+func (vgm *vGPUManager) gpusByID() map[string]GPU {
+	gpus := make(map[string]GPU, len(vgm.gpus))
+	for _, gpu := range vgm.gpus {
+		gpus[vgm.config.gpuID(gpu)] = gpu
+	}
+	return gpus
+}
+
+// gpuIDs maps the UUIDs of the physical GPUs to their ID in the virtual GPU
+// IDs.
+func (vgm *vGPUManager) gpuIDs() map[string]string {
+	ids := make(map[string]string, len(vgm.gpus))
+	for _, gpu := range vgm.gpus {
+		ids[gpu.UUID] = vgm.config.gpuID(gpu)
+	}
+	return ids
+}
+
+// kubeClient returns the Kubernetes client, creating it on first use.
+func (vgm *vGPUManager) kubeClient() (kubernetes.Interface, error) {
+	if vgm.client != nil {
+		return vgm.client, nil
+	}
+
+	client, err := kube.NewClient(vgm.config.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	vgm.client = client
+	return client, nil
+}
+
+// openAuditLog opens the configured audit log, if any, loading its signing
+// key.
+func (vgm *vGPUManager) openAuditLog() (*audit.Log, error) {
+	if vgm.config.AuditLog == "" {
+		return nil, nil
+	}
+
+	var key []byte
+	if vgm.config.AuditSigningKey != "" {
+		k, err := audit.LoadKey(vgm.config.AuditSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+
+	log.Printf("Writing allocation audit log to %s.", vgm.config.AuditLog)
+	return audit.Open(vgm.config.AuditLog, key)
+}
+
+// allocationWebhook returns the configured allocation webhook, if any,
+// loading its signing key.
+func (vgm *vGPUManager) allocationWebhook() (*allocationWebhook, error) {
+	if vgm.config.AllocationWebhook == "" {
+		return nil, nil
+	}
+
+	var key []byte
+	if vgm.config.AllocationWebhookKey != "" {
+		k, err := audit.LoadKey(vgm.config.AllocationWebhookKey)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+
+	log.Printf("Sending allocation events to %s.", vgm.config.AllocationWebhook)
+	return newAllocationWebhook(vgm.config.AllocationWebhook, key), nil
+}
+
+func (vgm *vGPUManager) Run() error {
+	if !nvml.Supported() && !vgm.config.Tegra && vgm.config.FakeGPUs == 0 {
+		log.Println("Warning: this binary is built without cgo (CGO_ENABLED=0) and can not use NVML. " +
+			"The GPUs are accessed through nvidia-smi, whose health checks only detect lost GPUs, not Xid and ECC errors. " +
+			"Build the plugin with cgo to use NVML.")
+	}
+
+	// Loading NVML and enumerating the GPUs is the slowest part of the
+	// startup, the rest of the setup runs meanwhile.
+	log.Println("Loading NVML and discovering devices.")
+	discovered := make(chan error, 1)
+	go func() { discovered <- vgm.discover() }()
+
+	log.Println("Checking capabilities and permissions")
+	reportCapabilities(vgm.config)
+	if err := verifyPermissions(vgm.config); err != nil {
+		return err
 	}
 
 	log.Println("Starting FS watcher.")
-	watcher, err := newFSWatcher(pluginapi.DevicePluginPath)
+	watcher, err := newFSWatcher(vgm.config.DevicePluginPath)
 	if err != nil {
 		log.Println("Failed to created FS watcher.")
 		return err
@@ -49,49 +406,378 @@ func (vgm *vGPUManager) Run() error {
 	defer watcher.Close()
 
 	log.Println("Starting OS watcher.")
-	sigs := newOSWatcher(syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	sigs := newOSWatcher(syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	if err := <-discovered; err != nil {
+		// The plugin restarts until the configuration is fixed.
+		if _, ok := err.(*invalidConfigError); ok {
+			vgm.backend.Shutdown()
+			return err
+		}
+		log.Printf("Failed to initialize NVML: %s.", err)
+		switch err {
+		case nvml.ErrUnavailable:
+			log.Printf("This binary was built without NVML support, use the release image or rebuild it with cgo enabled.")
+		case nvml.ErrLibraryNotFound:
+			log.Printf("If the driver is installed by a driver container, make its libnvidia-ml.so.1 visible to the plugin.")
+		default:
+			log.Printf("If this is a GPU node, did you set the docker default runtime to `nvidia`?")
+		}
 
-	restart := true
-	var devicePlugin *NvidiaDevicePlugin
+		log.Printf("You can check the prerequisites at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#prerequisites")
+		log.Printf("You can learn how to set the runtime at: https://github.com/awslabs/k8s-virtual-gpu#quick-start")
 
-L:
-	for {
-		if restart {
-			if devicePlugin != nil {
-				devicePlugin.Stop()
+		vgm.waitWithoutGPUs(sigs)
+		return nil
+	}
+	defer func() { log.Println("Shutdown of NVML returned:", vgm.backend.Shutdown()) }()
+
+	log.Printf("Found %d devices.", len(vgm.gpus))
+	if len(vgm.gpus) == 0 {
+		log.Println("No devices found. Waiting indefinitely.")
+		vgm.waitWithoutGPUs(sigs)
+		return nil
+	}
+
+	if vgm.config.NoGPUTaint != "" {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+		go vgm.setNoGPUTaint(client, false)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if vgm.config.MetricsAddress != "" {
+		log.Printf("Serving metrics on %s.", vgm.config.MetricsAddress)
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.DefaultRegistry)
+			err := httpserver.Serve(vgm.config.MetricsAddress,
+				vgm.config.MetricsTLSCertFile, vgm.config.MetricsTLSKeyFile, mux)
+			log.Printf("Metrics server stopped: %v", err)
+		}()
+	}
+
+	if vgm.config.NodeLabels {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		// Labeling waits for the API server, it must not delay the
+		// registration with kubelet.
+		log.Println("Labeling node with GPU features.")
+		go vgm.labelNode(client, stop)
+	}
+
+	if vgm.config.NFDFeaturesDir != "" {
+		log.Printf("Writing node features to %s.", vgm.config.NFDFeaturesDir)
+		go vgm.writeNFDFeatures(stop)
+	}
+
+	if vgm.config.PublishInventory {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Println("Starting GPU inventory publisher.")
+		go vgm.publishInventory(client, stop)
+	}
+
+	if vgm.config.PublishHealth {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Println("Starting GPU health publisher.")
+		go vgm.publishHealth(client, stop)
+	}
+
+	// reregister is notified of the resources whose node status drifted
+	// from the advertised devices.
+	reregister := make(chan []string, 1)
+	if vgm.config.DetectCapacityDrift {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Println("Starting capacity drift detection.")
+		go vgm.watchCapacityDrift(client, stop, reregister)
+	}
+
+	if vgm.config.WatchDrain {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Println("Starting GPU drain watcher.")
+		go vgm.watchDrain(client, stop)
+	}
+
+	if vgm.config.PublishGPUNode {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Println("Starting GPUNode publisher.")
+		go vgm.publishGPUNode(client, stop)
+	}
+
+	if vgm.config.HealthCondition {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Printf("Reporting the %s node condition.", healthCondition)
+		go vgm.reportHealthCondition(client, stop)
+	}
+
+	if vgm.config.StateNamespace != "" {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Printf("Publishing plugin state to namespace %s.", vgm.config.StateNamespace)
+		go vgm.publishState(client, stop)
+	}
+
+	if vgm.config.AnnotatePods || vgm.config.AuditLog != "" || vgm.config.AllocationWebhook != "" {
+		var client kubernetes.Interface
+		if vgm.config.AnnotatePods {
+			c, err := vgm.kubeClient()
+			if err != nil {
+				log.Println("Failed to create Kubernetes client.")
+				return err
 			}
+			client = c
+		}
+
+		auditLog, err := vgm.openAuditLog()
+		if err != nil {
+			log.Println("Failed to open audit log.")
+			return err
+		}
+		if auditLog != nil {
+			defer auditLog.Close()
+		}
+
+		webhook, err := vgm.allocationWebhook()
+		if err != nil {
+			log.Println("Failed to load allocation webhook signing key.")
+			return err
+		}
+		if webhook != nil {
+			go webhook.run(stop)
+			go webhook.watchReleases(stop)
+		}
+
+		log.Println("Starting GPU assignment recorder.")
+		vgm.assignments = newAssignmentRecorder(client, auditLog, webhook, vgm.config.NodeName)
+		go vgm.assignments.run(stop)
+	}
+
+	if vgm.confidential && vgm.config.MemoryQuotaEnforcement != MemoryQuotaNone {
+		log.Println("Warning: GPU memory quotas can not be enforced in confidential computing mode, the driver does not report the GPU processes.")
+	} else if vgm.config.MemoryQuotaEnforcement != MemoryQuotaNone {
+		client, err := vgm.kubeClient()
+		if err != nil {
+			log.Println("Failed to create Kubernetes client.")
+			return err
+		}
+
+		log.Printf("Starting GPU memory quota watcher, enforcing %s.", vgm.config.MemoryQuotaEnforcement)
+		go vgm.watchMemoryQuota(client, stop)
+	}
+
+	if vgm.confidential && vgm.config.VerifyDevicePolicy {
+		log.Println("Warning: the device policy can not be verified in confidential computing mode, the driver does not report the GPU processes.")
+	} else if vgm.config.VerifyDevicePolicy {
+		log.Println("Starting device policy verifier.")
+		go vgm.verifyDevicePolicy(stop)
+	}
+
+	if vgm.config.BudgetDir != "" {
+		if err := os.MkdirAll(vgm.config.BudgetDir, 0755); err != nil {
+			log.Println("Failed to create GPU budget directory.")
+			return err
+		}
+		vgm.budgets = newBudgetWriter(vgm.config.BudgetDir)
+	}
+
+	if vgm.config.ManageComputeMode {
+		log.Println("Starting GPU compute mode manager.")
+		vgm.computeModes = newComputeModeManager(vgm.config, vgm.backend, vgm.gpus)
+		go vgm.computeModes.run(stop)
+	}
+
+	switch vgm.config.Persistence {
+	case PersistenceMode:
+		log.Println("Enabling GPU persistence mode.")
+		vgm.enablePersistenceMode()
+	case PersistenceDaemon:
+		log.Println("Starting nvidia-persistenced supervisor.")
+		go vgm.supervisePersistenced(stop)
+	}
+
+	if vgm.config.reconcilesAllocations() {
+		log.Println("Starting allocation ledger reconciler.")
+		go vgm.reconcileLedger(stop)
+	}
+
+	if vgm.config.Handover {
+		log.Printf("Instance %s will hand over the node to newer instances.", vgm.config.instance)
+		go vgm.watchHandover(stop)
+	}
+
+	if vgm.config.FakeGPUs == 0 && len(nvswitchDevices()) > 0 {
+		log.Println("Starting NVLink fabric checks.")
+		go vgm.watchFabric(stop)
+	}
+
+	if vgm.config.GRIDPartitioning {
+		log.Println("Starting vGPU license checks.")
+		go vgm.watchGRIDLicenses(stop)
+	}
 
-			devicePlugin = NewNvidiaDevicePlugin(vgm.vGPUCount)
-			if err := devicePlugin.Serve(); err != nil {
-				log.Printf("You can check the prerequisites at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#prerequisites")
-				log.Printf("You can learn how to set the runtime at: https://github.com/awslabs/aws-virtual-gpu-device-plugin#quick-start")
-			} else {
-				restart = false
+	if vgm.config.CheckpointFile != "" {
+		log.Printf("Starting checkpoints to %s.", vgm.config.CheckpointFile)
+		go vgm.saveCheckpoints(stop)
+	}
+
+	if vgm.config.sharedAccounting() {
+		log.Println("Starting device reconciler.")
+		go vgm.reconcileDevices(stop)
+	}
+
+	if vgm.config.OverloadThreshold > 0 {
+		// Without a node name only the metrics are reported.
+		var client kubernetes.Interface
+		if vgm.config.NodeName != "" {
+			c, err := vgm.kubeClient()
+			if err != nil {
+				log.Println("Failed to create Kubernetes client.")
+				return err
 			}
+			client = c
+		}
+
+		log.Println("Starting GPU overload detector.")
+		go vgm.watchOverload(client, stop)
+	}
+
+	if vgm.faults != nil {
+		go vgm.faults.serve(vgm.config.FaultInjectionAddress)
+	}
+
+	var configEvents chan fsnotify.Event
+	var configErrors chan error
+	if vgm.config.ResourceConfigFile != "" {
+		if _, err := vgm.reloadResourceConfigs(); err != nil {
+			log.Println("Failed to load the resource configuration.")
+			return err
+		}
+		// ConfigMap volumes replace the file through a symbolic link, the
+		// changes of its directory are watched.
+		log.Printf("Watching the resource configuration %s.", vgm.config.ResourceConfigFile)
+		configWatcher, err := newFSWatcher(filepath.Dir(vgm.config.ResourceConfigFile))
+		if err != nil {
+			log.Println("Failed to watch the resource configuration.")
+			return err
 		}
+		defer configWatcher.Close()
+		configEvents, configErrors = configWatcher.Events, configWatcher.Errors
+	}
+
+	manager := newPluginManager(vgm)
+	manager.restartAll()
+	handover := vgm.handedOver
+	retry := time.NewTicker(pluginRetryInterval)
+	defer retry.Stop()
 
+	for {
 		select {
+		case <-handover:
+			// Kubelet already talks to the sockets of the new instance.
+			log.Println("Handed over the node, waiting to be stopped.")
+			manager.stopAll()
+			vgm.setRegistered(false)
+			handover = nil
+			manager = nil
+
 		case event := <-watcher.Events:
-			if event.Name == pluginapi.KubeletSocket && event.Op&fsnotify.Create == fsnotify.Create {
-				log.Printf("inotify: %s created, restarting.", pluginapi.KubeletSocket)
-				restart = true
+			if manager == nil {
+				continue
+			}
+			if event.Name == vgm.config.kubeletSocket() && event.Op&fsnotify.Create == fsnotify.Create {
+				log.Printf("inotify: %s created, restarting.", vgm.config.kubeletSocket())
+				manager.restartAll()
+			} else if event.Op&fsnotify.Remove == fsnotify.Remove {
+				manager.restartSocket(event.Name)
+			}
+
+		case resources := <-reregister:
+			if manager != nil {
+				manager.restartResources(resources)
+			}
+
+		case <-retry.C:
+			if manager != nil {
+				manager.retryFailed()
 			}
 
 		case err := <-watcher.Errors:
 			log.Printf("inotify: %s", err)
 
+		case <-configEvents:
+			changed, err := vgm.reloadResourceConfigs()
+			if err != nil {
+				log.Printf("Keeping the current resource configuration: %v", err)
+				continue
+			}
+			if len(changed) > 0 && manager != nil {
+				log.Printf("Configuration of %s changed, restarting.", strings.Join(changed, ", "))
+				manager.restartResources(changed)
+			}
+
+		case err := <-configErrors:
+			log.Printf("inotify: %s", err)
+
 		case s := <-sigs:
 			switch s {
 			case syscall.SIGHUP:
-				log.Println("Received SIGHUP, restarting.")
-				restart = true
+				if manager != nil {
+					log.Println("Received SIGHUP, restarting.")
+					manager.restartAll()
+				}
+			case syscall.SIGUSR1:
+				log.Println("Received SIGUSR1, dumping the state.")
+				vgm.dumpState()
 			default:
 				log.Printf("Received signal \"%v\", shutting down.", s)
-				devicePlugin.Stop()
-				break L
+				if manager != nil {
+					manager.stopAll()
+				}
+				return nil
 			}
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,266 @@
+package nvidia
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/metrics"
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+const (
+	// Types of the allocation events.
+	allocationEventAllocate = "allocate"
+	allocationEventRelease  = "release"
+
+	// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the body
+	// of an event, using the signing key, as sha256=<signature>.
+	webhookSignatureHeader = "X-Hkube-Signature"
+	// webhookEventHeader carries the type of the event.
+	webhookEventHeader = "X-Hkube-Event"
+
+	webhookTimeout = 10 * time.Second
+	// An event is delivered in at most webhookAttempts attempts, waiting
+	// webhookBackoff, doubled after every failed attempt, in between.
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	// webhookQueueSize bounds the events waiting for delivery, more are
+	// dropped.
+	webhookQueueSize = 1000
+)
+
+var webhookEvents = metrics.NewCounterVec("vgpu_allocation_webhook_events_total",
+	"Allocation events sent to the webhook, by result: \"delivered\", \"failed\" after every attempt, or \"dropped\" when the queue is full.", "result")
+
+// gpuShare is the share of a physical GPU allocated to a container.
+type gpuShare struct {
+	UUID  string `json:"uuid"`
+	Index int    `json:"index"`
+	// VGPUs are the virtual GPUs of the container out of the TotalVGPUs of
+	// the GPU.
+	VGPUs      int `json:"vgpus"`
+	TotalVGPUs int `json:"totalVGPUs"`
+	// MemoryMiB is the GPU memory of the container, unknown for GPUs without
+	// a memory size.
+	MemoryMiB uint64 `json:"memoryMiB,omitempty"`
+}
+
+// gpuShares returns the share of the physical GPUs gpus the devices ids hold.
+func (m *NvidiaDevicePlugin) gpuShares(gpus []string, ids []string) []gpuShare {
+	perGPU := make(map[string]int, len(gpus))
+	for _, id := range m.vGPUs(ids) {
+		perGPU[getPhysicalDeviceID(id)]++
+	}
+	memory := m.memoryBudgets(ids)
+
+	shares := make([]gpuShare, 0, len(gpus))
+	for _, id := range gpus {
+		gpu := m.gpus[id]
+		share := gpuShare{UUID: gpu.UUID, Index: gpu.Index, VGPUs: perGPU[id], TotalVGPUs: m.config.vGPUCount(gpu)}
+		if gpu.Memory > 0 {
+			share.MemoryMiB = memory[id]
+		}
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// allocationEvent is the allocation of virtual GPUs to a container, or their
+// release, sent to the webhook. Allocations whose pod could not be found are
+// sent without a pod.
+type allocationEvent struct {
+	Type      string     `json:"type"`
+	Time      time.Time  `json:"time"`
+	Node      string     `json:"node"`
+	Namespace string     `json:"namespace,omitempty"`
+	Pod       string     `json:"pod,omitempty"`
+	Container string     `json:"container,omitempty"`
+	Resource  string     `json:"resource"`
+	DeviceIDs []string   `json:"deviceIDs"`
+	GPUs      []gpuShare `json:"gpus"`
+}
+
+// allocationWebhook posts the allocation events, in order, to an HTTP
+// endpoint, e.g. of an accounting system, retrying failed deliveries.
+type allocationWebhook struct {
+	url    string
+	key    []byte
+	client *http.Client
+	events chan allocationEvent
+
+	sync.Mutex
+	// assigned are the allocated containers, by pod, container, resource and
+	// devices, whose release is sent once they are gone.
+	assigned map[string]allocationEvent
+}
+
+// newAllocationWebhook returns the webhook posting to url, signing the events
+// with key unless it is empty.
+func newAllocationWebhook(url string, key []byte) *allocationWebhook {
+	return &allocationWebhook{
+		url:      url,
+		key:      key,
+		client:   &http.Client{Timeout: webhookTimeout},
+		events:   make(chan allocationEvent, webhookQueueSize),
+		assigned: make(map[string]allocationEvent),
+	}
+}
+
+// send queues the event. It never blocks, events are dropped when the queue
+// is full.
+func (w *allocationWebhook) send(e allocationEvent) {
+	select {
+	case w.events <- e:
+	default:
+		webhookEvents.Inc("dropped")
+		log.Printf("Allocation webhook queue full, dropping the %s event of virtual GPUs %v", e.Type, e.DeviceIDs)
+	}
+}
+
+// allocated sends the allocation event and, when its container is known,
+// tracks the container to send its release. The allocation of a tracked
+// container, retried by kubelet, is only sent once.
+func (w *allocationWebhook) allocated(e allocationEvent) {
+	e.Type = allocationEventAllocate
+	if e.Pod != "" {
+		key := e.Namespace + "/" + e.Pod + "/" + e.Container + "/" + e.Resource + "/" + allocationFingerprint(e.DeviceIDs)
+		w.Lock()
+		_, sent := w.assigned[key]
+		w.assigned[key] = e
+		w.Unlock()
+		if sent {
+			return
+		}
+	}
+	w.send(e)
+}
+
+// released sends the release events of the tracked containers no longer
+// holding their devices, through their resource, in pods, the pod resources
+// reported by kubelet.
+func (w *allocationWebhook) released(pods []*podresourcesapi.PodResources) {
+	w.Lock()
+	var gone []allocationEvent
+	for key, e := range w.assigned {
+		if pod, container := findContainer(pods, e.Resource, e.DeviceIDs); pod != nil &&
+			pod.Namespace == e.Namespace && pod.Name == e.Pod && container.Name == e.Container {
+			continue
+		}
+		delete(w.assigned, key)
+		gone = append(gone, e)
+	}
+	w.Unlock()
+
+	for _, e := range gone {
+		e.Type = allocationEventRelease
+		e.Time = time.Now().UTC()
+		w.send(e)
+	}
+}
+
+// run delivers the queued events until stop is closed.
+func (w *allocationWebhook) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-w.events:
+			if err := w.deliver(e, stop); err != nil {
+				webhookEvents.Inc("failed")
+				log.Printf("Failed to deliver the %s event of virtual GPUs %v to the allocation webhook: %v", e.Type, e.DeviceIDs, err)
+				continue
+			}
+			webhookEvents.Inc("delivered")
+		}
+	}
+}
+
+// deliver posts the event, retrying network errors, throttling and server
+// errors with an exponential backoff.
+func (w *allocationWebhook) deliver(e allocationEvent, stop <-chan struct{}) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(e.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post posts the body of an event of the given type, and reports whether a
+// failure is worth retrying.
+func (w *allocationWebhook) post(eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, eventType)
+	if len(w.key) > 0 {
+		mac := hmac.New(sha256.New, w.key)
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook answered %s", resp.Status)
+}
+
+// watchReleases sends the release events of the containers gone, checked
+// every ledgerReconcileInterval, until stop is closed.
+func (w *allocationWebhook) watchReleases(stop <-chan struct{}) {
+	ticker := time.NewTicker(ledgerReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		pods, err := listPodResources()
+		if err != nil {
+			log.Printf("Failed to list pod resources: %v", err)
+			continue
+		}
+		w.released(pods)
+	}
+}
@@ -0,0 +1,78 @@
+package nvidia
+
+import (
+	"testing"
+	"time"
+
+	podresourcesapi "k8s.io/kubernetes/pkg/kubelet/apis/podresources/v1alpha1"
+)
+
+// queued returns the events waiting for delivery.
+func queued(w *allocationWebhook) []allocationEvent {
+	var events []allocationEvent
+	for {
+		select {
+		case e := <-w.events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestWebhookReleasesTheDevicesOfTheirResource(t *testing.T) {
+	w := newAllocationWebhook("http://webhook", nil)
+	shared := allocationEvent{Namespace: "default", Pod: "p", Container: "c", Resource: resourceName, DeviceIDs: []string{"0-0"}}
+	perGPU := shared
+	perGPU.Resource = "hkube.io/gpu-0-vgpu"
+	w.allocated(shared)
+	w.allocated(perGPU)
+	queued(w)
+
+	// The container still holds 0-0, only through the per GPU resource.
+	w.released([]*podresourcesapi.PodResources{
+		podHolding("p", map[string][]string{"hkube.io/gpu-0-vgpu": {"0-0"}}),
+	})
+	events := queued(w)
+	if len(events) != 1 || events[0].Type != allocationEventRelease || events[0].Resource != resourceName {
+		t.Fatalf("got events %+v, want the release of 0-0 through %s", events, resourceName)
+	}
+
+	w.released(nil)
+	events = queued(w)
+	if len(events) != 1 || events[0].Resource != "hkube.io/gpu-0-vgpu" {
+		t.Fatalf("got events %+v, want the release of 0-0 through hkube.io/gpu-0-vgpu", events)
+	}
+}
+
+func TestWebhookSendsOneAllocationPerRetriedRequest(t *testing.T) {
+	p := newRecordingPlugin(t)
+	p.assignments.webhook = newAllocationWebhook("http://webhook", nil)
+
+	allocate(t, p, "0-0")
+	allocate(t, p, "0-0")
+	close(p.assignments.pending)
+	for a := range p.assignments.pending {
+		p.assignments.notify(time.Now(), "default", "p", "c", a)
+	}
+	if events := queued(p.assignments.webhook); len(events) != 1 {
+		t.Errorf("got %d allocation events, want 1", len(events))
+	}
+}
+
+func TestWebhookSendsTheAllocationOfANewPodReusingTheDevices(t *testing.T) {
+	p := newRecordingPlugin(t)
+	p.assignments.webhook = newAllocationWebhook("http://webhook", nil)
+
+	allocate(t, p, "0-0")
+	allocate(t, p, "0-0")
+	close(p.assignments.pending)
+	pods := []string{"p", "q"}
+	for a := range p.assignments.pending {
+		p.assignments.notify(time.Now(), "default", pods[0], "c", a)
+		pods = pods[1:]
+	}
+	if events := queued(p.assignments.webhook); len(events) != 2 {
+		t.Errorf("got %d allocation events, want one per pod", len(events))
+	}
+}
@@ -0,0 +1,81 @@
+package nvidia
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// wslGPUDevice is the paravirtualized GPU device of WSL2, shared by every
+	// GPU of the Windows host.
+	wslGPUDevice = "/dev/dxg"
+	// wslLibDir holds the driver libraries the Windows host exposes to WSL2,
+	// e.g. libcuda.so and libnvidia-ml.so.
+	wslLibDir = "/usr/lib/wsl/lib"
+	// wslDriversDir is the driver store of the Windows host, the libraries of
+	// wslLibDir load the driver from there.
+	wslDriversDir = "/usr/lib/wsl/drivers"
+)
+
+// wslBackend accesses the GPUs of a WSL2 distribution, e.g. on a developer
+// laptop. NVML works through the libraries of the Windows host, but there are
+// no /dev/nvidia* device nodes: containers get the dxg device and the host
+// libraries instead.
+type wslBackend struct {
+	DeviceBackend
+}
+
+// NewWSLBackend returns the DeviceBackend of the GPUs discovered by backend
+// in a WSL2 distribution.
+func NewWSLBackend(backend DeviceBackend) DeviceBackend {
+	return wslBackend{DeviceBackend: backend}
+}
+
+// IsWSL reports whether the plugin runs in a WSL2 distribution with GPU
+// support.
+func IsWSL() bool {
+	if _, err := os.Stat(wslGPUDevice); err != nil {
+		return false
+	}
+	_, err := os.Stat(wslLibDir)
+	return err == nil
+}
+
+func (b wslBackend) Discover() ([]GPU, error) {
+	gpus, err := b.DeviceBackend.Discover()
+	if err != nil {
+		return nil, err
+	}
+	for i := range gpus {
+		gpus[i].Path = wslGPUDevice
+	}
+	return gpus, nil
+}
+
+// GetHealthEvents sends no event, NVML does not report the events of the GPUs
+// in WSL2.
+func (wslBackend) GetHealthEvents(ctx context.Context, uuids []string, events chan<- HealthEvent) error {
+	<-ctx.Done()
+	return nil
+}
+
+// ContainerEdits injects the dxg device and the libraries of the Windows host
+// at their path, where libcuda looks for them. The dxg device can not select
+// GPUs, the container sees every GPU of the host.
+func (wslBackend) ContainerEdits(ids []string, gpus []GPU) ContainerEdits {
+	edits := ContainerEdits{
+		Envs: map[string]string{
+			"NVIDIA_VISIBLE_DEVICES": strings.Join(ids, ","),
+			"LD_LIBRARY_PATH":        wslLibDir,
+		},
+		DeviceNodes:        []string{wslGPUDevice},
+		DriverDir:          wslLibDir,
+		DriverContainerDir: wslLibDir,
+	}
+	if _, err := os.Stat(wslDriversDir); err == nil {
+		edits.HostDirs = append(edits.HostDirs, wslDriversDir)
+	}
+	return edits
+}
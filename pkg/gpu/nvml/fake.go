@@ -0,0 +1,69 @@
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	fakeModel         = "Emulated GPU"
+	fakeMemory uint64 = 16384
+)
+
+var errFakeTimeout = errors.New("no event")
+
+// UseFake replaces NVML with count emulated GPUs. It must be called before
+// Init.
+func UseFake(count uint) {
+	current = fakeBackend{count: count}
+}
+
+// fakeBackend emulates idle GPUs which never report events.
+type fakeBackend struct {
+	count uint
+}
+
+// fakeUUID returns a UUID shaped like the NVML ones for the emulated GPU.
+func fakeUUID(idx uint) string {
+	return fmt.Sprintf("GPU-fa4e0000-0000-0000-0000-%012d", idx)
+}
+
+func (f fakeBackend) init() error                    { return nil }
+func (f fakeBackend) shutdown() error                { return nil }
+func (f fakeBackend) deviceCount() (uint, error)     { return f.count, nil }
+func (f fakeBackend) driverVersion() (string, error) { return "0.0.0", nil }
+
+func (f fakeBackend) cudaDriverVersion() (*uint, *uint, error) {
+	return nil, nil, nil
+}
+
+func (f fakeBackend) newDevice(idx uint) (*Device, error) {
+	if idx >= f.count {
+		return nil, fmt.Errorf("no emulated GPU at index %d", idx)
+	}
+	model, memory := fakeModel, fakeMemory
+	return &Device{
+		UUID:   fakeUUID(idx),
+		Path:   fmt.Sprintf("/dev/nvidia%d", idx),
+		Model:  &model,
+		Memory: &memory,
+	}, nil
+}
+
+func (f fakeBackend) status(d *Device) (*Status, error) {
+	free, utilization := fakeMemory, uint(0)
+	return &Status{MemoryFree: &free, Utilization: &utilization}, nil
+}
+
+// processes reports no process, emulated GPUs are never used.
+func (f fakeBackend) processes(d *Device) ([]Process, error) { return nil, nil }
+
+func (f fakeBackend) newEventSet() EventSet                     { return EventSet{} }
+func (f fakeBackend) deleteEventSet(es EventSet)                {}
+func (f fakeBackend) registerEvent(EventSet, int, string) error { return nil }
+
+func (f fakeBackend) waitForEvent(es EventSet, timeout uint) (Event, error) {
+	time.Sleep(time.Duration(timeout) * time.Millisecond)
+	return Event{}, errFakeTimeout
+}
@@ -0,0 +1,127 @@
+// Package nvml is the access layer of the device plugin to NVML.
+//
+// Built with cgo, it uses the NVML bindings, which load libnvidia-ml.so.1
+// with dlopen when Init is called: no CUDA toolkit or driver is needed at
+// build time, and the library is picked up from the driver installed on the
+// host or by a driver container. Built without cgo, the binary is fully
+// static and NVML is reported unavailable.
+//
+// UseFake replaces NVML with emulated GPUs, for clusters without NVIDIA
+// hardware.
+package nvml
+
+import "errors"
+
+const (
+	// DoubleBitEccError is the event type of double bit ECC errors.
+	DoubleBitEccError = 2
+	// XidCriticalError is the event type of critical Xid errors.
+	XidCriticalError = 8
+)
+
+var (
+	// ErrLibraryNotFound is returned by Init when libnvidia-ml.so.1 can not
+	// be loaded, e.g. when the driver is not installed yet.
+	ErrLibraryNotFound = errors.New("NVML library libnvidia-ml.so.1 not found")
+
+	// ErrUnavailable is returned by every function of a binary built
+	// without cgo.
+	ErrUnavailable = errors.New("NVML support not built in, rebuild with CGO_ENABLED=1")
+)
+
+// Device is a physical GPU. Model and Memory, in MiB, are nil when NVML does
+// not report them.
+type Device struct {
+	UUID   string
+	Path   string
+	Model  *string
+	Memory *uint64
+
+	// handle is the backend's own representation of the device.
+	handle interface{}
+}
+
+// Status is the current state of a physical GPU. MemoryFree is in MiB and
+// Utilization a percentage, both are nil when NVML does not report them.
+type Status struct {
+	MemoryFree  *uint64
+	Utilization *uint
+}
+
+// Process is a process using a GPU, MemoryUsed is in MiB.
+type Process struct {
+	PID        uint
+	MemoryUsed uint64
+}
+
+// Event is an NVML event. UUID is nil when the event concerns every device.
+type Event struct {
+	UUID  *string
+	Etype uint64
+	Edata uint64
+}
+
+// EventSet is a set of devices and events to wait for.
+type EventSet struct {
+	handle interface{}
+}
+
+// backend implements the functions of the package, either with NVML or with
+// emulated GPUs.
+type backend interface {
+	init() error
+	shutdown() error
+	deviceCount() (uint, error)
+	driverVersion() (string, error)
+	cudaDriverVersion() (*uint, *uint, error)
+	newDevice(idx uint) (*Device, error)
+	status(d *Device) (*Status, error)
+	processes(d *Device) ([]Process, error)
+	newEventSet() EventSet
+	deleteEventSet(es EventSet)
+	registerEvent(es EventSet, event int, uuid string) error
+	waitForEvent(es EventSet, timeout uint) (Event, error)
+}
+
+var current backend = driver{}
+
+// Init loads and initializes NVML.
+func Init() error { return current.init() }
+
+// Shutdown releases NVML.
+func Shutdown() error { return current.shutdown() }
+
+// GetDeviceCount returns the number of physical GPUs.
+func GetDeviceCount() (uint, error) { return current.deviceCount() }
+
+// GetDriverVersion returns the version of the installed driver.
+func GetDriverVersion() (string, error) { return current.driverVersion() }
+
+// GetCudaDriverVersion returns the CUDA version supported by the driver.
+func GetCudaDriverVersion() (*uint, *uint, error) { return current.cudaDriverVersion() }
+
+// NewDevice returns the physical GPU at index idx.
+func NewDevice(idx uint) (*Device, error) { return current.newDevice(idx) }
+
+// Status returns the current state of the device.
+func (d *Device) Status() (*Status, error) { return current.status(d) }
+
+// Processes returns the compute and graphics processes using the device.
+func (d *Device) Processes() ([]Process, error) { return current.processes(d) }
+
+// NewEventSet returns an empty event set.
+func NewEventSet() EventSet { return current.newEventSet() }
+
+// DeleteEventSet releases the event set.
+func DeleteEventSet(es EventSet) { current.deleteEventSet(es) }
+
+// RegisterEventForDevice adds the events of type event of the device with
+// the given UUID to the event set.
+func RegisterEventForDevice(es EventSet, event int, uuid string) error {
+	return current.registerEvent(es, event, uuid)
+}
+
+// WaitForEvent waits up to timeout milliseconds for an event of the set.
+func WaitForEvent(es EventSet, timeout uint) (Event, error) {
+	return current.waitForEvent(es, timeout)
+}
@@ -0,0 +1,85 @@
+//go:build cgo
+// +build cgo
+
+package nvml
+
+import (
+	"strings"
+
+	gonvml "github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+// Supported reports whether NVML support is built in.
+func Supported() bool { return true }
+
+// driver is the backend using the NVML library of the installed driver.
+type driver struct{}
+
+func (driver) init() error {
+	err := gonvml.Init()
+	if err != nil && strings.Contains(err.Error(), "could not load NVML library") {
+		return ErrLibraryNotFound
+	}
+	return err
+}
+
+func (driver) shutdown() error {
+	return gonvml.Shutdown()
+}
+
+func (driver) deviceCount() (uint, error) {
+	return gonvml.GetDeviceCount()
+}
+
+func (driver) driverVersion() (string, error) {
+	return gonvml.GetDriverVersion()
+}
+
+func (driver) cudaDriverVersion() (*uint, *uint, error) {
+	return gonvml.GetCudaDriverVersion()
+}
+
+func (driver) newDevice(idx uint) (*Device, error) {
+	d, err := gonvml.NewDevice(idx)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{UUID: d.UUID, Path: d.Path, Model: d.Model, Memory: d.Memory, handle: d}, nil
+}
+
+func (driver) status(d *Device) (*Status, error) {
+	s, err := d.handle.(*gonvml.Device).Status()
+	if err != nil {
+		return nil, err
+	}
+	return &Status{MemoryFree: s.Memory.Global.Free, Utilization: s.Utilization.GPU}, nil
+}
+
+func (driver) processes(d *Device) ([]Process, error) {
+	infos, err := d.handle.(*gonvml.Device).GetAllRunningProcesses()
+	if err != nil {
+		return nil, err
+	}
+	processes := make([]Process, 0, len(infos))
+	for _, p := range infos {
+		processes = append(processes, Process{PID: p.PID, MemoryUsed: p.MemoryUsed})
+	}
+	return processes, nil
+}
+
+func (driver) newEventSet() EventSet {
+	return EventSet{handle: gonvml.NewEventSet()}
+}
+
+func (driver) deleteEventSet(es EventSet) {
+	gonvml.DeleteEventSet(es.handle.(gonvml.EventSet))
+}
+
+func (driver) registerEvent(es EventSet, event int, uuid string) error {
+	return gonvml.RegisterEventForDevice(es.handle.(gonvml.EventSet), event, uuid)
+}
+
+func (driver) waitForEvent(es EventSet, timeout uint) (Event, error) {
+	e, err := gonvml.WaitForEvent(es.handle.(gonvml.EventSet), timeout)
+	return Event{UUID: e.UUID, Etype: e.Etype, Edata: e.Edata}, err
+}
@@ -0,0 +1,23 @@
+//go:build !cgo
+// +build !cgo
+
+package nvml
+
+// Supported reports whether NVML support is built in, it is not without cgo.
+func Supported() bool { return false }
+
+// driver is the backend of binaries built without cgo, NVML is unavailable.
+type driver struct{}
+
+func (driver) init() error                                { return ErrUnavailable }
+func (driver) shutdown() error                            { return ErrUnavailable }
+func (driver) deviceCount() (uint, error)                 { return 0, ErrUnavailable }
+func (driver) driverVersion() (string, error)             { return "", ErrUnavailable }
+func (driver) cudaDriverVersion() (*uint, *uint, error)   { return nil, nil, ErrUnavailable }
+func (driver) newDevice(idx uint) (*Device, error)        { return nil, ErrUnavailable }
+func (driver) status(d *Device) (*Status, error)          { return nil, ErrUnavailable }
+func (driver) processes(d *Device) ([]Process, error)     { return nil, ErrUnavailable }
+func (driver) newEventSet() EventSet                      { return EventSet{} }
+func (driver) deleteEventSet(es EventSet)                 {}
+func (driver) registerEvent(EventSet, int, string) error  { return ErrUnavailable }
+func (driver) waitForEvent(EventSet, uint) (Event, error) { return Event{}, ErrUnavailable }
@@ -0,0 +1,140 @@
+// Package httpserver serves the auxiliary HTTP endpoints, such as metrics, on
+// a TCP address or a unix socket, optionally over TLS.
+package httpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unixPrefix marks an address as the path of a unix socket.
+const unixPrefix = "unix:"
+
+// Listen listens on address, either a TCP address such as "localhost:9400"
+// or a unix socket path prefixed by "unix:". Unix sockets are only
+// accessible to their owner.
+func Listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixPrefix)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve serves handler on address. When certFile and keyFile are set the
+// connections use TLS, and the key pair is reloaded whenever the files change
+// so that rotated certificates are picked up without a restart.
+func Serve(address, certFile, keyFile string, handler http.Handler) error {
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("both a TLS certificate and a key are required")
+	}
+
+	l, err := Listen(address)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler}
+	if certFile == "" {
+		return server.Serve(l)
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	return server.ServeTLS(l, "", "")
+}
+
+// CertReloader keeps a TLS key pair up to date with the files it was loaded
+// from.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads the key pair from certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the most recent modification time of the key pair.
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *CertReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		log.Printf("Reloaded TLS certificate %s", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, reloading the key pair
+// when it changed on disk. The previous key pair keeps being served when the
+// new one cannot be loaded, e.g. while only one of the files was rotated.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.reload(); err != nil {
+		log.Printf("Failed to reload TLS certificate %s: %v", r.certFile, err)
+	}
+	return r.cert, nil
+}
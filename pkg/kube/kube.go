@@ -0,0 +1,218 @@
+// Package kube holds the small set of Kubernetes API helpers used by the
+// virtual GPU device plugin to publish node level information.
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewClient returns a Kubernetes client using the given kubeconfig, or the
+// in-cluster configuration when kubeconfig is empty.
+func NewClient(kubeconfig string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// PatchNodeAnnotations sets the given annotations on the node. A nil value
+// removes the annotation.
+func PatchNodeAnnotations(client kubernetes.Interface, nodeName string, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+	return err
+}
+
+// SetNodeCondition creates or updates the condition of the node, merged by
+// condition type.
+func SetNodeCondition(client kubernetes.Interface, nodeName string, condition v1.NodeCondition) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(nodeName, types.StrategicMergePatchType, patch, "status")
+	return err
+}
+
+// PatchNodeLabels sets the given labels on the node. A nil value removes the
+// label.
+func PatchNodeLabels(client kubernetes.Interface, nodeName string, labels map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": labels,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+	return err
+}
+
+// SetNodeTaint adds the taint to the node when present is true and removes
+// it otherwise, taints being identified by key and effect. The taints of the
+// node are replaced as a whole, guarded by its resource version, so the
+// update is retried when the node changed meanwhile.
+func SetNodeTaint(client kubernetes.Interface, nodeName string, taint v1.Taint, present bool) error {
+	for attempt := 0; ; attempt++ {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		taints := []v1.Taint{}
+		found, current := false, false
+		for _, t := range node.Spec.Taints {
+			if t.Key != taint.Key || t.Effect != taint.Effect {
+				taints = append(taints, t)
+				continue
+			}
+			found = true
+			current = current || t.Value == taint.Value
+		}
+		if present && current || !present && !found {
+			return nil
+		}
+		if present {
+			taints = append(taints, taint)
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": node.ResourceVersion},
+			"spec":     map[string]interface{}{"taints": taints},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+		if apierrors.IsConflict(err) && attempt < 3 {
+			continue
+		}
+		return err
+	}
+}
+
+// ApplyConfigMap creates the ConfigMap with the given labels and data, or
+// replaces the data of the existing one.
+func ApplyConfigMap(client kubernetes.Interface, namespace, name string, labels, data map[string]string) error {
+	configMaps := client.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Data:       data,
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = configMaps.Update(cm)
+	return err
+}
+
+// CustomObject is a cluster scoped custom resource holding its state in its
+// status, e.g. a GPUNode.
+type CustomObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Status            interface{} `json:"status,omitempty"`
+}
+
+// ApplyCustomObject creates the custom object in the collection at path, e.g.
+// /apis/hkube.io/v1alpha1/gpunodes, or replaces the existing one, keeping
+// its metadata.
+func ApplyCustomObject(client kubernetes.Interface, path string, obj *CustomObject) error {
+	rest := client.Discovery().RESTClient()
+	raw, err := rest.Get().AbsPath(path, obj.Name).DoRaw()
+	if apierrors.IsNotFound(err) {
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = rest.Post().AbsPath(path).Body(body).DoRaw()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var current CustomObject
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return err
+	}
+	current.Status = obj.Status
+	body, err := json.Marshal(&current)
+	if err != nil {
+		return err
+	}
+	_, err = rest.Put().AbsPath(path, obj.Name).Body(body).DoRaw()
+	return err
+}
+
+// RecordPodEvent creates an event of the given type, e.g. v1.EventTypeWarning,
+// about the pod, reported by component on the node.
+func RecordPodEvent(client kubernetes.Interface, pod *v1.Pod, component, nodeName, eventType, reason, message string) error {
+	now := metav1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", pod.Name, now.UnixNano()),
+			Namespace: pod.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Pod",
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			APIVersion:      "v1",
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: component, Host: nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	_, err := client.CoreV1().Events(pod.Namespace).Create(event)
+	return err
+}
+
+// EvictPod evicts the pod through the eviction API, which honors its
+// PodDisruptionBudgets.
+func EvictPod(client kubernetes.Interface, namespace, name string) error {
+	return client.CoreV1().Pods(namespace).Evict(&policy.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+	})
+}
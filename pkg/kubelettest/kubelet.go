@@ -0,0 +1,107 @@
+// Package kubelettest provides a fake kubelet serving the device plugin
+// Registration service on a unix socket, and connecting back to the
+// registered plugins as kubelet does. It lets Register, ListAndWatch and
+// Allocate flows, re-registration included, run without a node: start the
+// plugin with its device plugin path set to Kubelet.Dir.
+package kubelettest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// Kubelet is a fake kubelet. Its registration socket is Dir/kubelet.sock.
+type Kubelet struct {
+	// Dir holds the kubelet and device plugin sockets.
+	Dir string
+
+	server        *grpc.Server
+	registrations chan *pluginapi.RegisterRequest
+}
+
+// New starts a fake kubelet in dir, which must exist.
+func New(dir string) (*Kubelet, error) {
+	k := &Kubelet{
+		Dir:           dir,
+		registrations: make(chan *pluginapi.RegisterRequest, 16),
+	}
+	if err := k.start(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Socket returns the path of the registration socket.
+func (k *Kubelet) Socket() string {
+	return filepath.Join(k.Dir, "kubelet.sock")
+}
+
+func (k *Kubelet) start() error {
+	if err := os.Remove(k.Socket()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", k.Socket())
+	if err != nil {
+		return err
+	}
+
+	k.server = grpc.NewServer()
+	pluginapi.RegisterRegistrationServer(k.server, k)
+	go k.server.Serve(l)
+	return nil
+}
+
+// Register implements the Registration service.
+func (k *Kubelet) Register(ctx context.Context, req *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	if req.Version != pluginapi.Version {
+		return nil, fmt.Errorf("unsupported device plugin API version %s", req.Version)
+	}
+	k.registrations <- req
+	return &pluginapi.Empty{}, nil
+}
+
+// WaitForRegistration returns the next registration request, or an error
+// when none is received within timeout.
+func (k *Kubelet) WaitForRegistration(timeout time.Duration) (*pluginapi.RegisterRequest, error) {
+	select {
+	case req := <-k.registrations:
+		return req, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no registration within %s", timeout)
+	}
+}
+
+// Dial connects to the device plugin registered with the given endpoint, a
+// socket name relative to Dir. The connection must be closed by the caller.
+func (k *Kubelet) Dial(ctx context.Context, endpoint string) (pluginapi.DevicePluginClient, *grpc.ClientConn, error) {
+	conn, err := grpc.DialContext(ctx, filepath.Join(k.Dir, endpoint), grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pluginapi.NewDevicePluginClient(conn), conn, nil
+}
+
+// Restart stops the fake kubelet and starts it again, recreating its socket
+// as kubelet does on restart. Plugins are expected to register again.
+func (k *Kubelet) Restart() error {
+	k.server.Stop()
+	return k.start()
+}
+
+// Stop stops the fake kubelet and removes its socket.
+func (k *Kubelet) Stop() {
+	k.server.Stop()
+	os.Remove(k.Socket())
+}
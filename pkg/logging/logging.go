@@ -0,0 +1,116 @@
+// Package logging routes the log of the plugin, written with the standard
+// log package, to the console and to a rotating file, as text or as JSON
+// lines for node log collectors.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatText writes the log as the standard log package does.
+	FormatText = "text"
+	// FormatJSON writes every entry as a JSON object on its own line.
+	FormatJSON = "json"
+
+	// ConsoleStderr, ConsoleStdout and ConsoleNone select the console
+	// output of the log.
+	ConsoleStderr = "stderr"
+	ConsoleStdout = "stdout"
+	ConsoleNone   = "none"
+)
+
+// Options configure the output of the log.
+type Options struct {
+	// Format is FormatText or FormatJSON.
+	Format string
+	// Console is ConsoleStderr, ConsoleStdout or ConsoleNone.
+	Console string
+	// File is the path of a file the log is also written to, rotated once
+	// it exceeds MaxSize bytes. Rotated files older than MaxAge, or beyond
+	// the MaxBackups most recent ones, are removed; zero keeps them.
+	File       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// Setup sets the output of the standard logger according to opts. The
+// returned Closer closes the log file, if any.
+func Setup(opts Options) (io.Closer, error) {
+	var writers []io.Writer
+	switch opts.Console {
+	case ConsoleStderr, "":
+		writers = append(writers, os.Stderr)
+	case ConsoleStdout:
+		writers = append(writers, os.Stdout)
+	case ConsoleNone:
+	default:
+		return nil, fmt.Errorf("invalid console output %q, expected %q, %q or %q", opts.Console, ConsoleStderr, ConsoleStdout, ConsoleNone)
+	}
+
+	var closer io.Closer = ioutil.NopCloser(nil)
+	if opts.File != "" {
+		f, err := newRotatingFile(opts.File, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, f)
+		closer = f
+	}
+
+	w := io.MultiWriter(writers...)
+	switch opts.Format {
+	case FormatText, "":
+		log.SetOutput(w)
+	case FormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(&jsonWriter{w: w})
+	default:
+		closer.Close()
+		return nil, fmt.Errorf("invalid log format %q, expected %q or %q", opts.Format, FormatText, FormatJSON)
+	}
+	return closer, nil
+}
+
+// jsonEntry is a log entry in the JSON format.
+type jsonEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// jsonWriter writes every entry of the standard logger, without prefix nor
+// flags, as a JSON line. Entries starting with "Warning:" get the warning
+// level, the others the info level.
+type jsonWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := "info"
+	if strings.HasPrefix(msg, "Warning:") {
+		level = "warning"
+	}
+	b, err := json.Marshal(jsonEntry{Time: time.Now().UTC().Format(time.RFC3339Nano), Level: level, Msg: msg})
+	if err != nil {
+		return 0, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
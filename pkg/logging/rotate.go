@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat suffixes the path of a rotated log file, sorting rotated
+// files by age.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// rotatingFile is a log file renamed to path.<time> once it exceeds maxSize
+// bytes, the rotated files older than maxAge or beyond the maxBackups most
+// recent ones being removed.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+// open opens the log file for appending.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log file and opens a new one.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	rotated := r.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes the rotated files older than maxAge and beyond the
+// maxBackups most recent ones. Failures are ignored, the next rotation
+// retries.
+func (r *rotatingFile) prune() {
+	rotated, _ := filepath.Glob(r.path + ".*")
+	var backups []string
+	for _, path := range rotated {
+		suffix := strings.TrimPrefix(path, r.path+".")
+		t, err := time.Parse(rotatedTimeFormat, suffix)
+		if err != nil {
+			continue
+		}
+		if r.maxAge > 0 && time.Since(t) > r.maxAge {
+			os.Remove(path)
+			continue
+		}
+		backups = append(backups, path)
+	}
+	if r.maxBackups <= 0 || len(backups) <= r.maxBackups {
+		return
+	}
+	// The time suffix sorts the oldest files first.
+	sort.Strings(backups)
+	for _, path := range backups[:len(backups)-r.maxBackups] {
+		os.Remove(path)
+	}
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,179 @@
+// Package metrics is a minimal registry of gauges and counters exposed in the
+// Prometheus text format, which keeps the device plugin free of a metrics
+// client dependency.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// Registry holds metric families and serves them over HTTP.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*Vec
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*Vec)}
+}
+
+// DefaultRegistry is the registry served by the device plugin.
+var DefaultRegistry = NewRegistry()
+
+// Vec is a metric family partitioned by label values.
+type Vec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) register(name, help, typ string, labels []string) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.families[name]; ok {
+		return v
+	}
+	v := &Vec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+	r.families[name] = v
+	return v
+}
+
+// NewGaugeVec registers a gauge family with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *Vec {
+	return r.register(name, help, typeGauge, labels)
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *Vec {
+	return r.register(name, help, typeCounter, labels)
+}
+
+// NewGaugeVec registers a gauge family in the default registry.
+func NewGaugeVec(name, help string, labels ...string) *Vec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewCounterVec registers a counter family in the default registry.
+func NewCounterVec(name, help string, labels ...string) *Vec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+func (v *Vec) get(labelValues []string) *sample {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.samples[key] = s
+	}
+	return s
+}
+
+// Set sets the value of the sample with the given label values.
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value = value
+}
+
+// Add adds delta to the value of the sample with the given label values.
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value += delta
+}
+
+// Inc increments the sample with the given label values.
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Delete removes the sample with the given label values.
+func (v *Vec) Delete(labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.samples, strings.Join(labelValues, "\xff"))
+}
+
+// Reset removes every sample of the family.
+func (v *Vec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.samples = make(map[string]*sample)
+}
+
+func (v *Vec) write(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", v.name, v.typ)
+
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.samples[k]
+		b.WriteString(v.name)
+		if len(v.labels) > 0 {
+			pairs := make([]string, len(v.labels))
+			for i, l := range v.labels {
+				pairs[i] = fmt.Sprintf("%s=%q", l, s.labelValues[i])
+			}
+			b.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		fmt.Fprintf(b, " %g\n", s.value)
+	}
+}
+
+// ServeHTTP writes every metric family in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for n := range r.families {
+		names = append(names, n)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, n := range names {
+		r.mu.Lock()
+		v := r.families[n]
+		r.mu.Unlock()
+		v.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,120 @@
+// Package extender implements a scheduler extender that filters and
+// prioritizes nodes using the per-GPU inventory published by the virtual GPU
+// device plugin, so that pods are not sent to nodes whose physical GPUs are
+// saturated even though the node still advertises free virtual GPUs.
+package extender
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/scheduler"
+	v1 "k8s.io/api/core/v1"
+)
+
+// MaxPriority is the highest score an extender may give to a node.
+const MaxPriority = 10
+
+// Extender filters and prioritizes nodes for pods requesting virtual GPUs.
+type Extender struct {
+	// ResourceName is the resource advertised by the device plugin.
+	ResourceName v1.ResourceName
+	// SingleGPU requires every container to fit on a single physical GPU.
+	SingleGPU bool
+}
+
+// fits checks whether the pod's containers can be placed on the node. Nodes
+// without a published inventory are accepted.
+func (e *Extender) fits(node *v1.Node, reqs []int64) error {
+	inv, err := inventory.Parse(node.Annotations)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return nil
+	}
+	return scheduler.Fits(inv, reqs, e.SingleGPU)
+}
+
+// Filter removes the nodes whose physical GPUs can not hold the pod.
+func (e *Extender) Filter(args *ExtenderArgs) *ExtenderFilterResult {
+	if args.Nodes == nil {
+		return &ExtenderFilterResult{Error: "extender must be configured with nodeCacheCapable: false"}
+	}
+
+	reqs := scheduler.ContainerRequests(args.Pod, e.ResourceName)
+	if len(reqs) == 0 {
+		return &ExtenderFilterResult{Nodes: args.Nodes}
+	}
+
+	result := &ExtenderFilterResult{
+		Nodes:       &v1.NodeList{},
+		FailedNodes: FailedNodesMap{},
+	}
+	for _, node := range args.Nodes.Items {
+		if err := e.fits(&node, reqs); err != nil {
+			result.FailedNodes[node.Name] = err.Error()
+			continue
+		}
+		result.Nodes.Items = append(result.Nodes.Items, node)
+	}
+	return result
+}
+
+// Prioritize favors the nodes with the least loaded physical GPU, spreading
+// virtual GPUs across physical GPUs.
+func (e *Extender) Prioritize(args *ExtenderArgs) HostPriorityList {
+	if args.Nodes == nil {
+		return HostPriorityList{}
+	}
+
+	priorities := make(HostPriorityList, 0, len(args.Nodes.Items))
+	for _, node := range args.Nodes.Items {
+		var score int64
+		inv, err := inventory.Parse(node.Annotations)
+		if err == nil && inv != nil {
+			for _, g := range inv.GPUs {
+				if g.TotalVGPUs == 0 {
+					continue
+				}
+				s := int64(MaxPriority * g.FreeVGPUs() / g.TotalVGPUs)
+				if s > score {
+					score = s
+				}
+			}
+		}
+		priorities = append(priorities, HostPriority{Host: node.Name, Score: score})
+	}
+	return priorities
+}
+
+// Handler returns the HTTP handler serving the filter and prioritize verbs.
+func (e *Extender) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter", func(w http.ResponseWriter, r *http.Request) {
+		var args ExtenderArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			writeJSON(w, &ExtenderFilterResult{Error: err.Error()})
+			return
+		}
+		writeJSON(w, e.Filter(&args))
+	})
+	mux.HandleFunc("/prioritize", func(w http.ResponseWriter, r *http.Request) {
+		var args ExtenderArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, e.Prioritize(&args))
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package extender
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// The types below mirror the scheduler extender wire format of
+// k8s.io/kubernetes/pkg/scheduler/api/v1.
+
+// ExtenderArgs represents the arguments needed by the extender to filter or
+// prioritize nodes for a pod.
+type ExtenderArgs struct {
+	Pod       *v1.Pod
+	Nodes     *v1.NodeList
+	NodeNames *[]string
+}
+
+// FailedNodesMap represents the filtered out nodes, with node names and failure messages
+type FailedNodesMap map[string]string
+
+// ExtenderFilterResult represents the results of a filter call to an extender
+type ExtenderFilterResult struct {
+	Nodes       *v1.NodeList
+	NodeNames   *[]string
+	FailedNodes FailedNodesMap
+	Error       string
+}
+
+// HostPriority represents the priority of scheduling to a particular host
+type HostPriority struct {
+	Host  string
+	Score int64
+}
+
+// HostPriorityList declares a []HostPriority type.
+type HostPriorityList []HostPriority
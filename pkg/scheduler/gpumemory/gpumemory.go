@@ -0,0 +1,96 @@
+// Package gpumemory implements a scheduler framework plugin placing pods that
+// request virtual GPUs on the node whose shared physical GPUs have the most
+// free memory, according to the inventory published by the device plugin.
+package gpumemory
+
+import (
+	"fmt"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/scheduler"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// Name is the name of the plugin used in the scheduler configuration.
+const Name = "GPUMemory"
+
+// ResourceName is the resource advertised by the virtual GPU device plugin.
+var ResourceName v1.ResourceName = "nvidia.com/gpu"
+
+// GPUMemory is a Filter and Score plugin for virtual GPU requests.
+type GPUMemory struct {
+	handle framework.FrameworkHandle
+}
+
+var _ framework.FilterPlugin = &GPUMemory{}
+var _ framework.ScorePlugin = &GPUMemory{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, h framework.FrameworkHandle) (framework.Plugin, error) {
+	return &GPUMemory{handle: h}, nil
+}
+
+// Name returns name of the plugin.
+func (g *GPUMemory) Name() string {
+	return Name
+}
+
+// nodeInventory returns the GPU inventory published on the node, or nil when
+// there is none.
+func (g *GPUMemory) nodeInventory(nodeName string) (*inventory.Node, error) {
+	nodeInfo, ok := g.handle.NodeInfoSnapshot().NodeInfoMap[nodeName]
+	if !ok || nodeInfo.Node() == nil {
+		return nil, fmt.Errorf("node %s not found", nodeName)
+	}
+	return inventory.Parse(nodeInfo.Node().Annotations)
+}
+
+// Filter rejects the nodes whose physical GPUs can not hold the pod.
+func (g *GPUMemory) Filter(pc *framework.PluginContext, pod *v1.Pod, nodeName string) *framework.Status {
+	reqs := scheduler.ContainerRequests(pod, ResourceName)
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	inv, err := g.nodeInventory(nodeName)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if inv == nil {
+		return nil
+	}
+	if err := scheduler.Fits(inv, reqs, true); err != nil {
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	return nil
+}
+
+// Score ranks the node by the free memory share of its least loaded physical
+// GPU that still has free virtual GPUs.
+func (g *GPUMemory) Score(pc *framework.PluginContext, pod *v1.Pod, nodeName string) (int, *framework.Status) {
+	if len(scheduler.ContainerRequests(pod, ResourceName)) == 0 {
+		return 0, nil
+	}
+
+	inv, err := g.nodeInventory(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	if inv == nil {
+		return 0, nil
+	}
+
+	score := 0
+	for _, gpu := range inv.GPUs {
+		if gpu.FreeVGPUs() == 0 || gpu.MemoryTotal == 0 {
+			continue
+		}
+		s := int(uint64(framework.MaxNodeScore) * gpu.MemoryFree / gpu.MemoryTotal)
+		if s > score {
+			score = s
+		}
+	}
+	return score, nil
+}
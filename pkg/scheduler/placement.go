@@ -0,0 +1,46 @@
+// Package scheduler holds the placement logic shared by the scheduler
+// integrations of the virtual GPU device plugin.
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/inventory"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ContainerRequests returns the number of virtual GPUs requested by every
+// container of the pod that requests some.
+func ContainerRequests(pod *v1.Pod, resourceName v1.ResourceName) []int64 {
+	var reqs []int64
+	for _, c := range pod.Spec.Containers {
+		q, ok := c.Resources.Limits[resourceName]
+		if !ok {
+			q, ok = c.Resources.Requests[resourceName]
+		}
+		if !ok {
+			continue
+		}
+		if n := q.Value(); n > 0 {
+			reqs = append(reqs, n)
+		}
+	}
+	return reqs
+}
+
+// Fits checks whether containers requesting reqs virtual GPUs can be placed
+// on a node with the given inventory. When singleGPU is set every container
+// must fit on one physical GPU.
+func Fits(inv *inventory.Node, reqs []int64, singleGPU bool) error {
+	var total int64
+	for _, r := range reqs {
+		total += r
+		if singleGPU && r > int64(inv.MaxFreeVGPUs()) {
+			return fmt.Errorf("no physical GPU has %d free virtual GPUs", r)
+		}
+	}
+	if total > int64(inv.FreeVGPUs()) {
+		return fmt.Errorf("physical GPUs are saturated: %d virtual GPUs free, %d requested", inv.FreeVGPUs(), total)
+	}
+	return nil
+}
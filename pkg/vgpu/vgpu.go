@@ -0,0 +1,85 @@
+// Package vgpu is the API for programs embedding the virtual GPU device
+// plugin, e.g. other kube-HPC components, without forking it: they run the
+// plugin with their own Options and extend it with their own Backend, to
+// access the GPUs, and AllocationPolicy, to admit the allocations.
+//
+// The package follows semantic versioning, tracked by APIVersion: the
+// identifiers it exports are only changed incompatibly with a new major
+// version. The packages under pkg/gpu are internal to the plugin and change
+// without notice, embedders only use them through the aliases of this
+// package.
+package vgpu
+
+import (
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvidia"
+)
+
+// APIVersion is the semantic version of the API of this package.
+const APIVersion = "1.0.0"
+
+// Config configures the plugin, as the command line flags of the plugin do.
+type Config = nvidia.Config
+
+// Backend accesses the GPUs of the node.
+type Backend = nvidia.DeviceBackend
+
+// Types exchanged with a Backend.
+type (
+	GPU            = nvidia.GPU
+	GPUUsage       = nvidia.GPUUsage
+	GPUProcess     = nvidia.GPUProcess
+	HealthEvent    = nvidia.HealthEvent
+	DriverInfo     = nvidia.DriverInfo
+	ContainerEdits = nvidia.ContainerEdits
+)
+
+// AllocationPolicy admits the allocations of virtual GPUs to containers.
+type AllocationPolicy = nvidia.AllocationPolicy
+
+// AllocationRequest is the allocation of virtual GPUs to a container.
+type AllocationRequest = nvidia.AllocationRequest
+
+// Options are the options of Run.
+type Options struct {
+	// Config configures the plugin.
+	Config Config
+	// Backend accesses the GPUs, nil selects the backend of the plugin
+	// from Config: NVML, with its nvidia-smi fallback, or emulated, Tegra
+	// or WSL2 GPUs.
+	Backend Backend
+	// Policies admit every allocation, in order.
+	Policies []AllocationPolicy
+}
+
+// NewNVMLBackend returns the Backend using NVML.
+func NewNVMLBackend() Backend {
+	return nvidia.NewNVMLBackend()
+}
+
+// NewDeviceBackend returns the Backend the plugin selects from config.
+func NewDeviceBackend(config Config) Backend {
+	return nvidia.NewDeviceBackend(config)
+}
+
+// NewMockBackend returns a Backend emulating n GPUs, for tests.
+func NewMockBackend(n int) Backend {
+	return nvidia.NewMockBackend(n)
+}
+
+// Run validates the configuration and runs the plugin until it receives a
+// termination signal.
+func Run(opts Options) error {
+	if err := opts.Config.Validate(); err != nil {
+		return err
+	}
+
+	backend := opts.Backend
+	if backend == nil {
+		backend = nvidia.NewDeviceBackend(opts.Config)
+	}
+	vgm := nvidia.NewVirtualGPUManagerWithBackend(opts.Config, backend)
+	for _, policy := range opts.Policies {
+		vgm.AddAllocationPolicy(policy)
+	}
+	return vgm.Run()
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ClassPolicy maps namespaces or pod labels to the virtual GPU resource
+// classes, e.g. the tiers of the device plugin, their pods may request.
+type ClassPolicy struct {
+	// Classes are the governed virtual GPU resources along with the number of
+	// virtual GPUs of their devices, e.g. {"hkube.io/vgpu-large": 4}.
+	Classes map[string]int64 `json:"classes"`
+	// Rules are matched in order, the first matching rule applies. The pods
+	// matching no rule may request every class.
+	Rules []ClassRule `json:"rules"`
+}
+
+// ClassRule grants resource classes to the pods of a namespace or with the
+// given labels, or both.
+type ClassRule struct {
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Allowed are the classes the pods may request.
+	Allowed []string `json:"allowed"`
+	// Default is the allowed class the requests of the other classes are
+	// rewritten to, keeping at least as many virtual GPUs. The requests are
+	// rejected instead when empty.
+	Default string `json:"default,omitempty"`
+}
+
+// LoadClassPolicy reads the JSON class policy file.
+func LoadClassPolicy(path string) (*ClassPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p ClassPolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid class policy %s: %v", path, err)
+	}
+	for _, r := range p.Rules {
+		for _, class := range append(r.Allowed, r.Default) {
+			if class != "" && p.Classes[class] <= 0 {
+				return nil, fmt.Errorf("invalid class policy %s: unknown class %q", path, class)
+			}
+		}
+		if r.Default != "" && !r.allows(r.Default) {
+			return nil, fmt.Errorf("invalid class policy %s: default class %q is not allowed", path, r.Default)
+		}
+	}
+	return &p, nil
+}
+
+// matches reports whether the rule applies to the pod of the namespace.
+func (r *ClassRule) matches(namespace string, pod *v1.Pod) bool {
+	if r.Namespace != "" && r.Namespace != namespace {
+		return false
+	}
+	for k, v := range r.Labels {
+		if pod.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ClassRule) allows(class string) bool {
+	for _, c := range r.Allowed {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// rule returns the rule applying to the pod, nil when none does.
+func (p *ClassPolicy) rule(namespace string, pod *v1.Pod) *ClassRule {
+	for i := range p.Rules {
+		if p.Rules[i].matches(namespace, pod) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// classPatch returns the patch rewriting the requests of classes the pod is
+// not allowed to the default class of its rule.
+func (wh *Webhook) classPatch(req *admissionv1beta1.AdmissionRequest, pod *v1.Pod) ([]patchOperation, error) {
+	rule := wh.Classes.rule(req.Namespace, pod)
+	if rule == nil || rule.Default == "" {
+		return nil, nil
+	}
+	to := v1.ResourceName(rule.Default)
+
+	var patch []patchOperation
+	for i, c := range pod.Spec.Containers {
+		rewritten := false
+		for name, q := range c.Resources.Limits {
+			from := string(name)
+			size, governed := wh.Classes.Classes[from]
+			if !governed || rule.allows(from) {
+				continue
+			}
+			if _, ok := c.Resources.Limits[to]; ok || rewritten {
+				return nil, fmt.Errorf("container %q requests several classes besides %s", c.Name, to)
+			}
+			rewritten = true
+
+			// Round up so that the container gets at least what it asked for.
+			vGPUs := q.Value() * size
+			n := (vGPUs + wh.Classes.Classes[rule.Default] - 1) / wh.Classes.Classes[rule.Default]
+			quantity := fmt.Sprintf("%d", n)
+			for _, kind := range []string{"limits", "requests"} {
+				list := c.Resources.Limits
+				if kind == "requests" {
+					list = c.Resources.Requests
+				}
+				if _, ok := list[name]; !ok {
+					continue
+				}
+				base := fmt.Sprintf("/spec/containers/%d/resources/%s/", i, kind)
+				patch = append(patch,
+					patchOperation{Op: "remove", Path: base + escapeJSONPointer(from)},
+					patchOperation{Op: "add", Path: base + escapeJSONPointer(rule.Default), Value: quantity})
+			}
+			log.Printf("Pod %s/%s: rewrote %d %s into %s %s on container %q", req.Namespace, podName(pod), q.Value(), from, quantity, rule.Default, c.Name)
+		}
+	}
+	return patch, nil
+}
+
+// validateClasses rejects the pods requesting classes they are not allowed.
+func (wh *Webhook) validateClasses(req *admissionv1beta1.AdmissionRequest, pod *v1.Pod) error {
+	rule := wh.Classes.rule(req.Namespace, pod)
+	if rule == nil {
+		return nil
+	}
+	for _, c := range pod.Spec.Containers {
+		for name := range c.Resources.Limits {
+			if _, governed := wh.Classes.Classes[string(name)]; governed && !rule.allows(string(name)) {
+				return fmt.Errorf("container %q requests %s, pods of namespace %s may only request %v", c.Name, name, req.Namespace, rule.Allowed)
+			}
+		}
+	}
+	return nil
+}
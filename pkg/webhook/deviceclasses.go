@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"fmt"
+	"log"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// DeviceClasses maps the virtual GPU requests of pods to the guaranteed or
+// best-effort device class of the device plugin.
+type DeviceClasses struct {
+	// Guaranteed and BestEffort are the resources of the device classes,
+	// e.g. hkube.io/vgpu-guaranteed and hkube.io/vgpu-best-effort.
+	Guaranteed v1.ResourceName
+	BestEffort v1.ResourceName
+	// PriorityClasses are the priority classes of the pods given guaranteed
+	// virtual GPUs whatever their QoS class.
+	PriorityClasses []string
+}
+
+// guaranteedQOS reports whether the pod is in the Guaranteed QoS class: every
+// container sets limits on CPU and memory, with equal requests. The QoS class
+// is only recorded in the pod status after admission.
+func guaranteedQOS(pod *v1.Pod) bool {
+	containers := append(append([]v1.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, r := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			limit, ok := c.Resources.Limits[r]
+			if !ok {
+				return false
+			}
+			if request, ok := c.Resources.Requests[r]; ok && request.Cmp(limit) != 0 {
+				return false
+			}
+		}
+	}
+	return len(containers) > 0
+}
+
+// deviceClass returns the resource the virtual GPUs of the pod are requested
+// as: the guaranteed class for the pods of the Guaranteed QoS class or of a
+// listed priority class, the best-effort class for the others, and the
+// resource of the plugin when device classes are not used.
+func (wh *Webhook) deviceClass(pod *v1.Pod) v1.ResourceName {
+	if wh.DeviceClasses == nil {
+		return wh.ResourceName
+	}
+	for _, class := range wh.DeviceClasses.PriorityClasses {
+		if pod.Spec.PriorityClassName == class {
+			return wh.DeviceClasses.Guaranteed
+		}
+	}
+	if guaranteedQOS(pod) {
+		return wh.DeviceClasses.Guaranteed
+	}
+	return wh.DeviceClasses.BestEffort
+}
+
+// deviceClassPatch returns the patch moving the virtual GPUs requested by the
+// containers of the pod to its device class.
+func (wh *Webhook) deviceClassPatch(req *admissionv1beta1.AdmissionRequest, pod *v1.Pod) ([]patchOperation, error) {
+	to := wh.deviceClass(pod)
+	from := escapeJSONPointer(string(wh.ResourceName))
+
+	var patch []patchOperation
+	for i, c := range pod.Spec.Containers {
+		q, ok := c.Resources.Limits[wh.ResourceName]
+		if !ok {
+			continue
+		}
+		if _, ok := c.Resources.Limits[to]; ok {
+			return nil, fmt.Errorf("container %q requests both %s and %s", c.Name, wh.ResourceName, to)
+		}
+		for _, kind := range []string{"limits", "requests"} {
+			list := c.Resources.Limits
+			if kind == "requests" {
+				list = c.Resources.Requests
+			}
+			quantity, ok := list[wh.ResourceName]
+			if !ok {
+				continue
+			}
+			base := fmt.Sprintf("/spec/containers/%d/resources/%s/", i, kind)
+			patch = append(patch,
+				patchOperation{Op: "remove", Path: base + from},
+				patchOperation{Op: "add", Path: base + escapeJSONPointer(string(to)), Value: quantity.String()})
+		}
+		log.Printf("Pod %s/%s: moved %d %s to %s on container %q", req.Namespace, podName(pod), q.Value(), wh.ResourceName, to, c.Name)
+	}
+	return patch, nil
+}
+
+// validateDeviceClass rejects the pods requesting guaranteed virtual GPUs
+// without being entitled to them.
+func (wh *Webhook) validateDeviceClass(pod *v1.Pod) error {
+	if wh.deviceClass(pod) == wh.DeviceClasses.Guaranteed {
+		return nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if _, ok := c.Resources.Limits[wh.DeviceClasses.Guaranteed]; ok {
+			return fmt.Errorf("container %q requests %s, only pods of the Guaranteed QoS class or of priority classes %v may", c.Name, wh.DeviceClasses.Guaranteed, wh.DeviceClasses.PriorityClasses)
+		}
+	}
+	return nil
+}
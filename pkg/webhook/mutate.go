@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// MemoryAnnotation requests an amount of GPU memory, e.g. "8Gi", which
+	// is translated into the matching number of virtual GPUs.
+	MemoryAnnotation = "hkube.io/gpu-memory"
+	// ContainerAnnotation names the container receiving the virtual GPUs.
+	// It defaults to the first container of the pod.
+	ContainerAnnotation = "hkube.io/gpu-container"
+)
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// vGPUsForMemory returns the number of virtual GPUs needed to hold memory.
+func (wh *Webhook) vGPUsForMemory(memory string) (int64, error) {
+	q, err := resource.ParseQuantity(memory)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %v", MemoryAnnotation, memory, err)
+	}
+	bytes := q.Value()
+	if bytes <= 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must be positive", MemoryAnnotation, memory)
+	}
+	return (bytes + wh.MemoryPerVGPU - 1) / wh.MemoryPerVGPU, nil
+}
+
+// Mutate translates the GPU memory annotation of a pod into virtual GPU
+// resource requests on the selected container, moves the virtual GPUs to the
+// device class of the pod, and sets the MPS client priority of the containers
+// using virtual GPUs.
+func (wh *Webhook) Mutate(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return denied(err)
+	}
+
+	gpuContainers := make([]bool, len(pod.Spec.Containers))
+	for i, c := range pod.Spec.Containers {
+		_, gpuContainers[i] = c.Resources.Limits[wh.ResourceName]
+		if wh.DeviceClasses != nil {
+			_, guaranteed := c.Resources.Limits[wh.DeviceClasses.Guaranteed]
+			_, bestEffort := c.Resources.Limits[wh.DeviceClasses.BestEffort]
+			gpuContainers[i] = gpuContainers[i] || guaranteed || bestEffort
+		}
+	}
+
+	var patch []patchOperation
+	if memory, ok := pod.Annotations[MemoryAnnotation]; ok {
+		ops, index, err := wh.memoryPatch(req, pod, memory)
+		if err != nil {
+			return denied(err)
+		}
+		patch = append(patch, ops...)
+		gpuContainers[index] = true
+	}
+	if wh.DeviceClasses != nil {
+		ops, err := wh.deviceClassPatch(req, pod)
+		if err != nil {
+			return denied(err)
+		}
+		patch = append(patch, ops...)
+	}
+	if wh.Classes != nil {
+		ops, err := wh.classPatch(req, pod)
+		if err != nil {
+			return denied(err)
+		}
+		patch = append(patch, ops...)
+	}
+	if len(wh.HighPriorityClasses) > 0 {
+		patch = append(patch, wh.priorityPatch(req, pod, gpuContainers)...)
+	}
+	if len(patch) == 0 {
+		return allowed()
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return denied(err)
+	}
+
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     b,
+		PatchType: &patchType,
+	}
+}
+
+// memoryPatch returns the patch adding the virtual GPUs holding memory to the
+// limits of the selected container, along with the container index.
+func (wh *Webhook) memoryPatch(req *admissionv1beta1.AdmissionRequest, pod *v1.Pod, memory string) ([]patchOperation, int, error) {
+	count, err := wh.vGPUsForMemory(memory)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := 0
+	if name, ok := pod.Annotations[ContainerAnnotation]; ok {
+		index = -1
+		for i, c := range pod.Spec.Containers {
+			if c.Name == name {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, 0, fmt.Errorf("container %q from %s annotation not found", name, ContainerAnnotation)
+		}
+	}
+	if index >= len(pod.Spec.Containers) {
+		return nil, 0, fmt.Errorf("pod has no containers")
+	}
+
+	container := pod.Spec.Containers[index]
+	resourceName := wh.deviceClass(pod)
+	for _, name := range []v1.ResourceName{wh.ResourceName, resourceName} {
+		if _, ok := container.Resources.Limits[name]; ok {
+			return nil, 0, fmt.Errorf("container %q sets both %s and the %s annotation", container.Name, name, MemoryAnnotation)
+		}
+	}
+
+	quantity := fmt.Sprintf("%d", count)
+	var patch []patchOperation
+	base := fmt.Sprintf("/spec/containers/%d/resources", index)
+	if container.Resources.Limits == nil {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  base + "/limits",
+			Value: map[string]string{string(resourceName): quantity},
+		})
+	} else {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  base + "/limits/" + escapeJSONPointer(string(resourceName)),
+			Value: quantity,
+		})
+	}
+
+	log.Printf("Pod %s/%s: translated %s=%s into %s virtual GPUs on container %q", req.Namespace, podName(pod), MemoryAnnotation, memory, quantity, container.Name)
+	return patch, index, nil
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// podName returns the name of the pod, which may not be set yet for pods
+// created through a controller.
+func podName(pod *v1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
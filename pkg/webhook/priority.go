@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"fmt"
+	"log"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MPSClientPriorityEnv is read by the CUDA runtime to set the priority of
+	// the MPS client, on drivers whose MPS server supports client priorities.
+	MPSClientPriorityEnv = "CUDA_MPS_CLIENT_PRIORITY"
+
+	// MPSPriorityNormal is the priority of the guaranteed tier.
+	MPSPriorityNormal = "0"
+	// MPSPriorityBelowNormal is the priority of the best-effort tier.
+	MPSPriorityBelowNormal = "1"
+)
+
+// mpsPriority returns the MPS client priority of the pod: normal for the pods
+// of the high priority classes, below normal for the others.
+func (wh *Webhook) mpsPriority(pod *v1.Pod) string {
+	for _, class := range wh.HighPriorityClasses {
+		if pod.Spec.PriorityClassName == class {
+			return MPSPriorityNormal
+		}
+	}
+	return MPSPriorityBelowNormal
+}
+
+// priorityPatch returns the patch setting the MPS client priority of the
+// containers using virtual GPUs. A priority set by the container itself is
+// overwritten so that best-effort pods can not raise their own priority.
+func (wh *Webhook) priorityPatch(req *admissionv1beta1.AdmissionRequest, pod *v1.Pod, gpuContainers []bool) []patchOperation {
+	priority := wh.mpsPriority(pod)
+	env := v1.EnvVar{Name: MPSClientPriorityEnv, Value: priority}
+
+	var patch []patchOperation
+	for i, c := range pod.Spec.Containers {
+		if !gpuContainers[i] {
+			continue
+		}
+
+		base := fmt.Sprintf("/spec/containers/%d/env", i)
+		op := patchOperation{Op: "add", Path: base + "/-", Value: env}
+		if c.Env == nil {
+			op = patchOperation{Op: "add", Path: base, Value: []v1.EnvVar{env}}
+		}
+		for j, e := range c.Env {
+			if e.Name == MPSClientPriorityEnv {
+				op = patchOperation{Op: "replace", Path: fmt.Sprintf("%s/%d", base, j), Value: env}
+			}
+		}
+		patch = append(patch, op)
+	}
+
+	if len(patch) > 0 {
+		log.Printf("Pod %s/%s: set %s=%s for priority class %q", req.Namespace, podName(pod), MPSClientPriorityEnv, priority, pod.Spec.PriorityClassName)
+	}
+	return patch
+}
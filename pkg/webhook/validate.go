@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"fmt"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SingleGPUAnnotation requires every container of the pod to receive all its
+// virtual GPUs from a single physical GPU when set to "true".
+const SingleGPUAnnotation = "hkube.io/single-gpu"
+
+// requiresSingleGPU reports whether the containers of the pod must fit on a
+// single physical GPU.
+func (wh *Webhook) requiresSingleGPU(pod *v1.Pod) bool {
+	return wh.SingleGPU || pod.Annotations[SingleGPUAnnotation] == "true"
+}
+
+// Validate rejects pods requesting virtual GPU classes their namespace is not
+// allowed, pods requesting guaranteed virtual GPUs they are not entitled to,
+// and pods with a container requesting more virtual GPUs than a single
+// physical GPU provides, which would otherwise stay pending forever.
+func (wh *Webhook) Validate(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return denied(err)
+	}
+
+	if wh.Classes != nil {
+		if err := wh.validateClasses(req, pod); err != nil {
+			return denied(err)
+		}
+	}
+
+	if wh.DeviceClasses != nil {
+		if err := wh.validateDeviceClass(pod); err != nil {
+			return denied(err)
+		}
+	}
+
+	if wh.VGPUsPerGPU <= 0 || !wh.requiresSingleGPU(pod) {
+		return allowed()
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, name := range []v1.ResourceName{wh.ResourceName, wh.deviceClass(pod)} {
+			q, ok := c.Resources.Limits[name]
+			if !ok {
+				continue
+			}
+			if n := q.Value(); n > wh.VGPUsPerGPU {
+				return denied(fmt.Errorf("container %q requests %d %s but a single physical GPU only provides %d", c.Name, n, name, wh.VGPUsPerGPU))
+			}
+		}
+	}
+	return allowed()
+}
@@ -0,0 +1,97 @@
+// Package webhook implements the admission webhooks that translate and check
+// virtual GPU requests of pods before they reach the scheduler.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Webhook holds the cluster policy applied by the admission webhooks.
+type Webhook struct {
+	// ResourceName is the resource advertised by the virtual GPU device plugin.
+	ResourceName v1.ResourceName
+	// MemoryPerVGPU is the GPU memory, in bytes, backing one virtual GPU.
+	MemoryPerVGPU int64
+	// VGPUsPerGPU is the number of virtual GPUs exposed for every physical
+	// GPU. Zero disables the per-container validation.
+	VGPUsPerGPU int64
+	// SingleGPU requires the virtual GPUs of every container to come from a
+	// single physical GPU.
+	SingleGPU bool
+	// HighPriorityClasses are the priority classes of the pods sharing GPUs
+	// with a normal MPS client priority, the pods of the other classes get a
+	// below normal priority. MPS priorities are not set when empty.
+	HighPriorityClasses []string
+	// Classes restricts the virtual GPU resources pods may request by
+	// namespace or labels. Every resource is allowed when nil.
+	Classes *ClassPolicy
+	// DeviceClasses moves the virtual GPUs requested as ResourceName to the
+	// guaranteed or best-effort device class of the pod. The requests are
+	// left untouched when nil.
+	DeviceClasses *DeviceClasses
+}
+
+type admitFunc func(*admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse
+
+// Handler returns the HTTP handler serving the admission endpoints.
+func (wh *Webhook) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", serve(wh.Mutate))
+	mux.HandleFunc("/validate", serve(wh.Validate))
+	return mux
+}
+
+// serve decodes the AdmissionReview of the request, runs admit on it and
+// writes back the review with the response.
+func serve(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var review admissionv1beta1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		response := admit(review.Request)
+		response.UID = review.Request.UID
+		review.Response = response
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&review); err != nil {
+			log.Printf("Failed to encode admission review: %v", err)
+		}
+	}
+}
+
+// decodePod reads the pod under admission.
+func decodePod(req *admissionv1beta1.AdmissionRequest) (*v1.Pod, error) {
+	if req.Kind.Kind != "Pod" {
+		return nil, fmt.Errorf("unexpected kind %s", req.Kind.Kind)
+	}
+
+	var pod v1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
+
+func allowed() *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func denied(err error) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/gpu/nvidia"
+	"github.com/awslabs/aws-virtual-gpu-device-plugin/pkg/kubelettest"
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// soakCommand is the hidden subcommand running the soak test.
+const soakCommand = "soak"
+
+// soak runs the device plugin on mock GPUs against a fake kubelet and loops
+// watch and allocation cycles, with Xid errors and reloads now and then, for
+// hours. It reports the goroutine count and heap size as it goes and fails
+// when they grow beyond the given bounds, which reveals leaks in the health
+// and allocation paths.
+func soak(args []string) error {
+	fs := flag.NewFlagSet(soakCommand, flag.ExitOnError)
+	duration := fs.Duration("duration", 4*time.Hour, "How long to run the soak test")
+	report := fs.Duration("report-interval", time.Minute, "Interval between two goroutine and memory reports")
+	gpus := fs.Int("gpus", 2, "Number of mock physical GPUs")
+	vGPU := fs.Int("vgpu", 10, "Number of virtual GPUs per physical GPU")
+	reloadEvery := fs.Int("reload-every", 1000, "Cycles between two Xid errors followed by a reload of the plugin")
+	maxGoroutines := fs.Int("max-goroutine-growth", 50, "Fail when the goroutine count grows more than this since the first report")
+	maxHeap := fs.Uint64("max-heap-growth-mb", 64, "Fail when the heap grows more than this many MiB since the first report")
+	fs.Parse(args)
+
+	dir, err := ioutil.TempDir("", "vgpu-soak")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	kubelet, err := kubelettest.New(dir)
+	if err != nil {
+		return err
+	}
+	defer kubelet.Stop()
+
+	faults := filepath.Join(dir, "faults.sock")
+	config := nvidia.Config{
+		DevicePluginPath:       dir,
+		VGPUCount:              *vGPU,
+		DriverRoot:             nvidia.DefaultDriverRoot,
+		FakeGPUs:               uint(*gpus),
+		DevicePermissions:      nvidia.DefaultDevicePermissions,
+		DeviceProfile:          nvidia.DeviceProfileDefault,
+		ResponseTemplates:      nvidia.DefaultResponseTemplates,
+		ComputeEnforcement:     nvidia.ComputeEnforcementNone,
+		MemoryQuotaEnforcement: nvidia.MemoryQuotaNone,
+		Persistence:            nvidia.PersistenceNone,
+		DrainTimeout:           time.Second,
+		AllocateTimeout:        time.Second,
+		SequentialDeviceIDs:    true,
+		FaultInjectionAddress:  "unix:" + faults,
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	backend := nvidia.NewMockBackend(*gpus)
+	go func() {
+		err := nvidia.NewVirtualGPUManagerWithBackend(config, backend).Run()
+		log.Fatalf("Device plugin stopped: %v", err)
+	}()
+
+	faultsClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", faults)
+			},
+		},
+	}
+
+	log.Printf("Soaking the device plugin for %s.", *duration)
+	var (
+		cycles, failures int
+		baseGoroutines   int
+		baseHeap         uint64
+		deadline         = time.Now().Add(*duration)
+		nextReport       = time.Now().Add(*report)
+	)
+	for time.Now().Before(deadline) {
+		if cycles%*reloadEvery == 0 {
+			if cycles > 0 {
+				uuid := backend.GPUs[rand.Intn(*gpus)].UUID
+				if resp, err := faultsClient.Post("http://plugin/faults/xid?xid=79&uuid="+uuid, "text/plain", nil); err == nil {
+					resp.Body.Close()
+				}
+				syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			}
+			if _, err := kubelet.WaitForRegistration(30 * time.Second); err != nil {
+				return err
+			}
+		}
+
+		if err := soakCycle(kubelet); err != nil {
+			failures++
+		}
+		cycles++
+
+		if time.Now().Before(nextReport) {
+			continue
+		}
+		nextReport = time.Now().Add(*report)
+
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		goroutines := runtime.NumGoroutine()
+		log.Printf("cycles=%d failures=%d goroutines=%d heap=%dKiB", cycles, failures, goroutines, m.HeapAlloc/1024)
+
+		if baseGoroutines == 0 {
+			baseGoroutines, baseHeap = goroutines, m.HeapAlloc
+			continue
+		}
+		if goroutines > baseGoroutines+*maxGoroutines {
+			log.Fatalf("Goroutine leak: %d goroutines, %d on the first report", goroutines, baseGoroutines)
+		}
+		if m.HeapAlloc > baseHeap+*maxHeap<<20 {
+			log.Fatalf("Memory leak: heap of %dKiB, %dKiB on the first report", m.HeapAlloc/1024, baseHeap/1024)
+		}
+	}
+
+	log.Printf("Soak test passed: %d cycles, %d failures.", cycles, failures)
+	return nil
+}
+
+// soakCycle connects to the plugin as kubelet does, watches its devices and
+// allocates a random healthy one, as for a container reusing the virtual GPU
+// of a terminated one.
+func soakCycle(kubelet *kubelettest.Kubelet) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, conn, err := kubelet.Dial(ctx, "hkube-vgpu.sock")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		return err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	var healthy []string
+	for _, d := range resp.Devices {
+		if d.Health == pluginapi.Healthy {
+			healthy = append(healthy, d.ID)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	_, err = client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{healthy[rand.Intn(len(healthy))]}}},
+	})
+	return err
+}